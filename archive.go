@@ -0,0 +1,222 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// handleArchive writes a remote directory tree into a tar or zip archive as
+// it is read from the device, so the files are never unpacked locally first.
+// Without a local file, or with -, the archive goes to stdout, which then
+// carries nothing else.
+func (c *CLI) handleArchive(args []string) error {
+	fs := flag.NewFlagSet("archive", flag.ContinueOnError)
+	format := fs.String("format", "", "archive format: tar or zip (default zip for .zip files, tar otherwise)")
+	skipHidden := fs.Bool("skip-hidden", false, "leave hidden objects and their contents out")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) < 1 {
+		return usagef("archive requires a remote directory")
+	}
+	target := "-"
+	if len(args) > 1 {
+		target = args[1]
+	}
+	if *format == "" {
+		*format = "tar"
+		if strings.EqualFold(filepath.Ext(target), ".zip") {
+			*format = "zip"
+		}
+	}
+	if *format != "tar" && *format != "zip" {
+		return usagef("invalid --format %q: must be tar or zip", *format)
+	}
+
+	remote, err := c.resolveRemote(args[0])
+	if err != nil {
+		return err
+	}
+	root, err := c.objectFromPath(remote)
+	if err != nil {
+		return err
+	}
+	if !root.IsDir {
+		return fmt.Errorf("%s is not a directory", root.FullPath)
+	}
+
+	// the tree is listed before the first file is read, as an MTP session
+	// can't list and transfer at the same time. The directory itself is the
+	// top entry, unless it is the storage root.
+	var objects []*mtpx.FileInfo
+	if path.Clean(root.FullPath) != "/" {
+		objects = append(objects, root)
+	}
+	_, _, _, err = c.walk(root.FullPath, true, true, *skipHidden,
+		func(objectId uint32, fi *mtpx.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			objects = append(objects, fi)
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+
+	a := &archiver{cli: c, format: *format, root: root, report: target != "-" || progressOut != os.Stdout}
+
+	if target == "-" {
+		rawStdout = true
+		w := bufio.NewWriter(os.Stdout)
+		if err := a.write(w, objects); err != nil {
+			return err
+		}
+		return w.Flush()
+	}
+
+	local, err := filepath.Abs(target)
+	if err != nil {
+		return fmt.Errorf("invalid local path: %w", err)
+	}
+	f, err := os.Create(local + partSuffix)
+	if err != nil {
+		return fmt.Errorf("failed to create local file: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	err = a.write(w, objects)
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(local+partSuffix, local)
+	}
+	if err != nil {
+		os.Remove(local + partSuffix)
+		return err
+	}
+
+	printJSON(map[string]interface{}{
+		"path":        root.FullPath,
+		"target":      local,
+		"format":      a.format,
+		"files":       a.files,
+		"directories": a.dirs,
+		"size":        a.size,
+	})
+
+	printDone("MTPX_ARCHIVE_DONE")
+	return nil
+}
+
+// archiver writes remote objects into an archive, named below the base name
+// of root
+type archiver struct {
+	cli    *CLI
+	format string
+	root   *mtpx.FileInfo
+
+	// report sends per-file progress, which stays off stdout while the
+	// archive is written there
+	report bool
+
+	files, dirs, size int64
+}
+
+// write archives objects, which are below the root in walk order, to w
+func (a *archiver) write(w io.Writer, objects []*mtpx.FileInfo) error {
+	var tw *tar.Writer
+	var zw *zip.Writer
+	if a.format == "zip" {
+		zw = zip.NewWriter(w)
+	} else {
+		tw = tar.NewWriter(w)
+	}
+
+	prefix := path.Base(a.root.FullPath)
+
+	for _, fi := range objects {
+		if err := canceled(); err != nil {
+			return err
+		}
+		name := path.Join(prefix, strings.TrimPrefix(fi.FullPath, a.root.FullPath))
+		name = strings.TrimPrefix(name, "/")
+		if name == "" {
+			continue
+		}
+
+		var dst io.Writer
+		var err error
+		switch {
+		case tw != nil && fi.IsDir:
+			err = tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: name + "/", Mode: 0o755, ModTime: fi.ModTime})
+		case tw != nil:
+			err = tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o644, Size: fi.Size, ModTime: fi.ModTime})
+			dst = tw
+		case fi.IsDir:
+			hdr := &zip.FileHeader{Name: name + "/", Modified: fi.ModTime}
+			hdr.SetMode(os.ModeDir | 0o755)
+			_, err = zw.CreateHeader(hdr)
+		default:
+			hdr := &zip.FileHeader{Name: name, Modified: fi.ModTime, Method: zip.Deflate}
+			hdr.SetMode(0o644)
+			dst, err = zw.CreateHeader(hdr)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to add %s to the archive: %w", fi.FullPath, err)
+		}
+
+		if fi.IsDir {
+			a.dirs++
+			continue
+		}
+		if err := a.copyFile(fi, name, dst); err != nil {
+			return err
+		}
+		a.files++
+		a.size += fi.Size
+	}
+
+	if zw != nil {
+		return zw.Close()
+	}
+	return tw.Close()
+}
+
+// copyFile reads the remote file fi into the archive entry dst
+func (a *archiver) copyFile(fi *mtpx.FileInfo, name string, dst io.Writer) error {
+	c := a.cli
+	if a.report {
+		progress.begin(fi.ObjectId)
+	}
+	start := time.Now()
+	err := c.device.GetObject(fi.ObjectId, dst, func(sent int64) error {
+		if a.report {
+			return progress.update(fi.ObjectId, fi.Name, fi.FullPath, sent, fi.Size)
+		}
+		return checkTransfer(start)
+	})
+	if err != nil {
+		return transferFailed(fmt.Errorf("failed to read %s: %w", fi.FullPath, err))
+	}
+	if a.report {
+		progress.complete(fi.ObjectId, fi.Name, fi.FullPath, name, fi.Size)
+	}
+	return nil
+}
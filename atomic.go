@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+
+	"github.com/ganeshrvel/go-mtpfs/mtp"
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// tempPrefix starts the name an upload is sent under until it is complete
+const tempPrefix = ".mtpxtmp-"
+
+// tempName returns the name to send an upload of name under. It is name
+// itself with --no-temp-names or when the device can't rename objects, which
+// is warned about once.
+func (c *CLI) tempName(name string) string {
+	if *noTempNames || c.noRename {
+		return name
+	}
+	if !c.supportsProp(mtp.OFC_Undefined, mtp.OPC_ObjectFileName) {
+		c.noRename = true
+		log.Print("warning: the device can't rename objects, uploads are sent under their final names")
+		return name
+	}
+	return tempPrefix + name
+}
+
+// commitUpload gives the object handle, sent under tempName, its final name
+// in remoteDir once its size on the device is size. An existing file of the
+// final name is only replaced at this point, so a failed upload leaves it
+// alone. A size mismatch deletes the object.
+func (c *CLI) commitUpload(handle uint32, remoteDir, tempName, name string, size int64) error {
+	if tempName == name {
+		return nil
+	}
+
+	remote := path.Join(remoteDir, name)
+	fi, err := mtpx.GetObjectFromObjectId(c.device, handle, remoteDir)
+	if err != nil {
+		return fmt.Errorf("failed to check %s: %w", remote, err)
+	}
+	if fi.Size != size {
+		c.device.DeleteObject(handle)
+		return fmt.Errorf("failed to upload %s: the device holds %d of %d bytes", remote, fi.Size, size)
+	}
+
+	existing, err := c.objectFromPath(remote)
+	switch {
+	case err == nil:
+		if err := c.device.DeleteObject(existing.ObjectId); err != nil {
+			return fmt.Errorf("failed to replace %s: %w", remote, err)
+		}
+	case !isNotFound(err):
+		return err
+	}
+
+	if _, err := mtpx.RenameFile(c.device, c.storage, mtpx.FileProp{ObjectId: handle}, name); err != nil {
+		return fmt.Errorf("failed to rename %s to %s: %w", path.Join(remoteDir, tempName), name, err)
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// snapshotDir is the directory of a backup that holds its manifest snapshots,
+// one per run, named by the UTC time of the run
+const snapshotDir = ".mtpx-backup"
+
+// Changes of a file since the last backup
+const (
+	changeNew       = "new"
+	changeChanged   = "changed"
+	changeRemoved   = "removed"
+	changeUnchanged = "unchanged"
+)
+
+func (c *CLI) handleBackup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	skipHidden := fs.Bool("skip-hidden", false, "don't back up hidden objects and their contents")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) < 2 {
+		return usagef("backup requires remote path and local dir")
+	}
+
+	localDir, err := filepath.Abs(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid local path: %w", err)
+	}
+
+	root, err := c.objectFromPath(remotePath(args[0]))
+	if err != nil {
+		return err
+	}
+	if !root.IsDir {
+		return usagef("%s is not a directory", root.FullPath)
+	}
+
+	prev, prevFile, err := latestSnapshot(localDir)
+	if err != nil {
+		return err
+	}
+	id, err := c.deviceIdentity()
+	if err != nil {
+		return err
+	}
+	last := map[string]manifestEntry{}
+	if prev != nil {
+		if prev.Root != root.FullPath {
+			return usagef("%s holds a backup of %s, not %s", localDir, prev.Root, root.FullPath)
+		}
+		if prev.Fingerprint != id.Fingerprint {
+			log.Printf("warning: the last backup in %s was taken from another device", localDir)
+		}
+		for _, e := range prev.Entries {
+			last[e.Path] = e
+		}
+	}
+
+	if err := makeLocalDir(localDir); err != nil {
+		return err
+	}
+
+	var objects []*mtpx.FileInfo
+	hidden := newHiddenFilter(c)
+	_, _, _, err = c.walk(root.FullPath, true, true, *skipHidden,
+		func(objectId uint32, fi *mtpx.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if *skipHidden && hidden.skip(fi) {
+				return nil
+			}
+			objects = append(objects, fi)
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+
+	transfers.begin()
+	d := &downloader{cli: c, limiter: newRateLimiter(0), skipExisting: true, compare: compareSizeMtime}
+	entries := make([]manifestEntry, 0, len(objects))
+	counts := map[string]int64{}
+	var size int64
+	for _, fi := range objects {
+		rel, ok := relRemotePath(root.FullPath, fi.FullPath)
+		if !ok {
+			continue
+		}
+		localPath := filepath.Join(localDir, filepath.FromSlash(localRel(rel)))
+		entry := newManifestEntry(fi)
+		prevEntry, seen := last[fi.FullPath]
+		delete(last, fi.FullPath)
+
+		if fi.IsDir {
+			if err := makeLocalDir(localPath); err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+			continue
+		}
+
+		change := backupChange(fi, localPath, prevEntry, seen)
+		counts[change]++
+		size += fi.Size
+		if change == changeUnchanged {
+			entry.SHA256 = prevEntry.SHA256
+		} else {
+			printJSON(map[string]interface{}{"path": fi.FullPath, "change": change})
+			if err := d.downloadFile(fi, localPath); err != nil {
+				return err
+			}
+		}
+
+		if entry.SHA256 == "" && !*dryRun {
+			digest, err := localDigest(localPath, hashSHA256)
+			if err != nil {
+				return fmt.Errorf("failed to hash %s: %w", localPath, err)
+			}
+			entry.SHA256 = hex.EncodeToString(digest)
+		}
+		entries = append(entries, entry)
+	}
+
+	// what is left of the last snapshot is gone from the device, the local
+	// copies are kept
+	for _, p := range sortedManifestPaths(last) {
+		if last[p].Type == "file" {
+			counts[changeRemoved]++
+			printJSON(map[string]interface{}{"path": p, "change": changeRemoved})
+		}
+	}
+
+	snapshot := ""
+	if !*dryRun {
+		snapshot, err = writeSnapshot(localDir, root.FullPath, id.Fingerprint, entries)
+		if err != nil {
+			return err
+		}
+	}
+
+	printJSON(map[string]interface{}{
+		"snapshot":  snapshot,
+		"previous":  prevFile,
+		"new":       counts[changeNew],
+		"changed":   counts[changeChanged],
+		"unchanged": counts[changeUnchanged],
+		"removed":   counts[changeRemoved],
+		"size":      size,
+	})
+	transfers.printSummary("backup")
+
+	printDone("MTPX_BACKUP_DONE")
+	return nil
+}
+
+// backupChange tells how fi changed since the last snapshot, which recorded
+// prev if seen. An unchanged file whose local copy is missing or has another
+// size counts as changed, so it is downloaded again.
+func backupChange(fi *mtpx.FileInfo, localPath string, prev manifestEntry, seen bool) string {
+	if !seen || prev.Type != "file" {
+		return changeNew
+	}
+	if prev.Size != fi.Size || !prev.Mtime.Truncate(time.Second).Equal(fi.ModTime.Truncate(time.Second)) {
+		return changeChanged
+	}
+	if st, err := os.Stat(localPath); err != nil || st.Size() != fi.Size {
+		return changeChanged
+	}
+	return changeUnchanged
+}
+
+// snapshots returns the manifest snapshots of the backup in localDir, oldest
+// first
+func snapshots(localDir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(localDir, snapshotDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// latestSnapshot reads the newest snapshot of the backup in localDir, nil when
+// there is none yet
+func latestSnapshot(localDir string) (*manifestFile, string, error) {
+	files, err := snapshots(localDir)
+	if err != nil || len(files) == 0 {
+		return nil, "", err
+	}
+	file := files[len(files)-1]
+	m, err := readManifest(file)
+	if err != nil {
+		return nil, "", err
+	}
+	return m, file, nil
+}
+
+// writeSnapshot writes entries as a new snapshot of the backup in localDir.
+// The file only gets its name once complete, so a failed run leaves the last
+// snapshot the newest.
+func writeSnapshot(localDir, root, fingerprint string, entries []manifestEntry) (string, error) {
+	dir := filepath.Join(localDir, snapshotDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	file := filepath.Join(dir, time.Now().UTC().Format("20060102T150405Z")+".json")
+	m, err := createManifest(file+partSuffix, root, fingerprint)
+	if err != nil {
+		return "", err
+	}
+	for _, e := range entries {
+		if err := m.add(e); err != nil {
+			m.f.Close()
+			return "", err
+		}
+	}
+	if err := m.close(); err != nil {
+		return "", err
+	}
+	return file, os.Rename(file+partSuffix, file)
+}
+
+func sortedManifestPaths(m map[string]manifestEntry) []string {
+	paths := make([]string, 0, len(m))
+	for p := range m {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// batchRequest is a JSON encoded batch line
+type batchRequest struct {
+	ID      json.RawMessage `json:"id"`
+	Command string          `json:"command"`
+	Args    []string        `json:"args"`
+}
+
+// handleBatch runs the commands read from stdin, one per line, on the open
+// session. A line is either a JSON object like {"id": "a", "command": "stat",
+// "args": ["/DCIM"]} or words as in the shell; blank lines and # comments are
+// skipped. Every output line of a command is tagged with its id, which
+// defaults to the line number.
+func (c *CLI) handleBatch(args []string) error {
+	fs := flag.NewFlagSet("batch", flag.ContinueOnError)
+	stopOnError := fs.Bool("stop-on-error", false, "stop at the first failing command instead of running the rest")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return usagef("batch takes no arguments")
+	}
+
+	var run, failed int
+	for n := 1; ; n++ {
+		line, err := stdin.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			break
+		}
+		if trimmed := strings.TrimSpace(line); trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		run++
+		if err := c.runBatchLine(n, line); err != nil {
+			failed++
+			if *stopOnError {
+				break
+			}
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d batch commands failed", failed, run)
+	}
+	printDone("MTPX_BATCH_DONE")
+	return nil
+}
+
+// runBatchLine runs the command on line n with its output tagged
+func (c *CLI) runBatchLine(n int, line string) error {
+	req := batchRequest{ID: json.RawMessage(strconv.Itoa(n))}
+
+	var err error
+	if trimmed := strings.TrimSpace(line); strings.HasPrefix(trimmed, "{") {
+		err = json.Unmarshal([]byte(trimmed), &req)
+		if err == nil && req.Command == "" {
+			err = fmt.Errorf("missing command")
+		}
+		if len(req.ID) == 0 || string(req.ID) == "null" {
+			req.ID = json.RawMessage(strconv.Itoa(n))
+		}
+	} else {
+		var words []string
+		words, err = splitShellLine(line)
+		if err == nil {
+			req.Command, req.Args = words[0], words[1:]
+		}
+	}
+
+	outputMu.Lock()
+	prevResult, prevProgress := resultOut, progressOut
+	resultOut = &taggedWriter{w: prevResult, id: req.ID}
+	progressOut = &taggedWriter{w: prevProgress, id: req.ID}
+	outputMu.Unlock()
+
+	defer func() {
+		outputMu.Lock()
+		resultOut, progressOut = prevResult, prevProgress
+		outputMu.Unlock()
+	}()
+
+	if err != nil {
+		err = usagef("line %d: %v", n, err)
+	} else if req.Command == "batch" {
+		err = usagef("batch can't be nested")
+	} else {
+		err = c.runShellCommand(req.Command, req.Args)
+	}
+	if err != nil {
+		printError(err)
+		log.Print(err)
+		// the session goes stale when the device sleeps or is replugged
+		c.reconnectIfDead()
+	}
+	return err
+}
+
+// taggedWriter adds an "id" field to every JSON object line written to it.
+// Other lines, such as the sentinels of --legacy-output, pass unchanged.
+type taggedWriter struct {
+	w  io.Writer
+	id json.RawMessage
+}
+
+func (t *taggedWriter) Write(p []byte) (int, error) {
+	if !bytes.HasPrefix(p, []byte("{")) {
+		return t.w.Write(p)
+	}
+
+	tagged := append([]byte(`{"id":`), t.id...)
+	if !bytes.HasPrefix(p, []byte("{}")) {
+		tagged = append(tagged, ',')
+	}
+	tagged = append(tagged, p[1:]...)
+	if _, err := t.w.Write(tagged); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path"
+	"time"
+
+	"github.com/ganeshrvel/go-mtpfs/mtp"
+)
+
+// Directions of benchmark --direction
+const (
+	benchmarkUp   = "up"
+	benchmarkDown = "down"
+	benchmarkBoth = "both"
+)
+
+// benchmarkInterval is the window the throughput is sampled over for its
+// variation
+const benchmarkInterval = 500 * time.Millisecond
+
+// handleBenchmark measures the round trip of a small MTP operation, then
+// uploads a temporary file of random data into a remote directory and reads
+// it back, reporting the sustained throughput of each direction and how much
+// it varied. The file is deleted afterwards, also when the run fails. Neither
+// --bwlimit nor --max-rate apply, the point is to measure the link.
+func (c *CLI) handleBenchmark(args []string) error {
+	fs := flag.NewFlagSet("benchmark", flag.ContinueOnError)
+	sizeStr := fs.String("size", "256M", "size of the test file, such as 64M or 1G")
+	direction := fs.String("direction", benchmarkBoth, "what to measure: up, down or both")
+	runs := fs.Int("latency-runs", 20, "number of operations timed for the latency")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	size, err := parseSize(*sizeStr)
+	if err != nil || size < 1 {
+		return usagef("invalid --size %q: must be a size like 64M or 1G", *sizeStr)
+	}
+	if *direction != benchmarkUp && *direction != benchmarkDown && *direction != benchmarkBoth {
+		return usagef("invalid --direction %q: must be %s, %s or %s", *direction, benchmarkUp, benchmarkDown, benchmarkBoth)
+	}
+	if *runs < 1 {
+		return usagef("invalid --latency-runs %d: must be at least 1", *runs)
+	}
+	dir := "/"
+	if len(args) > 0 {
+		if dir, err = c.resolveRemote(args[0]); err != nil {
+			return err
+		}
+	}
+	name := fmt.Sprintf(".mtpx-benchmark-%d", os.Getpid())
+
+	if *dryRun {
+		return printPlanned("benchmark", map[string]interface{}{"target": path.Join(dir, name), "size": size})
+	}
+
+	if err := c.benchmarkLatency(*runs); err != nil {
+		return err
+	}
+	if err := c.checkFreeSpace(size, false); err != nil {
+		return err
+	}
+
+	// the file is uploaded for a download-only run too, untimed
+	u := &uploader{cli: c}
+	handle, err := u.createObject(dir, name, size, time.Now())
+	if err != nil {
+		return err
+	}
+	defer c.device.DeleteObject(handle)
+
+	up := newThroughputSampler()
+	start := time.Now()
+	err = c.device.SendObject(io.LimitReader(rand.New(rand.NewSource(start.UnixNano())), size), size, func(sent int64) error {
+		up.update(sent)
+		return checkTransfer(start)
+	})
+	if err != nil {
+		return transferFailed(fmt.Errorf("failed to upload the test file: %w", err))
+	}
+	if *direction != benchmarkDown {
+		printJSON(up.result(benchmarkUp, size))
+	}
+
+	if *direction != benchmarkUp {
+		down := newThroughputSampler()
+		start := time.Now()
+		err = c.device.GetObject(handle, io.Discard, func(sent int64) error {
+			down.update(sent)
+			return checkTransfer(start)
+		})
+		if err != nil {
+			return transferFailed(fmt.Errorf("failed to download the test file: %w", err))
+		}
+		printJSON(down.result(benchmarkDown, size))
+	}
+
+	printDone("MTPX_BENCHMARK_DONE")
+	return nil
+}
+
+// benchmarkLatency times runs GetStorageInfo requests, which carry little
+// data, so they take about one USB round trip each
+func (c *CLI) benchmarkLatency(runs int) error {
+	samples := make([]float64, 0, runs)
+	for i := 0; i < runs; i++ {
+		if err := canceled(); err != nil {
+			return err
+		}
+		var info mtp.StorageInfo
+		start := time.Now()
+		if err := c.device.GetStorageInfo(c.storage, &info); err != nil {
+			return fmt.Errorf("failed to read the storage info: %w", err)
+		}
+		samples = append(samples, float64(time.Since(start).Microseconds())/1000)
+	}
+
+	mean, stddev, lo, hi := sampleStats(samples)
+	return printJSON(map[string]interface{}{
+		"operation": "latency",
+		"runs":      runs,
+		"avg_ms":    round2(mean),
+		"min_ms":    round2(lo),
+		"max_ms":    round2(hi),
+		"stddev_ms": round2(stddev),
+	})
+}
+
+// throughputSampler measures a transfer from its progress callbacks: the
+// sustained throughput of the whole transfer, and the throughput of each
+// benchmarkInterval for how steady it was
+type throughputSampler struct {
+	start, last time.Time
+	lastSent    int64
+	samples     []float64
+}
+
+// newThroughputSampler starts measuring a transfer about to begin
+func newThroughputSampler() *throughputSampler {
+	now := time.Now()
+	return &throughputSampler{start: now, last: now}
+}
+
+func (s *throughputSampler) update(sent int64) {
+	now := time.Now()
+	if elapsed := now.Sub(s.last); elapsed >= benchmarkInterval {
+		s.samples = append(s.samples, float64(sent-s.lastSent)/elapsed.Seconds())
+		s.last, s.lastSent = now, sent
+	}
+}
+
+// result is the entry of a finished transfer of size bytes
+func (s *throughputSampler) result(direction string, size int64) map[string]interface{} {
+	elapsed := time.Since(s.start)
+	throughput := int64(0)
+	if elapsed > 0 {
+		throughput = int64(float64(size) / elapsed.Seconds())
+	}
+	entry := map[string]interface{}{
+		"operation":        direction,
+		"size":             size,
+		"elapsed":          elapsed.Round(time.Millisecond).String(),
+		"elapsed_seconds":  elapsed.Seconds(),
+		"throughput":       throughput,
+		"throughput_human": humanReadableSize(throughput) + "/s",
+		"samples":          len(s.samples),
+	}
+	if len(s.samples) > 0 {
+		mean, stddev, lo, hi := sampleStats(s.samples)
+		entry["min_throughput"] = int64(lo)
+		entry["max_throughput"] = int64(hi)
+		entry["stddev_throughput"] = int64(stddev)
+		if mean > 0 {
+			entry["variation_percent"] = round2(stddev / mean * 100)
+		}
+	}
+	return entry
+}
+
+// sampleStats returns the mean, standard deviation, minimum and maximum of
+// samples, which must not be empty
+func sampleStats(samples []float64) (mean, stddev, lo, hi float64) {
+	lo, hi = samples[0], samples[0]
+	for _, v := range samples {
+		mean += v
+		lo, hi = math.Min(lo, v), math.Max(hi, v)
+	}
+	mean /= float64(len(samples))
+	for _, v := range samples {
+		stddev += (v - mean) * (v - mean)
+	}
+	stddev = math.Sqrt(stddev / float64(len(samples)))
+	return mean, stddev, lo, hi
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
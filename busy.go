@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/ganeshrvel/usb"
+)
+
+// stealWait is how long --steal waits for stopped MTP clients to let go of
+// the interface
+const stealWait = 5 * time.Second
+
+// interfaceBusyError is a device that failed to open while its MTP interface
+// was claimed by a kernel driver or another program
+type interfaceBusyError struct {
+	iface   *mtpInterface
+	driver  string
+	clients []mtpClient
+	err     error
+}
+
+func (e interfaceBusyError) Error() string {
+	if e.iface.kernelDriver {
+		holder := "a kernel driver"
+		if e.driver != "" {
+			holder = "kernel driver " + e.driver
+		}
+		return fmt.Sprintf("the MTP interface of %s is claimed by %s: unload it, or rerun with --steal to detach it (%v)",
+			e.iface.vidPid(), holder, e.err)
+	}
+	holder := "another program"
+	if len(e.clients) > 0 {
+		holder += ", likely " + joinClients(e.clients)
+	}
+	return fmt.Sprintf("the MTP interface of %s is claimed by %s: %s, or rerun with --steal to stop it (%v)",
+		e.iface.vidPid(), holder, mtpClientHint(e.clients), e.err)
+}
+
+func (e interfaceBusyError) Unwrap() error { return e.err }
+
+// busyInterfaces probes the MTP interfaces before a device is opened and
+// returns those that can't be claimed. With --steal they are freed first, see
+// stealInterfaces. A failed probe is left to the open that follows.
+func busyInterfaces() []*mtpInterface {
+	ctx := usb.NewContext()
+	defer ctx.Exit()
+
+	ifaces, err := probeMTPInterfaces(ctx, *steal)
+	if err != nil {
+		return nil
+	}
+	for _, m := range ifaces {
+		if m.detached != "" {
+			printJSON(map[string]string{
+				"event":  "interface_stolen",
+				"device": m.vidPid(),
+				"from":   m.detached,
+			})
+		}
+	}
+	busy := claimedInterfaces(ifaces)
+	if *steal && len(busy) > 0 {
+		busy = stealInterfaces(ctx, busy)
+	}
+	return busy
+}
+
+// claimedInterfaces returns the interfaces held by a kernel driver or another
+// program
+func claimedInterfaces(ifaces []*mtpInterface) []*mtpInterface {
+	var busy []*mtpInterface
+	for _, m := range ifaces {
+		if m.openErr == nil && (m.kernelDriver || errors.Is(m.claimErr, usb.ERROR_BUSY)) {
+			busy = append(busy, m)
+		}
+	}
+	return busy
+}
+
+// stealInterfaces terminates the running MTP clients, which are the likely
+// holders of the busy interfaces, and waits up to stealWait for the
+// interfaces to be free. Only processes of the user can be stopped, others
+// are logged. It returns the interfaces that are still busy.
+func stealInterfaces(ctx *usb.Context, busy []*mtpInterface) []*mtpInterface {
+	clients := mtpClients()
+	if len(clients) == 0 {
+		return busy
+	}
+	for _, c := range clients {
+		p, err := os.FindProcess(c.Pid)
+		if err == nil {
+			err = p.Signal(syscall.SIGTERM)
+		}
+		if err != nil {
+			log.Printf("warning: failed to stop %s: %v", c, err)
+		}
+	}
+
+	deadline := time.Now().Add(stealWait)
+	for {
+		time.Sleep(devicePoll)
+		ifaces, err := probeMTPInterfaces(ctx, false)
+		if err != nil {
+			return busy
+		}
+		still := claimedInterfaces(ifaces)
+		if len(still) > 0 && time.Now().Before(deadline) {
+			continue
+		}
+		for _, m := range busy {
+			if !containsInterface(still, m) {
+				printJSON(map[string]string{
+					"event":  "interface_stolen",
+					"device": m.vidPid(),
+					"from":   joinClients(clients),
+				})
+			}
+		}
+		return still
+	}
+}
+
+func containsInterface(ifaces []*mtpInterface, m *mtpInterface) bool {
+	for _, i := range ifaces {
+		if i.bus == m.bus && i.address == m.address {
+			return true
+		}
+	}
+	return false
+}
+
+// busyError explains a failed open with the first busy interface, naming the
+// kernel driver or the MTP clients that likely hold it, or returns err as is
+func busyError(busy []*mtpInterface, err error) error {
+	if len(busy) == 0 {
+		return err
+	}
+	m := busy[0]
+	e := interfaceBusyError{iface: m, err: err}
+	if m.kernelDriver {
+		e.driver = kernelDriverName(m)
+	} else {
+		e.clients = mtpClients()
+	}
+	return e
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ganeshrvel/go-mtpfs/mtp"
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// cachedCommands only read the object tree, so with --cache they are served
+// from the cache
+var cachedCommands = map[string]bool{
+	"list": true,
+	"stat": true,
+	"find": true,
+	"du":   true,
+	"tree": true,
+}
+
+// renamingCommands can rename or move objects without changing the set of
+// object handles the cache is checked against, so they drop the cache of the
+// device
+var renamingCommands = map[string]bool{
+	"mv":    true,
+	"prop":  true,
+	"serve": true,
+	"http":  true,
+	"mount": true,
+	"trash": true,
+}
+
+// cachedObject is an object of a cached tree
+type cachedObject struct {
+	Id              uint32    `json:"id"`
+	Parent          uint32    `json:"parent"`
+	Path            string    `json:"path"`
+	Size            int64     `json:"size"`
+	Mtime           time.Time `json:"mtime"`
+	Dir             bool      `json:"dir,omitempty"`
+	Hidden          bool      `json:"hidden,omitempty"`
+	Format          uint16    `json:"format"`
+	AssociationType uint16    `json:"association_type,omitempty"`
+	AssociationDesc uint32    `json:"association_desc,omitempty"`
+}
+
+// objectTree is the cached object tree of a storage. Handles is the digest
+// of the storage's object handles when the tree was read, objects are in walk
+// order.
+type objectTree struct {
+	Storage uint32          `json:"storage"`
+	Handles string          `json:"handles"`
+	Built   time.Time       `json:"built"`
+	Objects []*cachedObject `json:"objects"`
+
+	byPath   map[string]*cachedObject
+	byId     map[uint32]*cachedObject
+	children map[string][]*cachedObject
+}
+
+// index builds the lookup maps, with an entry for the storage root
+func (t *objectTree) index() {
+	root := &cachedObject{Id: mtpx.ParentObjectId, Path: "/", Dir: true}
+	t.byPath = map[string]*cachedObject{"/": root}
+	t.byId = map[uint32]*cachedObject{root.Id: root}
+	t.children = map[string][]*cachedObject{}
+	for _, o := range t.Objects {
+		t.byPath[o.Path] = o
+		t.byId[o.Id] = o
+		parent := path.Dir(o.Path)
+		t.children[parent] = append(t.children[parent], o)
+	}
+}
+
+// lookup returns the object at p, nil if it isn't cached or t is nil
+func (t *objectTree) lookup(p string) *cachedObject {
+	if t == nil {
+		return nil
+	}
+	return t.byPath[path.Clean(p)]
+}
+
+// object returns the object with the handle id, nil if it isn't cached or t
+// is nil
+func (t *objectTree) object(id uint32) *cachedObject {
+	if t == nil {
+		return nil
+	}
+	return t.byId[id]
+}
+
+// fileInfo returns o as go-mtpx describes objects, with the object info
+// fields the commands read
+func (o *cachedObject) fileInfo(storage uint32) *mtpx.FileInfo {
+	if o.Path == "/" {
+		return &mtpx.FileInfo{IsDir: true, FullPath: "/", ObjectId: o.Id, Info: &mtp.ObjectInfo{}}
+	}
+
+	name := path.Base(o.Path)
+	size := uint32(0xFFFFFFFF)
+	if o.Size < int64(size) {
+		size = uint32(o.Size)
+	}
+	return &mtpx.FileInfo{
+		Size:       o.Size,
+		IsDir:      o.Dir,
+		ModTime:    o.Mtime,
+		Name:       name,
+		FullPath:   o.Path,
+		ParentPath: path.Dir(o.Path),
+		Extension:  objectExtension(name, o.Dir),
+		ParentId:   o.Parent,
+		ObjectId:   o.Id,
+		Info: &mtp.ObjectInfo{
+			StorageID:        storage,
+			ObjectFormat:     o.Format,
+			CompressedSize:   size,
+			ParentObject:     o.Parent,
+			AssociationType:  o.AssociationType,
+			AssociationDesc:  o.AssociationDesc,
+			Filename:         name,
+			ModificationDate: o.Mtime,
+		},
+	}
+}
+
+// cachedTree returns the object tree of the current storage when the command
+// runs with --cache, and nil otherwise. The cached tree is used while the
+// storage holds the same object handles as when it was read, which is the
+// same check the events command derives added and removed objects from and
+// costs one request. A cache that can't be read or written is reported and
+// the device is asked as usual.
+func (c *CLI) cachedTree() *objectTree {
+	if !c.cacheOn {
+		return nil
+	}
+	if t, ok := c.trees[c.storage]; ok {
+		return t
+	}
+
+	// reading the tree looks up hidden objects, which mustn't ask for the
+	// tree being read
+	c.trees[c.storage] = nil
+	t, err := c.loadTree()
+	if err != nil {
+		log.Printf("not using the object cache: %v", err)
+		return nil
+	}
+	c.trees[c.storage] = t
+	return t
+}
+
+// loadTree reads the cached tree of the current storage, or reads the tree
+// from the device and caches it when there is none, it is outdated or
+// --refresh is given
+func (c *CLI) loadTree() (*objectTree, error) {
+	file, err := c.treeFile(c.storage)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := c.handlesDigest()
+	if err != nil {
+		return nil, err
+	}
+
+	if !*refreshCache {
+		if t, err := readTree(file); err == nil && t.Storage == c.storage && t.Handles == digest {
+			return t, nil
+		}
+	}
+
+	t, err := c.readDeviceTree(digest)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeTree(file, t); err != nil {
+		log.Printf("failed to write the object cache: %v", err)
+	}
+	return t, nil
+}
+
+// readDeviceTree walks the whole storage
+func (c *CLI) readDeviceTree(digest string) (*objectTree, error) {
+	t := &objectTree{Storage: c.storage, Handles: digest, Built: time.Now().UTC()}
+	_, _, _, err := c.walk("/", true, false, false,
+		func(objectId uint32, fi *mtpx.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			o := &cachedObject{
+				Id:     objectId,
+				Parent: fi.ParentId,
+				Path:   path.Clean(fi.FullPath),
+				Size:   fi.Size,
+				Mtime:  fi.ModTime,
+				Dir:    fi.IsDir,
+				Hidden: c.isHidden(fi),
+			}
+			if fi.Info != nil {
+				o.Format = fi.Info.ObjectFormat
+				o.AssociationType = fi.Info.AssociationType
+				o.AssociationDesc = fi.Info.AssociationDesc
+			}
+			t.Objects = append(t.Objects, o)
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the object tree: %w", err)
+	}
+	t.index()
+	return t, nil
+}
+
+// handlesDigest hashes the sorted object handles of the current storage
+func (c *CLI) handlesDigest() (string, error) {
+	var handles mtp.Uint32Array
+	if err := c.device.GetObjectHandles(c.storage, 0, allObjects, &handles); err != nil {
+		return "", fmt.Errorf("failed to list the objects of storage %d: %w", c.storage, err)
+	}
+	sort.Slice(handles.Values, func(i, j int) bool { return handles.Values[i] < handles.Values[j] })
+
+	h := sha256.New()
+	buf := make([]byte, 4)
+	for _, id := range handles.Values {
+		binary.LittleEndian.PutUint32(buf, id)
+		h.Write(buf)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// dropCache removes the cached trees of every storage of the device
+func (c *CLI) dropCache() {
+	c.trees = map[uint32]*objectTree{}
+	dir, err := objectCacheDir()
+	if err != nil {
+		return
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, c.cacheKey()+"-*.json"))
+	for _, f := range files {
+		os.Remove(f)
+	}
+}
+
+// treeFile returns the cache file of a storage of the device
+func (c *CLI) treeFile(storage uint32) (string, error) {
+	dir, err := objectCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s-%08x.json", c.cacheKey(), storage)), nil
+}
+
+// cacheKey names the device in cache files: its USB serial number, or its
+// vendor and product id for devices without one
+func (c *CLI) cacheKey() string {
+	info, err := c.device.GetUsbInfo()
+	if err != nil {
+		return "unknown"
+	}
+	if info.SerialNumber == "" {
+		return fmt.Sprintf("%04x-%04x", info.IdVendor, info.IdProduct)
+	}
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' {
+			return r
+		}
+		return '_'
+	}, info.SerialNumber)
+}
+
+// objectCacheDir returns the directory of the cached trees below the user
+// cache directory, such as ~/.cache/mtpx-cli/objects
+func objectCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "mtpx-cli", "objects"), nil
+}
+
+func readTree(file string) (*objectTree, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var t objectTree
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("invalid object cache %s: %w", file, err)
+	}
+	t.index()
+	return &t, nil
+}
+
+// writeTree replaces file through a temp file of its own, so concurrent runs
+// never read half a tree or rename each other's
+func writeTree(file string, t *objectTree) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*"+partSuffix)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), file)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
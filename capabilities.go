@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ganeshrvel/go-mtpfs/mtp"
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// capability is a code from the device info with its name
+type capability struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
+
+// features are the operations the commands of this tool depend on, by what
+// they enable
+var features = []struct {
+	name string
+	ops  []uint16
+}{
+	{"partial_read", []uint16{mtp.OC_ANDROID_GET_PARTIAL_OBJECT64}},
+	{"partial_write", []uint16{mtp.OC_ANDROID_BEGIN_EDIT_OBJECT, mtp.OC_ANDROID_SEND_PARTIAL_OBJECT, mtp.OC_ANDROID_END_EDIT_OBJECT}},
+	{"move", []uint16{mtp.OC_MoveObject}},
+	{"copy", []uint16{mtp.OC_CopyObject}},
+	{"thumbnails", []uint16{mtp.OC_GetThumb}},
+	{"object_properties", []uint16{mtp.OC_MTP_GetObjectPropsSupported, mtp.OC_MTP_GetObjectPropValue}},
+	{"set_object_properties", []uint16{mtp.OC_MTP_SetObjectPropValue}},
+	{"device_properties", []uint16{mtp.OC_GetDevicePropDesc, mtp.OC_GetDevicePropValue}},
+}
+
+func (c *CLI) handleCapabilities(args []string) error {
+	info, err := mtpx.FetchDeviceInfo(c.device)
+	if err != nil {
+		return err
+	}
+
+	supported := map[string]bool{}
+	for _, f := range features {
+		ok := true
+		for _, op := range f.ops {
+			ok = ok && supportsOperation(info, op)
+		}
+		supported[f.name] = ok
+	}
+
+	// object properties are declared per format, so ask for every format the
+	// device can hold
+	objectProps := map[string][]capability{}
+	if supported["object_properties"] {
+		for _, format := range info.PlaybackFormats {
+			var props mtp.Uint16Array
+			if err := c.device.GetObjectPropsSupported(format, &props); err != nil {
+				continue
+			}
+			objectProps[codeName(format, mtp.OFC_names)] = capabilities(props.Values, mtp.OPC_names)
+		}
+	}
+
+	printJSON(map[string]interface{}{
+		"features":          supported,
+		"operations":        capabilities(info.OperationsSupported, mtp.OC_names),
+		"events":            capabilities(info.EventsSupported, mtp.EC_names),
+		"device_properties": capabilities(info.DevicePropertiesSupported, mtp.DPC_names),
+		"capture_formats":   capabilities(info.CaptureFormats, mtp.OFC_names),
+		"playback_formats":  capabilities(info.PlaybackFormats, mtp.OFC_names),
+		"object_properties": objectProps,
+	})
+
+	printDone("MTPX_CAPABILITIES_DONE")
+	return nil
+}
+
+// capabilities names codes, keeping the order the device reports them in
+func capabilities(codes []uint16, names map[int]string) []capability {
+	list := []capability{}
+	for _, code := range codes {
+		list = append(list, capability{Code: fmt.Sprintf("0x%04X", code), Name: codeName(code, names)})
+	}
+	return list
+}
+
+// codeName returns the name of code, or the code in hex for vendor codes
+// without one
+func codeName(code uint16, names map[int]string) string {
+	if name, ok := names[int(code)]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04X", code)
+}
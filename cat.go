@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// handleCat streams remote files to stdout one after another. Stdout carries
+// nothing but the file data, so there is no done line and glob matches aren't
+// printed; progress is only reported to --progress-fd.
+func (c *CLI) handleCat(args []string) error {
+	fs := flag.NewFlagSet("cat", flag.ContinueOnError)
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) < 1 {
+		return usagef("cat requires a remote path")
+	}
+
+	rawStdout = true
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+
+	for _, arg := range args {
+		remote, err := c.resolveRemote(arg)
+		if err != nil {
+			return err
+		}
+
+		fi, err := c.objectFromPath(remote)
+		if err != nil {
+			return err
+		}
+		if fi.IsDir {
+			return fmt.Errorf("%s is a directory", fi.FullPath)
+		}
+
+		report := progressOut != os.Stdout
+		if report {
+			progress.begin(fi.ObjectId)
+		}
+		start := time.Now()
+		err = c.device.GetObject(fi.ObjectId, w, func(sent int64) error {
+			if report {
+				return progress.update(fi.ObjectId, fi.Name, fi.FullPath, sent, fi.Size)
+			}
+			return checkTransfer(start)
+		})
+		if err != nil {
+			return transferFailed(fmt.Errorf("failed to read %s: %w", fi.FullPath, err))
+		}
+		if report {
+			progress.complete(fi.ObjectId, fi.Name, fi.FullPath, "-", fi.Size)
+		}
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+const bashCompletion = `# bash completion for mtpx-cli
+_mtpx_cli() {
+    local cur="${COMP_WORDS[COMP_CWORD]}"
+
+    # the command is the first word that isn't a global flag or its value
+    local cmd="" i
+    for ((i = 1; i < COMP_CWORD; i++)); do
+        case "${COMP_WORDS[i]}" in
+            {{VALUE_FLAGS}}) ((i++)) ;;
+            -*) ;;
+            *) cmd="${COMP_WORDS[i]}"; break ;;
+        esac
+    done
+
+    if [[ "$cur" == -* ]]; then
+        local flags="{{GLOBAL_FLAGS}}"
+        case "$cmd" in
+{{COMMAND_FLAGS}}
+        esac
+        COMPREPLY=($(compgen -W "$flags" -- "$cur"))
+        return
+    fi
+
+    if [[ -z "$cmd" ]]; then
+        COMPREPLY=($(compgen -W "{{COMMANDS}}" -- "$cur"))
+        return
+    fi
+
+    # remote paths are always absolute, everything else is a local path
+    if [[ "$cur" == /* ]]; then
+        local IFS=$'\n'
+        COMPREPLY=($("${COMP_WORDS[0]}" __complete "$cur" 2>/dev/null))
+        compopt -o nospace 2>/dev/null
+        return
+    fi
+
+    COMPREPLY=($(compgen -f -- "$cur"))
+}
+
+complete -F _mtpx_cli mtpx-cli
+`
+
+const zshCompletion = `#compdef mtpx-cli
+
+_mtpx_cli() {
+    local -a commands
+    commands=(
+{{COMMANDS}}
+    )
+
+    # the command is the first word that isn't a global flag or its value
+    local cmd="" i
+    for ((i = 2; i < CURRENT; i++)); do
+        case "${words[i]}" in
+            {{VALUE_FLAGS}}) ((i++)) ;;
+            -*) ;;
+            *) cmd="${words[i]}"; break ;;
+        esac
+    done
+
+    if [[ "$PREFIX" == -* ]]; then
+        local -a flags
+        flags=({{GLOBAL_FLAGS}})
+        case "$cmd" in
+{{COMMAND_FLAGS}}
+        esac
+        compadd -- $flags
+        return
+    fi
+
+    if [[ -z "$cmd" ]]; then
+        _describe 'command' commands
+        return
+    fi
+
+    # remote paths are always absolute, everything else is a local path
+    if [[ "$PREFIX" == /* ]]; then
+        local -a remote
+        remote=("${(@f)$(${words[1]} __complete "$PREFIX" 2>/dev/null)}")
+        compadd -S '' -- $remote
+        return
+    fi
+
+    _files
+}
+
+compdef _mtpx_cli mtpx-cli
+`
+
+const fishCompletion = `# fish completion for mtpx-cli
+function __mtpx_cli_remote
+    set -l cur (commandline -ct)
+    string match -q -- '/*' $cur; or return
+    mtpx-cli __complete $cur 2>/dev/null
+end
+
+{{COMMANDS}}
+{{GLOBAL_FLAGS}}
+{{COMMAND_FLAGS}}
+complete -c mtpx-cli -n 'not __fish_use_subcommand' -a '(__mtpx_cli_remote)'
+`
+
+// usageFlag matches the flags in a command's usage string
+var usageFlag = regexp.MustCompile(`(?:^|[\s\[])(--?[a-z][a-z0-9-]*)`)
+
+// commandFlags returns the flags named in the usage string of c
+func commandFlags(c command) []string {
+	var flags []string
+	seen := map[string]bool{}
+	for _, m := range usageFlag.FindAllStringSubmatch(c.args, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			flags = append(flags, m[1])
+		}
+	}
+	return flags
+}
+
+// globalFlags returns the global flags, and those of them that take a value
+func globalFlags() (all, withValue []string) {
+	flag.VisitAll(func(f *flag.Flag) {
+		all = append(all, "--"+f.Name)
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !b.IsBoolFlag() {
+			withValue = append(withValue, "--"+f.Name)
+		}
+	})
+	return all, withValue
+}
+
+// zshEscape escapes s for a single-quoted zsh string
+func zshEscape(s string) string {
+	return strings.ReplaceAll(s, "'", `'\''`)
+}
+
+// fishEscape escapes s for a single-quoted fish string, in which a backslash
+// escapes a quote or another backslash
+func fishEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s)
+}
+
+func handleCompletion(args []string) error {
+	if len(args) < 1 {
+		return usagef("completion requires a shell: bash, zsh or fish")
+	}
+
+	global, withValue := globalFlags()
+	var script string
+	var names, flagCases []string
+
+	switch args[0] {
+	case "bash", "zsh":
+		for _, c := range commands {
+			if args[0] == "bash" {
+				names = append(names, c.name)
+			} else {
+				names = append(names, fmt.Sprintf("        '%s:%s'", c.name, zshEscape(c.desc)))
+			}
+			if flags := commandFlags(c); len(flags) > 0 {
+				list := strings.Join(flags, " ")
+				if args[0] == "bash" {
+					flagCases = append(flagCases, fmt.Sprintf(`            %s) flags="%s" ;;`, c.name, list))
+				} else {
+					flagCases = append(flagCases, fmt.Sprintf(`            %s) flags=(%s) ;;`, c.name, list))
+				}
+			}
+		}
+		script = bashCompletion
+		sep := " "
+		if args[0] == "zsh" {
+			script = zshCompletion
+			sep = "\n"
+		}
+		script = strings.NewReplacer(
+			"{{COMMANDS}}", strings.Join(names, sep),
+			"{{VALUE_FLAGS}}", strings.Join(withValue, "|"),
+			"{{GLOBAL_FLAGS}}", strings.Join(global, " "),
+			"{{COMMAND_FLAGS}}", strings.Join(flagCases, "\n"),
+		).Replace(script)
+	case "fish":
+		var globalLines []string
+		for _, c := range commands {
+			names = append(names, fmt.Sprintf("complete -c mtpx-cli -f -n '__fish_use_subcommand' -a '%s' -d '%s'", c.name, fishEscape(c.desc)))
+			for _, f := range commandFlags(c) {
+				flagCases = append(flagCases, fmt.Sprintf("complete -c mtpx-cli -n '__fish_seen_subcommand_from %s' %s", c.name, fishFlag(f)))
+			}
+		}
+		flag.VisitAll(func(f *flag.Flag) {
+			line := fmt.Sprintf("complete -c mtpx-cli -n '__fish_use_subcommand' %s -d '%s'", fishFlag("--"+f.Name), fishEscape(f.Usage))
+			if slices.Contains(withValue, "--"+f.Name) {
+				line += " -r"
+			}
+			globalLines = append(globalLines, line)
+		})
+		script = strings.NewReplacer(
+			"{{COMMANDS}}", strings.Join(names, "\n"),
+			"{{GLOBAL_FLAGS}}", strings.Join(globalLines, "\n"),
+			"{{COMMAND_FLAGS}}", strings.Join(flagCases, "\n"),
+		).Replace(fishCompletion)
+	default:
+		return usagef("unsupported shell: %s", args[0])
+	}
+
+	fmt.Print(script)
+	return nil
+}
+
+// fishFlag turns a flag into the complete option naming it: -l for --long,
+// -s for a single letter and -o for other single dash flags
+func fishFlag(f string) string {
+	switch {
+	case strings.HasPrefix(f, "--"):
+		return "-l " + f[2:]
+	case len(f) == 2:
+		return "-s " + f[1:]
+	}
+	return "-o " + f[1:]
+}
+
+// handleComplete prints the immediate children of the partial path's parent
+// that match its last segment, one per line, for use by the completion scripts.
+// Directories carry a trailing slash so the shell can descend into them.
+func (c *CLI) handleComplete(args []string) error {
+	partial := "/"
+	if len(args) > 0 && args[0] != "" {
+		partial = args[0]
+	}
+
+	dir, prefix := path.Split(partial)
+	dir = path.Clean("/" + dir)
+
+	_, _, _, err := c.walk(dir, false, true, false,
+		func(objectId uint32, fi *mtpx.FileInfo, err error) error {
+			if err != nil || !strings.HasPrefix(fi.Name, prefix) {
+				return nil
+			}
+			p := path.Join(dir, fi.Name)
+			if fi.IsDir {
+				p += "/"
+			}
+			fmt.Println(p)
+			return nil
+		})
+
+	return err
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Overwrite policies of a profile
+const (
+	overwriteReplace = "replace"
+	overwriteSkip    = "skip"
+)
+
+// profile holds the settings of the profile chosen with --profile that aren't
+// global flags
+type profile struct {
+	name string
+
+	// downloadDir is the local directory download uses when none is given
+	downloadDir string
+
+	// overwrite is the default for existing local files on download:
+	// replace, or skip to default to --skip-existing
+	overwrite string
+}
+
+// activeProfile is the profile in use, empty without one
+var activeProfile profile
+
+// defaultConfigPath returns ~/.config/mtpx-cli/config.yaml, or the same below
+// $XDG_CONFIG_HOME
+func defaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "mtpx-cli", "config.yaml")
+}
+
+// loadProfile reads the config file and applies the profile named name, or
+// the file's default_profile when name is empty. A missing config file is
+// only an error when a profile was asked for.
+//
+// Profile keys named like a global flag set that flag unless it was given on
+// the command line; download_dir and overwrite go to activeProfile.
+func loadProfile(file, name string) error {
+	if file == "" {
+		file = defaultConfigPath()
+	}
+
+	config, err := readConfig(file)
+	if errors.Is(err, os.ErrNotExist) && name == "" {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if name == "" {
+		name, _ = config["default_profile"].(string)
+		if name == "" {
+			return nil
+		}
+	}
+
+	profiles, _ := config["profiles"].(map[string]interface{})
+	settings, ok := profiles[name].(map[string]interface{})
+	if !ok {
+		return usagef("no profile %q in %s", name, file)
+	}
+
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	activeProfile = profile{name: name, overwrite: overwriteReplace}
+	for _, key := range sortedSettings(settings) {
+		value, ok := settings[key].(string)
+		if !ok {
+			return fmt.Errorf("%s: profile %s: %s must be a value", file, name, key)
+		}
+
+		switch key {
+		case "download_dir":
+			activeProfile.downloadDir = expandHome(value)
+			continue
+		case "overwrite":
+			if value != overwriteReplace && value != overwriteSkip {
+				return fmt.Errorf("%s: profile %s: invalid overwrite %q: must be %s or %s", file, name, value, overwriteReplace, overwriteSkip)
+			}
+			activeProfile.overwrite = value
+			continue
+		case "profile", "config":
+			return fmt.Errorf("%s: profile %s: %s can't be set in a profile", file, name, key)
+		}
+
+		flagName := strings.ReplaceAll(key, "_", "-")
+		if flag.Lookup(flagName) == nil {
+			return fmt.Errorf("%s: profile %s: unknown setting %s", file, name, key)
+		}
+		if given[flagName] {
+			continue
+		}
+		if err := flag.Set(flagName, value); err != nil {
+			return fmt.Errorf("%s: profile %s: %s: %w", file, name, key, err)
+		}
+	}
+	return nil
+}
+
+func sortedSettings(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func expandHome(p string) string {
+	if p != "~" && !strings.HasPrefix(p, "~/") {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return p
+	}
+	return filepath.Join(home, strings.TrimPrefix(p, "~"))
+}
+
+// readConfig reads the subset of YAML the config file uses: nested mappings
+// of plain, single or double quoted scalars, with # comments. Values are
+// strings and nested mappings are map[string]interface{}.
+func readConfig(file string) (map[string]interface{}, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	type level struct {
+		indent int
+		m      map[string]interface{}
+	}
+	root := map[string]interface{}{}
+	stack := []level{{indent: -1, m: root}}
+
+	// the mapping opened by the previous "key:" line, waiting for its
+	// first indented entry
+	var open map[string]interface{}
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := stripComment(scanner.Text())
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		body := strings.TrimLeft(line, " ")
+		indent := len(line) - len(body)
+		if strings.HasPrefix(body, "\t") {
+			return nil, fmt.Errorf("%s:%d: tabs can't be used for indentation", file, n)
+		}
+		if strings.HasPrefix(body, "- ") || body == "-" {
+			return nil, fmt.Errorf("%s:%d: lists are not supported", file, n)
+		}
+
+		// a "key:" line followed by a less indented one leaves an empty
+		// mapping
+		if open != nil && indent > stack[len(stack)-1].indent {
+			stack = append(stack, level{indent: indent, m: open})
+		}
+		open = nil
+		for len(stack) > 1 && indent < stack[len(stack)-1].indent {
+			stack = stack[:len(stack)-1]
+		}
+		if stack[0].indent < 0 {
+			stack[0].indent = indent
+		}
+		if indent != stack[len(stack)-1].indent {
+			return nil, fmt.Errorf("%s:%d: unexpected indentation", file, n)
+		}
+
+		key, value, ok := strings.Cut(body, ":")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%s:%d: expected key: value", file, n)
+		}
+		key, err = unquoteScalar(strings.TrimSpace(key))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, n, err)
+		}
+		value = strings.TrimSpace(value)
+
+		m := stack[len(stack)-1].m
+		if value == "" {
+			child := map[string]interface{}{}
+			m[key] = child
+			open = child
+			continue
+		}
+
+		v, err := unquoteScalar(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, n, err)
+		}
+		m[key] = v
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// stripComment removes a # comment that starts the line or follows a space,
+// outside of quotes
+func stripComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func unquoteScalar(value string) (string, error) {
+	if len(value) == 0 || (value[0] != '"' && value[0] != '\'') {
+		return value, nil
+	}
+	quote := value[0]
+	if len(value) < 2 || value[len(value)-1] != quote {
+		return "", fmt.Errorf("unterminated quote in %s", value)
+	}
+	inner := value[1 : len(value)-1]
+	if quote == '\'' {
+		return strings.ReplaceAll(inner, "''", "'"), nil
+	}
+	return strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(inner), nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// Policies for --on-conflict, what a transfer does when its target exists
+const (
+	conflictOverwrite = "overwrite"
+	conflictSkip      = "skip"
+	conflictRename    = "rename"
+	conflictNewer     = "newer"
+)
+
+func validateConflict(policy string) error {
+	switch policy {
+	case conflictOverwrite, conflictSkip, conflictRename, conflictNewer:
+		return nil
+	}
+	return usagef("invalid --on-conflict %q: must be %s, %s, %s or %s", policy,
+		conflictSkip, conflictOverwrite, conflictRename, conflictNewer)
+}
+
+// checkConflict applies policy to a source modified at srcTime whose target
+// exists and was modified at dstTime. It returns the reason to skip the
+// transfer, or "" to go ahead; for rename the caller picks a new name.
+func checkConflict(policy string, srcTime, dstTime time.Time) string {
+	switch policy {
+	case conflictSkip:
+		return "exists"
+	case conflictNewer:
+		// MTP dates carry no fractions
+		if !srcTime.Truncate(time.Second).After(dstTime.Truncate(time.Second)) {
+			return "not_newer"
+		}
+	}
+	return ""
+}
+
+// printConflictSkip reports a transfer of source left out because of target
+func printConflictSkip(source, target, reason string) error {
+	transfers.skip()
+	return printJSON(map[string]interface{}{
+		"path":    source,
+		"target":  target,
+		"skipped": true,
+		"reason":  reason,
+	})
+}
+
+// conflictName returns name with " (n)" before its extension, as file managers
+// name copies
+func conflictName(name string, n int) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	if base == "" {
+		base, ext = name, ""
+	}
+	return fmt.Sprintf("%s (%d)%s", base, n, ext)
+}
+
+// freeLocalPath returns the first name for localPath that doesn't exist yet
+func freeLocalPath(localPath string) string {
+	dir, name := filepath.Split(localPath)
+	for n := 1; ; n++ {
+		candidate := filepath.Join(dir, conflictName(name, n))
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
+
+// freeRemoteName returns the first name for name that remoteDir doesn't hold
+// yet, listing the directory once
+func (c *CLI) freeRemoteName(remoteDir, name string) (string, error) {
+	taken := map[string]bool{}
+	_, _, _, err := c.walk(remoteDir, false, false, false,
+		func(objectId uint32, fi *mtpx.FileInfo, err error) error {
+			if err == nil {
+				taken[fi.Name] = true
+			}
+			return nil
+		})
+	if err != nil {
+		return "", err
+	}
+
+	for n := 1; ; n++ {
+		if candidate := conflictName(name, n); !taken[candidate] {
+			return candidate, nil
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ganeshrvel/go-mtpfs/mtp"
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// handleCopy duplicates a file, or with -r a directory tree, on the device.
+// Files are copied with the MTP CopyObject operation, so their data never
+// crosses USB. On devices without it each file is downloaded to a temporary
+// file and uploaded again. Like cp, a destination that is an existing
+// directory receives the copy under the source's name. Existing files are
+// never replaced. --to-storage puts the copy on another storage.
+func (c *CLI) handleCopy(args []string) error {
+	fs := flag.NewFlagSet("cp", flag.ContinueOnError)
+	recursive := fs.Bool("r", false, "copy a directory and everything below it")
+	fs.BoolVar(recursive, "recursive", false, "same as -r")
+	toStorage := fs.String("to-storage", "", "copy to this storage: index or id from storage-info, or a storage label")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) < 2 {
+		return usagef("cp requires remote source and destination")
+	}
+
+	src, err := c.resolveRemote(args[0])
+	if err != nil {
+		return err
+	}
+	if src == "/" {
+		return fmt.Errorf("cp: cannot copy the root directory")
+	}
+
+	srcStorage := c.storage
+	dstStorage, err := c.targetStorage(*toStorage)
+	if err != nil {
+		return err
+	}
+	defer func() { c.storage = srcStorage }()
+
+	results, err := c.fileExists([]mtpx.FileProp{{FullPath: src}})
+	if err != nil {
+		return err
+	}
+	if len(results) != 1 || !results[0].Exists {
+		return notFoundf("file not found: %s", src)
+	}
+	fi := results[0].FileInfo
+
+	// the destination is looked up on the storage it is on
+	c.storage = dstStorage
+	dst, err := c.resolveRemote(args[1])
+	if err != nil {
+		return err
+	}
+	if existing, err := c.fileExists([]mtpx.FileProp{{FullPath: dst}}); err != nil {
+		return err
+	} else if len(existing) == 1 && existing[0].Exists {
+		if !existing[0].FileInfo.IsDir {
+			return fmt.Errorf("cp: %s already exists", dst)
+		}
+		dst = path.Join(dst, fi.Name)
+		if c.remoteExists(dst) {
+			return fmt.Errorf("cp: %s already exists", dst)
+		}
+	}
+
+	if fi.IsDir && !*recursive {
+		return usagef("cp: %s is a directory, use -r to copy it", src)
+	}
+	if fi.IsDir && dstStorage == srcStorage && (dst == src || strings.HasPrefix(dst, src+"/")) {
+		return fmt.Errorf("cp: cannot copy %s into itself", src)
+	}
+
+	info, err := mtpx.FetchDeviceInfo(c.device)
+	if err != nil {
+		return err
+	}
+	cp := &copier{cli: c, native: supportsOperation(info, mtp.OC_CopyObject), from: srcStorage, to: dstStorage}
+
+	if !fi.IsDir {
+		err = cp.copyFile(fi, dst)
+	} else {
+		err = cp.copyTree(fi, dst)
+	}
+	if err != nil {
+		return err
+	}
+
+	printDone("MTPX_CP_DONE")
+	return nil
+}
+
+// copier copies files from the storage from to the storage to, which may be
+// the same
+type copier struct {
+	cli      *CLI
+	from, to uint32
+
+	// native is set while the device copies objects itself, it is cleared
+	// once CopyObject turns out not to work after all
+	native bool
+}
+
+// copyTree recreates the directory root as dst and copies every file below
+// it. The tree is listed before anything is copied, since listing and
+// copying can happen on different storages.
+func (cp *copier) copyTree(root *mtpx.FileInfo, dst string) error {
+	c := cp.cli
+
+	var objects []*mtpx.FileInfo
+	c.storage = cp.from
+	_, _, _, err := c.walk(root.FullPath, true, true, false,
+		func(objectId uint32, fi *mtpx.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			objects = append(objects, fi)
+			return nil
+		})
+	c.storage = cp.to
+	if err != nil {
+		return err
+	}
+
+	if err := c.makeRemoteDir(dst); err != nil {
+		return err
+	}
+	for _, fi := range objects {
+		if err := canceled(); err != nil {
+			return err
+		}
+		target := path.Join(dst, strings.TrimPrefix(fi.FullPath, root.FullPath))
+		if fi.IsDir {
+			err = c.makeRemoteDir(target)
+		} else {
+			err = cp.copyFile(fi, target)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile copies the file fi to dst, whose parent directory exists, and
+// prints a line naming the copy and how it was made. Object handles are
+// unique across storages, so fi is read by handle whatever the current
+// storage.
+func (cp *copier) copyFile(fi *mtpx.FileInfo, dst string) error {
+	c := cp.cli
+	c.storage = cp.to
+	dir, name := path.Split(dst)
+
+	entry := map[string]interface{}{
+		"source": fi.FullPath,
+		"target": dst,
+		"size":   fi.Size,
+	}
+	if cp.to != cp.from {
+		entry["storage_id"] = cp.to
+	}
+	if *dryRun {
+		return printPlanned("copy", entry)
+	}
+
+	if cp.native {
+		handle, err := c.copyObject(fi.ObjectId, dir)
+		var rc mtp.RCError
+		switch {
+		case err == nil:
+			if name != fi.Name {
+				if _, err := mtpx.RenameFile(c.device, c.storage, mtpx.FileProp{ObjectId: handle}, name); err != nil {
+					c.device.DeleteObject(handle)
+					return fmt.Errorf("failed to rename the copy of %s: %w", fi.FullPath, err)
+				}
+			}
+			entry["object_id"] = handle
+			entry["method"] = "copy_object"
+			return printJSON(entry)
+		// devices that copy within a storage may still refuse to copy to
+		// another one
+		case errors.As(err, &rc) && (rc == mtp.RC_OperationNotSupported || cp.to != cp.from):
+			cp.native = false
+		default:
+			return fmt.Errorf("failed to copy %s: %w", fi.FullPath, err)
+		}
+	}
+
+	if err := cp.transfer(fi, dir, name); err != nil {
+		return err
+	}
+	entry["method"] = "transfer"
+	return printJSON(entry)
+}
+
+// transfer copies fi through a temporary local file, since an MTP session
+// can't read one object while it writes another
+func (cp *copier) transfer(fi *mtpx.FileInfo, dir, name string) error {
+	c := cp.cli
+	f, err := os.CreateTemp("", "mtpx-cp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create a temporary file: %w", err)
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+
+	start := time.Now()
+	err = c.device.GetObject(fi.ObjectId, f, func(int64) error { return checkTransfer(start) })
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", fi.FullPath, err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	u := &uploader{cli: c, limiter: newRateLimiter(0)}
+	return u.uploadStream(f, fi.Size, fi.FullPath, name, dir, fi.ModTime)
+}
+
+// copyObject copies an object below the existing directory parentDir on the
+// current storage with the MTP CopyObject operation and returns the handle of
+// the copy
+func (c *CLI) copyObject(objectId uint32, parentDir string) (uint32, error) {
+	parent, err := c.objectFromPath(parentDir)
+	if err != nil {
+		return 0, err
+	}
+	if !parent.IsDir {
+		return 0, fmt.Errorf("%s is not a directory", parentDir)
+	}
+
+	// like MoveObject, CopyObject addresses the storage root as 0
+	parentId := parent.ObjectId
+	if path.Clean(parentDir) == "/" {
+		parentId = 0
+	}
+
+	req := mtp.Container{
+		Code:  mtp.OC_CopyObject,
+		Param: []uint32{objectId, c.storage, parentId},
+	}
+	var rep mtp.Container
+	if err := c.device.RunTransaction(&req, &rep, nil, nil, 0, mtp.EmptyProgressFunc); err != nil {
+		return 0, err
+	}
+	if len(rep.Param) == 0 {
+		return 0, fmt.Errorf("the device didn't return the handle of the copy")
+	}
+	return rep.Param[0], nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// Criteria for dedupe --by
+const (
+	dedupeBySize = "size"
+	dedupeByHash = "hash"
+)
+
+// handleDedupe reports sets of remote files below a path that have the same
+// size, or with --by hash the same sha256, streamed from the device for files
+// of the same size only. Each set keeps its oldest file, and --delete deletes
+// the others after confirmation. Empty files are left out.
+func (c *CLI) handleDedupe(args []string) error {
+	fs := flag.NewFlagSet("dedupe", flag.ContinueOnError)
+	by := fs.String("by", dedupeBySize, "what makes files duplicates: size, or hash for size and sha256")
+	deleteDups := fs.Bool("delete", false, "delete every file of a set but the one kept, requires --by hash")
+	yes := fs.Bool("yes", false, "delete without confirmation")
+	fs.BoolVar(yes, "y", false, "same as --yes")
+	skipHidden := fs.Bool("skip-hidden", false, "leave hidden objects and their contents out")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) < 1 {
+		return usagef("dedupe requires a remote path")
+	}
+	if *by != dedupeBySize && *by != dedupeByHash {
+		return usagef("invalid --by %q: must be %s or %s", *by, dedupeBySize, dedupeByHash)
+	}
+	// files of the same size are only likely to be the same
+	if *deleteDups && *by != dedupeByHash {
+		return usagef("--delete requires --by hash")
+	}
+	if *deleteDups && !*yes && !*dryRun && !isTerminal(os.Stdin) {
+		return usagef("refusing to delete duplicates without confirmation, use --yes")
+	}
+
+	root, err := c.resolveRemote(args[0])
+	if err != nil {
+		return err
+	}
+
+	bySize := map[int64][]*mtpx.FileInfo{}
+	_, _, _, err = c.walk(root, true, true, *skipHidden,
+		func(objectId uint32, fi *mtpx.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !fi.IsDir && fi.Size > 0 {
+				bySize[fi.Size] = append(bySize[fi.Size], fi)
+			}
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+
+	var sets [][]*mtpx.FileInfo
+	digests := map[*mtpx.FileInfo]string{}
+	for _, files := range bySize {
+		if len(files) < 2 {
+			continue
+		}
+		if *by == dedupeBySize {
+			sets = append(sets, files)
+			continue
+		}
+
+		byDigest := map[string][]*mtpx.FileInfo{}
+		for _, fi := range files {
+			if err := canceled(); err != nil {
+				return err
+			}
+			digest, err := c.remoteDigest(fi.ObjectId, hashSHA256)
+			if err != nil {
+				return fmt.Errorf("failed to hash %s: %w", fi.FullPath, err)
+			}
+			digests[fi] = hex.EncodeToString(digest)
+			byDigest[digests[fi]] = append(byDigest[digests[fi]], fi)
+		}
+		for _, same := range byDigest {
+			if len(same) > 1 {
+				sets = append(sets, same)
+			}
+		}
+	}
+
+	// the file kept is listed first, sets by the space they waste
+	for _, set := range sets {
+		sort.Slice(set, func(i, j int) bool { return keepBefore(set[i], set[j]) })
+	}
+	sort.Slice(sets, func(i, j int) bool {
+		wi, wj := sets[i][0].Size*int64(len(sets[i])-1), sets[j][0].Size*int64(len(sets[j])-1)
+		if wi != wj {
+			return wi > wj
+		}
+		return sets[i][0].FullPath < sets[j][0].FullPath
+	})
+
+	var doomed []*mtpx.FileInfo
+	var duplicates, wasted, deleted int64
+	for _, set := range sets {
+		keep, dups := set[0], set[1:]
+		paths := make([]string, len(dups))
+		for i, fi := range dups {
+			paths[i] = fi.FullPath
+		}
+		entry := map[string]interface{}{
+			"size":       keep.Size,
+			"keep":       keep.FullPath,
+			"duplicates": paths,
+			"wasted":     keep.Size * int64(len(dups)),
+		}
+		if d, ok := digests[keep]; ok {
+			entry["digest"] = d
+		}
+		printJSON(entry)
+		duplicates += int64(len(dups))
+		wasted += keep.Size * int64(len(dups))
+		if *deleteDups {
+			doomed = append(doomed, dups...)
+		}
+	}
+
+	paths := make([]string, len(doomed))
+	for i, fi := range doomed {
+		paths[i] = fi.FullPath
+	}
+	if !newPrompter(!*dryRun, *yes).confirmDeletion("delete", paths, wasted, 0) {
+		printDeclined(len(paths))
+		doomed = nil
+	}
+	for _, fi := range doomed {
+		if *dryRun {
+			printPlanned("delete", map[string]interface{}{"path": fi.FullPath})
+			continue
+		}
+		if err := c.device.DeleteObject(fi.ObjectId); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", fi.FullPath, err)
+		}
+		deleted++
+		printJSON(map[string]interface{}{
+			"event": "deleted",
+			"path":  fi.FullPath,
+			"size":  fi.Size,
+		})
+	}
+
+	printJSON(map[string]interface{}{
+		"sets":       len(sets),
+		"duplicates": duplicates,
+		"wasted":     wasted,
+		"deleted":    deleted,
+	})
+
+	printDone("MTPX_DEDUPE_DONE")
+	return nil
+}
+
+// keepBefore orders the files of a duplicate set by which one to keep: the
+// oldest, then the one with the shortest path, which is the original more
+// often than the copies named after it
+func keepBefore(a, b *mtpx.FileInfo) bool {
+	if !a.ModTime.Equal(b.ModTime) {
+		return a.ModTime.Before(b.ModTime)
+	}
+	if len(a.FullPath) != len(b.FullPath) {
+		return len(a.FullPath) < len(b.FullPath)
+	}
+	return a.FullPath < b.FullPath
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// deleteTree deletes remote and, for a directory, everything below it one
+// object at a time, deepest first, printing a deleted event for each. A
+// directory is only deleted after confirming its file count and size on the
+// terminal, unless force is set, as it is once delete confirmed the path.
+func (c *CLI) deleteTree(remote string, force bool) error {
+	root, err := c.objectFromPath(remote)
+	if err != nil {
+		return err
+	}
+
+	// Walk lists parents before their contents, so the reverse order
+	// deletes every directory after what it holds
+	objects := []*mtpx.FileInfo{root}
+	var files, size int64
+	if root.IsDir {
+		_, _, _, err = c.walk(root.FullPath, true, false, false,
+			func(objectId uint32, fi *mtpx.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				objects = append(objects, fi)
+				if !fi.IsDir {
+					files++
+					size += fi.Size
+				}
+				return nil
+			})
+		if err != nil {
+			return err
+		}
+
+		if !force && !*dryRun {
+			if !isTerminal(os.Stdin) {
+				return usagef("refusing to delete %s with %d files without confirmation, use --force", root.FullPath, files)
+			}
+			question := fmt.Sprintf("delete %s with %d files (%s)?", root.FullPath, files, humanReadableSize(size))
+			if !newPrompter(true, false).confirm(question) {
+				return printJSON(map[string]interface{}{
+					"path":    root.FullPath,
+					"skipped": true,
+					"reason":  "declined",
+				})
+			}
+		}
+	}
+
+	for i := len(objects) - 1; i >= 0; i-- {
+		fi := objects[i]
+		if *dryRun {
+			printPlanned("delete", map[string]interface{}{"path": fi.FullPath})
+			continue
+		}
+
+		if err := c.device.DeleteObject(fi.ObjectId); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", fi.FullPath, err)
+		}
+		printJSON(map[string]interface{}{
+			"event":  "deleted",
+			"path":   fi.FullPath,
+			"size":   fi.Size,
+			"is_dir": fi.IsDir,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ganeshrvel/go-mtpfs/mtp"
+	"github.com/ganeshrvel/usb"
+)
+
+// mtpTimeout matches the request timeout mtpx.Initialize uses, in
+// milliseconds, and is the default of --timeout
+const mtpTimeout = 15000
+
+// mtpCandidate is a connected MTP device that could be opened
+type mtpCandidate struct {
+	index int
+	dev   *mtp.Device
+	info  *mtp.UsbDeviceInfo
+}
+
+func (m *mtpCandidate) vidPid() string {
+	return fmt.Sprintf("%04x:%04x", m.info.IdVendor, m.info.IdProduct)
+}
+
+// findMTPDevices opens every connected MTP device to read its USB
+// descriptors. Devices that can't be opened, for example because another
+// program holds them, are left out. The caller must close the candidates.
+func findMTPDevices(ctx *usb.Context) ([]*mtpCandidate, error) {
+	devs, err := mtp.FindDevices(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var cands []*mtpCandidate
+	for _, dev := range devs {
+		debugDevice(dev)
+		setTimeout(dev)
+		if err := dev.Open(); err != nil {
+			dev.Done()
+			continue
+		}
+
+		info, err := dev.GetUsbInfo()
+		if err != nil {
+			dev.Close()
+			dev.Done()
+			continue
+		}
+
+		cands = append(cands, &mtpCandidate{index: len(cands), dev: dev, info: info})
+	}
+
+	return cands, nil
+}
+
+func closeCandidates(cands []*mtpCandidate) {
+	for _, c := range cands {
+		c.dev.Close()
+		c.dev.Done()
+	}
+}
+
+// handleListDevices prints every connected MTP device with the values
+// --device accepts. It runs without a CLI since opening a single device fails
+// when several are connected.
+func handleListDevices(args []string) error {
+	ctx := usb.NewContext()
+	defer ctx.Exit()
+
+	cands, err := findMTPDevices(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to enumerate USB devices: %w", err)
+	}
+	defer closeCandidates(cands)
+
+	for _, c := range cands {
+		printJSON(map[string]interface{}{
+			"index":        c.index,
+			"id":           c.vidPid(),
+			"manufacturer": c.info.Manufacturer,
+			"product":      c.info.Product,
+			"serial":       c.info.SerialNumber,
+		})
+	}
+
+	printDone("MTPX_LIST_DEVICES_DONE")
+	return nil
+}
+
+// selectDevice opens the device matching selector, which is tried as a
+// serial number first, then as a vendor:product id in hex, then as an index
+// into the list-devices output
+func selectDevice(selector string) (*mtp.Device, error) {
+	cands, err := findMTPDevices(usb.NewContext())
+	if err != nil {
+		return nil, fmt.Errorf("failed to enumerate USB devices: %w", err)
+	}
+	if len(cands) == 0 {
+		return nil, errNoDevice
+	}
+
+	match, err := matchDevice(cands, selector)
+	if err != nil {
+		closeCandidates(cands)
+		return nil, err
+	}
+
+	for _, c := range cands {
+		if c != match {
+			c.dev.Close()
+			c.dev.Done()
+		}
+	}
+
+	dev := match.dev
+	setTimeout(dev)
+	if err := dev.Configure(); err != nil {
+		dev.Close()
+		dev.Done()
+		return nil, fmt.Errorf("failed to configure device %s: %w", selector, err)
+	}
+	return dev, nil
+}
+
+func matchDevice(cands []*mtpCandidate, selector string) (*mtpCandidate, error) {
+	for _, c := range cands {
+		if c.info.SerialNumber != "" && c.info.SerialNumber == selector {
+			return c, nil
+		}
+	}
+
+	var byId []*mtpCandidate
+	for _, c := range cands {
+		if strings.EqualFold(c.vidPid(), selector) {
+			byId = append(byId, c)
+		}
+	}
+	switch len(byId) {
+	case 0:
+	case 1:
+		return byId[0], nil
+	default:
+		var serials []string
+		for _, c := range byId {
+			serials = append(serials, c.info.SerialNumber)
+		}
+		return nil, fmt.Errorf("several devices match %s, select one by serial: %s", selector, strings.Join(serials, ", "))
+	}
+
+	if i, err := strconv.Atoi(selector); err == nil && i >= 0 && i < len(cands) {
+		return cands[i], nil
+	}
+
+	return nil, fmt.Errorf("no MTP device matches %s", selector)
+}
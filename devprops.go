@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ganeshrvel/go-mtpfs/mtp"
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// devicePropAliases are short names for the device properties people ask for
+var devicePropAliases = map[string]uint16{
+	"friendly-name": mtp.DPC_MTP_DeviceFriendlyName,
+	"sync-partner":  mtp.DPC_MTP_SynchronizationPartner,
+	"battery":       mtp.DPC_BatteryLevel,
+	"date-time":     mtp.DPC_DateTime,
+}
+
+// handleDevice dispatches device prop list, get and set
+func (c *CLI) handleDevice(args []string) error {
+	if len(args) < 2 || args[0] != "prop" {
+		return usagef("device requires prop list, prop get or prop set")
+	}
+	args = args[1:]
+
+	switch args[0] {
+	case "list":
+		return c.handleDevicePropList()
+	case "get":
+		if len(args) < 2 {
+			return usagef("device prop get requires a property")
+		}
+		code, err := parseDevicePropCode(args[1])
+		if err != nil {
+			return err
+		}
+		value, _, err := c.devicePropValue(code)
+		if err != nil {
+			return err
+		}
+		printJSON(map[string]interface{}{
+			"property": devicePropName(code),
+			"code":     fmt.Sprintf("0x%04X", code),
+			"value":    value,
+		})
+		printDone("MTPX_DEVICE_PROP_DONE")
+		return nil
+	case "set":
+		if len(args) < 3 {
+			return usagef("device prop set requires a property and a value")
+		}
+		return c.handleDevicePropSet(args[1], args[2])
+	}
+	return usagef("unknown device prop action %q: must be list, get or set", args[0])
+}
+
+func (c *CLI) handleDevicePropList() error {
+	info, err := mtpx.FetchDeviceInfo(c.device)
+	if err != nil {
+		return err
+	}
+
+	props := []map[string]interface{}{}
+	for _, code := range info.DevicePropertiesSupported {
+		entry := map[string]interface{}{
+			"property": devicePropName(code),
+			"code":     fmt.Sprintf("0x%04X", code),
+		}
+		value, writable, err := c.devicePropValue(code)
+		if err != nil {
+			entry["error"] = err.Error()
+		} else {
+			entry["value"] = value
+			entry["writable"] = writable
+		}
+		props = append(props, entry)
+	}
+	printJSON(props)
+
+	printDone("MTPX_DEVICE_PROP_DONE")
+	return nil
+}
+
+func (c *CLI) handleDevicePropSet(name, s string) error {
+	code, err := parseDevicePropCode(name)
+	if err != nil {
+		return err
+	}
+	dataType, writable, err := c.devicePropDesc(code)
+	if err != nil {
+		return err
+	}
+	if !writable {
+		return fmt.Errorf("%s is read-only on this device", devicePropName(code))
+	}
+	raw, err := encodePropValue(dataType, 0, s)
+	if err != nil {
+		return usagef("invalid value for %s: %v", devicePropName(code), err)
+	}
+
+	old, _, err := c.devicePropValue(code)
+	if err != nil {
+		return err
+	}
+	value, _ := decodePropValue(dataType, raw)
+
+	result := map[string]interface{}{
+		"property": devicePropName(code),
+		"code":     fmt.Sprintf("0x%04X", code),
+		"old":      old,
+		"value":    value,
+	}
+	if *dryRun {
+		return printPlanned("setprop", result)
+	}
+
+	req := mtp.Container{
+		Code:  mtp.OC_SetDevicePropValue,
+		Param: []uint32{uint32(code)},
+	}
+	var rep mtp.Container
+	if err := c.device.RunTransaction(&req, &rep, nil, bytes.NewReader(raw), int64(len(raw)), mtp.EmptyProgressFunc); err != nil {
+		return fmt.Errorf("failed to set %s: %w", devicePropName(code), err)
+	}
+	printJSON(result)
+
+	printDone("MTPX_DEVICE_PROP_DONE")
+	return nil
+}
+
+// handleBattery prints the battery level in percent
+func (c *CLI) handleBattery(args []string) error {
+	value, _, err := c.devicePropValue(mtp.DPC_BatteryLevel)
+	if err != nil {
+		return fmt.Errorf("failed to read the battery level: %w", err)
+	}
+	printJSON(map[string]interface{}{"battery": value})
+
+	printDone("MTPX_BATTERY_DONE")
+	return nil
+}
+
+// devicePropDesc reads the data type of a device property and whether it can
+// be set. Only the fixed head of the description is decoded: go-mtpfs panics
+// on the array and 128 bit types some devices declare.
+func (c *CLI) devicePropDesc(code uint16) (mtp.DataTypeSelector, bool, error) {
+	req := mtp.Container{
+		Code:  mtp.OC_GetDevicePropDesc,
+		Param: []uint32{uint32(code)},
+	}
+	var rep mtp.Container
+	var buf bytes.Buffer
+	if err := c.device.RunTransaction(&req, &rep, &buf, nil, 0, mtp.EmptyProgressFunc); err != nil {
+		return 0, false, fmt.Errorf("failed to describe %s: %w", devicePropName(code), err)
+	}
+	raw := buf.Bytes()
+	if len(raw) < 5 {
+		return 0, false, fmt.Errorf("short description of %s", devicePropName(code))
+	}
+	dataType := mtp.DataTypeSelector(binary.LittleEndian.Uint16(raw[2:]))
+	return dataType, raw[4] == mtp.DPGS_GetSet, nil
+}
+
+// devicePropValue reads a device property, decoded like object properties,
+// and whether it can be set
+func (c *CLI) devicePropValue(code uint16) (interface{}, bool, error) {
+	dataType, writable, err := c.devicePropDesc(code)
+	if err != nil {
+		return nil, false, err
+	}
+
+	req := mtp.Container{
+		Code:  mtp.OC_GetDevicePropValue,
+		Param: []uint32{uint32(code)},
+	}
+	var rep mtp.Container
+	var buf bytes.Buffer
+	if err := c.device.RunTransaction(&req, &rep, &buf, nil, 0, mtp.EmptyProgressFunc); err != nil {
+		return nil, false, fmt.Errorf("failed to read %s: %w", devicePropName(code), err)
+	}
+	value, err := decodePropValue(dataType, buf.Bytes())
+	return value, writable, err
+}
+
+// parseDevicePropCode accepts an alias from devicePropAliases, a device
+// property name such as "BatteryLevel" (case-insensitive) or a hex code
+func parseDevicePropCode(s string) (uint16, error) {
+	if code, ok := devicePropAliases[strings.ToLower(s)]; ok {
+		return code, nil
+	}
+	for code, name := range mtp.DPC_names {
+		if strings.EqualFold(name, s) {
+			return uint16(code), nil
+		}
+	}
+
+	code, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(s), "0x"), 16, 16)
+	if err != nil {
+		return 0, usagef("unknown device property %s: use a name, hex code, battery, date-time, friendly-name or sync-partner", s)
+	}
+	return uint16(code), nil
+}
+
+func devicePropName(code uint16) string {
+	if name, ok := mtp.DPC_names[int(code)]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04X", code)
+}
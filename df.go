@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// handleDf prints the capacity, used and free space of every storage, or of
+// the one selected by the argument, in bytes and in human readable units
+func (c *CLI) handleDf(args []string) error {
+	storages, err := mtpx.FetchStorages(c.device)
+	if err != nil {
+		return err
+	}
+
+	var only uint32
+	if len(args) > 0 {
+		s, err := selectStorage(storages, args[0])
+		if err != nil {
+			return err
+		}
+		only = s.Sid
+	}
+
+	for i, s := range storages {
+		if only != 0 && s.Sid != only {
+			continue
+		}
+		printJSON(storageUsage(i, s))
+	}
+
+	printDone("MTPX_DF_DONE")
+	return nil
+}
+
+// storageUsage is the df entry of the storage at index
+func storageUsage(index int, s mtpx.StorageData) map[string]interface{} {
+	capacity := s.Info.MaxCapability
+	free := s.Info.FreeSpaceInBytes
+	if free == unknownFreeSpace {
+		free = 0
+	}
+	free = min(free, capacity)
+	used := capacity - free
+	percent := 0.0
+	if capacity > 0 {
+		percent = math.Round(float64(used)/float64(capacity)*1000) / 10
+	}
+
+	return map[string]interface{}{
+		"index":          index,
+		"storage":        storageLabel(s),
+		"storage_id":     s.Sid,
+		"capacity":       capacity,
+		"used":           used,
+		"free":           free,
+		"used_percent":   percent,
+		"capacity_human": humanReadableSize(int64(capacity)),
+		"used_human":     humanReadableSize(int64(used)),
+		"free_human":     humanReadableSize(int64(free)),
+	}
+}
+
+// unknownFreeSpace is the free space of storages that don't report it
+const unknownFreeSpace = 0xFFFFFFFFFFFFFFFF
+
+// checkFreeSpace fails when size bytes don't fit in the free space of the
+// current storage, before an upload rather than halfway through it. With
+// ignore it only warns. Storages that don't report their free space pass.
+func (c *CLI) checkFreeSpace(size int64, ignore bool) error {
+	storages, err := mtpx.FetchStorages(c.device)
+	if err != nil {
+		return err
+	}
+	for _, s := range storages {
+		if s.Sid != c.storage {
+			continue
+		}
+		free := s.Info.FreeSpaceInBytes
+		if free == unknownFreeSpace || uint64(size) <= free {
+			return nil
+		}
+		msg := fmt.Sprintf("not enough free space on %s: %s (%d bytes) to upload, %s (%d bytes) free",
+			storageLabel(s), humanReadableSize(size), size, humanReadableSize(int64(free)), free)
+		if ignore {
+			log.Printf("warning: %s", msg)
+			return nil
+		}
+		return fmt.Errorf("%s, use --ignore-space to upload anyway", msg)
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Kinds of differences reported by diff
+const (
+	diffOnlyLocal  = "only_local"
+	diffOnlyRemote = "only_remote"
+	diffChanged    = "differ"
+)
+
+func (c *CLI) handleDiff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	checksum := fs.Bool("checksum", false, "compare files of the same size by sha256 instead of modification time")
+	skipHidden := fs.Bool("skip-hidden", false, "leave hidden files out on both sides")
+	filters := addFilterFlags(fs)
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) < 2 {
+		return usagef("diff requires local dir and remote dir")
+	}
+	filter, err := filters.filter()
+	if err != nil {
+		return err
+	}
+
+	localDir, err := filepath.Abs(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid local path: %w", err)
+	}
+	if _, err := os.Stat(localDir); err != nil {
+		return fmt.Errorf("invalid local path: %w", err)
+	}
+
+	// the walks of sync, so diff shows what sync would look at
+	s := &syncer{
+		cli:        c,
+		localDir:   localDir,
+		remoteDir:  remotePath(args[1]),
+		skipHidden: *skipHidden,
+		filter:     filter,
+	}
+	local, err := s.localEntries()
+	if err != nil {
+		return err
+	}
+	remote, err := s.remoteEntries()
+	if err != nil {
+		return err
+	}
+
+	// remote entries keyed by the local path download would give them
+	mapped := map[string]syncEntry{}
+	for rel, r := range remote {
+		mapped[localRel(rel)] = r
+	}
+
+	all := map[string]syncEntry{}
+	for rel, e := range local {
+		all[rel] = e
+	}
+	for rel, e := range mapped {
+		all[rel] = e
+	}
+
+	counts := map[string]int64{}
+	var same int64
+	// a directory on one side only, or a file on the other, is reported
+	// without its contents
+	var oneSided []string
+	for _, rel := range sortedKeys(all) {
+		if underAny(rel, oneSided) {
+			continue
+		}
+		l, inLocal := local[rel]
+		r, inRemote := mapped[rel]
+
+		var kind, reason string
+		switch {
+		case !inRemote:
+			kind = diffOnlyLocal
+		case !inLocal:
+			kind = diffOnlyRemote
+		default:
+			reason, err = s.difference(rel, l, r, *checksum)
+			if err != nil {
+				return err
+			}
+			if reason != "" {
+				kind = diffChanged
+			}
+		}
+
+		if kind == "" {
+			if !l.isDir {
+				same++
+			}
+			continue
+		}
+		if l.isDir || r.isDir {
+			oneSided = append(oneSided, rel)
+		}
+		counts[kind]++
+		printDiff(rel, kind, reason, l, r, inLocal, inRemote)
+	}
+
+	printJSON(map[string]interface{}{
+		diffOnlyLocal:  counts[diffOnlyLocal],
+		diffOnlyRemote: counts[diffOnlyRemote],
+		diffChanged:    counts[diffChanged],
+		"same":         same,
+	})
+
+	printDone("MTPX_DIFF_DONE")
+	return nil
+}
+
+// difference returns why the local and remote entry at rel differ, or "" when
+// they don't: type, size, checksum or mtime. Times are compared to the second
+// since MTP dates carry no fractions.
+func (s *syncer) difference(rel string, l, r syncEntry, checksum bool) (string, error) {
+	switch {
+	case l.isDir != r.isDir:
+		return "type", nil
+	case l.isDir:
+		return "", nil
+	case l.size != r.size:
+		return "size", nil
+	}
+
+	if !checksum {
+		if !l.mtime.Truncate(time.Second).Equal(r.mtime.Truncate(time.Second)) {
+			return "mtime", nil
+		}
+		return "", nil
+	}
+
+	localPath := filepath.Join(s.localDir, filepath.FromSlash(rel))
+	local, err := localDigest(localPath, hashSHA256)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", localPath, err)
+	}
+	remote, err := s.cli.remoteDigest(r.fi.ObjectId, hashSHA256)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", r.fi.FullPath, err)
+	}
+	if !bytes.Equal(local, remote) {
+		return "checksum", nil
+	}
+	return "", nil
+}
+
+// printDiff prints a difference with the size and time of each side that has
+// the entry
+func printDiff(rel, kind, reason string, l, r syncEntry, inLocal, inRemote bool) error {
+	line := map[string]interface{}{
+		"path":   rel,
+		"diff":   kind,
+		"is_dir": l.isDir || r.isDir,
+	}
+	if reason != "" {
+		line["reason"] = reason
+	}
+	if inLocal {
+		line["local_size"] = l.size
+		line["local_mtime"] = l.mtime
+	}
+	if inRemote {
+		line["remote_path"] = r.fi.FullPath
+		line["remote_size"] = r.size
+		line["remote_mtime"] = r.mtime
+	}
+	return printJSON(line)
+}
+
+// humanDiff is the human form of a difference line
+func humanDiff(fields map[string]interface{}) string {
+	label := map[interface{}]string{
+		diffOnlyLocal:  "only local",
+		diffOnlyRemote: "only remote",
+		diffChanged:    "differs",
+	}[fields["diff"]]
+	line := fmt.Sprintf("%-12s %s", label, fields["path"])
+	if fields["is_dir"] == true {
+		line += "/"
+	}
+	if reason, ok := fields["reason"].(string); ok {
+		line += "  (" + reason + ")"
+	}
+	return line
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/ganeshrvel/go-mtpfs/mtp"
+	mtpx "github.com/ganeshrvel/go-mtpx"
+	"github.com/ganeshrvel/usb"
+)
+
+// doctorCheck is the result of a single doctor check
+type doctorCheck struct {
+	Check  string `json:"check"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Hint   string `json:"hint,omitempty"`
+}
+
+// handleDoctor runs the environment checks in order and reports each of them.
+// A failed check never aborts the run; checks that depend on it are skipped.
+// A warning points at a likely cause of trouble without failing the run. It
+// opens the device itself, so it runs without a CLI.
+func handleDoctor(args []string) error {
+	var checks []doctorCheck
+	report := func(c doctorCheck) {
+		checks = append(checks, c)
+		printJSON(c)
+	}
+	skip := func(check, requires string) {
+		report(doctorCheck{Check: check, Status: "skip", Detail: "requires " + requires})
+	}
+
+	ctx := usb.NewContext()
+	defer ctx.Exit()
+
+	usbOK := false
+	if l, err := ctx.GetDeviceList(); err != nil {
+		report(doctorCheck{
+			Check:  "usb_devices",
+			Status: "fail",
+			Detail: err.Error(),
+			Hint:   "make sure libusb is installed and the USB bus is visible (pass the device through when running in a VM or container)",
+		})
+	} else {
+		report(doctorCheck{Check: "usb_devices", Status: "pass", Detail: fmt.Sprintf("%d USB devices", len(l))})
+		if len(l) > 0 {
+			l.Done()
+		}
+		usbOK = true
+	}
+
+	mtpOK := false
+	if !usbOK {
+		skip("mtp_device", "usb_devices")
+	} else if cands, err := mtp.FindDevices(ctx); err != nil || len(cands) == 0 {
+		detail := "no USB device exposes an MTP interface"
+		if err != nil {
+			detail = err.Error()
+		}
+		report(doctorCheck{
+			Check:  "mtp_device",
+			Status: "fail",
+			Detail: detail,
+			Hint:   "connect the device with a data cable, unlock it and select \"File transfer\" in its USB settings",
+		})
+	} else {
+		report(doctorCheck{Check: "mtp_device", Status: "pass", Detail: fmt.Sprintf("%d MTP devices", len(cands))})
+		for _, cand := range cands {
+			cand.Done()
+		}
+		mtpOK = true
+	}
+
+	var ifaces []*mtpInterface
+	probeOK := false
+	if !mtpOK {
+		skip("usb_access", "mtp_device")
+	} else if found, err := probeMTPInterfaces(ctx, false); err != nil {
+		report(doctorCheck{Check: "usb_access", Status: "fail", Detail: err.Error()})
+	} else {
+		ifaces = found
+		probeOK = true
+		report(accessCheck(ifaces))
+	}
+
+	if runtime.GOOS == "linux" {
+		if !probeOK {
+			skip("udev_rules", "usb_access")
+		} else {
+			report(udevCheck(ifaces))
+		}
+	}
+
+	clients := mtpClients()
+	if !probeOK {
+		skip("kernel_driver", "usb_access")
+		skip("interface_claim", "usb_access")
+	} else {
+		report(kernelDriverCheck(ifaces))
+		report(claimCheck(ifaces, clients))
+	}
+
+	if len(clients) == 0 {
+		report(doctorCheck{Check: "mtp_clients", Status: "pass", Detail: "no other MTP client is running"})
+	} else {
+		report(doctorCheck{
+			Check:  "mtp_clients",
+			Status: "warn",
+			Detail: fmt.Sprintf("running: %s", joinClients(clients)),
+			Hint:   "these may claim the device whenever it is plugged in; " + mtpClientHint(clients),
+		})
+	}
+
+	var dev *mtp.Device
+	if !mtpOK {
+		skip("initialize", "mtp_device")
+	} else if d, err := initDevice(); err != nil {
+		report(doctorCheck{Check: "initialize", Status: "fail", Detail: err.Error(), Hint: initializeHint(err)})
+	} else {
+		dev = d
+		defer mtpx.Dispose(dev)
+		report(doctorCheck{Check: "initialize", Status: "pass"})
+	}
+
+	var storages []mtpx.StorageData
+	if dev == nil {
+		skip("storages", "initialize")
+	} else if s, err := mtpx.FetchStorages(dev); err != nil || len(s) == 0 {
+		detail := "the device reports no storage"
+		if err != nil {
+			detail = err.Error()
+		}
+		report(doctorCheck{
+			Check:  "storages",
+			Status: "fail",
+			Detail: detail,
+			Hint:   "unlock the device screen and allow access to device data, storages stay hidden while it is locked",
+		})
+	} else {
+		storages = s
+		report(doctorCheck{Check: "storages", Status: "pass", Detail: fmt.Sprintf("%d storages", len(s))})
+	}
+
+	if len(storages) == 0 {
+		skip("storage_writable", "storages")
+	} else if storage, err := selectStorage(storages, *storageSel); err != nil {
+		report(doctorCheck{
+			Check:  "storage_writable",
+			Status: "fail",
+			Detail: err.Error(),
+			Hint:   "pick one of the storages listed by storage-info with --storage",
+		})
+	} else {
+		info := storage.Info
+		if info.AccessCapability == mtp.AC_ReadWrite {
+			report(doctorCheck{Check: "storage_writable", Status: "pass", Detail: info.StorageDescription})
+		} else {
+			report(doctorCheck{
+				Check:  "storage_writable",
+				Status: "fail",
+				Detail: fmt.Sprintf("%s is read-only", info.StorageDescription),
+				Hint:   "check for a write-protected SD card; uploads and deletes will fail on this storage",
+			})
+		}
+	}
+
+	failed, warnings := 0, 0
+	for _, c := range checks {
+		switch c.Status {
+		case "fail":
+			failed++
+		case "warn":
+			warnings++
+		}
+	}
+	printJSON(map[string]interface{}{
+		"checks":   len(checks),
+		"failed":   failed,
+		"warnings": warnings,
+	})
+
+	printDone("MTPX_DOCTOR_DONE")
+
+	if failed > 0 {
+		return fmt.Errorf("doctor: %d of %d checks failed", failed, len(checks))
+	}
+	return nil
+}
+
+// initializeHint suggests a fix for a failed mtpx.Initialize
+func initializeHint(err error) string {
+	if strings.Contains(err.Error(), "more than 1 device") {
+		return "several devices are connected, pick one with --device (see list-devices)"
+	}
+
+	var configureErr mtpx.ConfigureError
+	if errors.As(err, &configureErr) {
+		return "unlock the device screen and confirm the \"Allow access to device data\" prompt, then reconnect"
+	}
+
+	switch runtime.GOOS {
+	case "linux":
+		return "add a udev rule granting your user access to the device, and stop gvfs or other MTP clients holding it"
+	case "darwin":
+		return "quit Android File Transfer, Image Capture or other apps holding the device"
+	}
+	return "close other applications holding the device and reconnect it"
+}
+
+// accessCheck fails when a device can't be opened, which on Linux usually
+// means that no udev rule grants the user access to it
+func accessCheck(ifaces []*mtpInterface) doctorCheck {
+	for _, m := range ifaces {
+		if m.openErr == nil {
+			continue
+		}
+		c := doctorCheck{Check: "usb_access", Status: "fail", Detail: fmt.Sprintf("opening %s: %v", m.vidPid(), m.openErr)}
+		if errors.Is(m.openErr, usb.ERROR_ACCESS) {
+			c.Hint = "your user may not open the device"
+			if runtime.GOOS == "linux" {
+				c.Hint += ", add a udev rule for it and reconnect: " + udevRule(m.vendor)
+			}
+		}
+		return c
+	}
+	return doctorCheck{Check: "usb_access", Status: "pass", Detail: fmt.Sprintf("%d MTP interfaces opened", len(ifaces))}
+}
+
+// udevCheck looks for udev rules naming the vendors of the devices. A device
+// opened without one is fine, the user may have access through a group.
+func udevCheck(ifaces []*mtpInterface) doctorCheck {
+	var rules []string
+	for _, m := range ifaces {
+		rule := udevRuleFor(m.vendor)
+		if rule != "" {
+			rules = append(rules, rule)
+			continue
+		}
+		if m.openErr != nil {
+			return doctorCheck{
+				Check:  "udev_rules",
+				Status: "fail",
+				Detail: fmt.Sprintf("no udev rule names vendor %04x", m.vendor),
+				Hint:   "install libmtp's udev rules, or add one such as: " + udevRule(m.vendor),
+			}
+		}
+	}
+	if len(rules) == 0 {
+		return doctorCheck{Check: "udev_rules", Status: "pass", Detail: "not needed, the devices opened without one"}
+	}
+	return doctorCheck{Check: "udev_rules", Status: "pass", Detail: strings.Join(rules, ", ")}
+}
+
+// udevRule is a rule granting the logged in user access to the vendor's
+// devices, for /etc/udev/rules.d
+func udevRule(vendor uint16) string {
+	return fmt.Sprintf(`SUBSYSTEM=="usb", ATTR{idVendor}=="%04x", MODE="0660", TAG+="uaccess"`, vendor)
+}
+
+// kernelDriverCheck fails when a kernel driver is bound to an MTP interface,
+// which keeps libusb from claiming it
+func kernelDriverCheck(ifaces []*mtpInterface) doctorCheck {
+	for _, m := range ifaces {
+		if !m.kernelDriver {
+			continue
+		}
+		driver := kernelDriverName(m)
+		c := doctorCheck{
+			Check:  "kernel_driver",
+			Status: "fail",
+			Detail: fmt.Sprintf("a kernel driver is bound to the MTP interface of %s", m.vidPid()),
+			Hint:   "unload the driver or blacklist it, then reconnect the device",
+		}
+		if driver != "" {
+			c.Detail = fmt.Sprintf("kernel driver %s is bound to the MTP interface of %s", driver, m.vidPid())
+			c.Hint = fmt.Sprintf("unload it with: sudo modprobe -r %s, or blacklist it in /etc/modprobe.d, then reconnect the device", driver)
+		}
+		return c
+	}
+	return doctorCheck{Check: "kernel_driver", Status: "pass"}
+}
+
+// claimCheck fails when an MTP interface is claimed by another program,
+// naming the running MTP clients as the likely holders
+func claimCheck(ifaces []*mtpInterface, clients []mtpClient) doctorCheck {
+	for _, m := range ifaces {
+		if m.openErr != nil || m.kernelDriver || m.claimErr == nil {
+			continue
+		}
+		c := doctorCheck{
+			Check:  "interface_claim",
+			Status: "fail",
+			Detail: fmt.Sprintf("claiming the MTP interface of %s: %v", m.vidPid(), m.claimErr),
+			Hint:   mtpClientHint(clients),
+		}
+		if errors.Is(m.claimErr, usb.ERROR_BUSY) {
+			c.Detail = fmt.Sprintf("the MTP interface of %s is claimed by another program", m.vidPid())
+			if len(clients) > 0 {
+				c.Detail += ", likely " + joinClients(clients)
+			}
+		}
+		return c
+	}
+	return doctorCheck{Check: "interface_claim", Status: "pass"}
+}
+
+func joinClients(clients []mtpClient) string {
+	s := make([]string, len(clients))
+	for i, c := range clients {
+		s[i] = c.String()
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// downloader copies remote files and directories to the local disk
+type downloader struct {
+	cli        *CLI
+	rawNames   bool
+	skipHidden bool
+	chunkSize  int
+
+	// skipExisting leaves local files alone that match the remote file
+	// according to compare
+	skipExisting bool
+	compare      string
+
+	// onConflict is the --on-conflict policy for existing local files,
+	// empty overwrites them
+	onConflict string
+
+	// filter leaves objects of a directory download out, nil keeps all
+	filter *pathFilter
+
+	// format limits the files of a directory download, nil keeps all
+	format *formatFilter
+
+	// age limits the files by modification time, nil keeps all
+	age *ageFilter
+
+	// limiter caps the transfer rate, nil means unlimited
+	limiter *rateLimiter
+
+	// resume continues partial local files instead of starting over
+	resume bool
+
+	// verify names the hash algorithm that downloaded files are checked
+	// with against a second read of the remote object, empty for none
+	verify string
+
+	// concurrency is the number of transfer queue workers
+	concurrency int
+
+	// rename names files after --rename-template, nil keeps their names
+	rename *renameTemplate
+
+	// staged downloads go to a temporary path that the caller moves into
+	// place, the caller runs --on-file-complete
+	staged bool
+
+	// files and bytes transferred so far, guarded by mu
+	mu          sync.Mutex
+	files, size int64
+}
+
+// partSuffix marks a local file that is still being downloaded
+const partSuffix = ".part"
+
+// resumeChunkSize is the partial transfer size for resumed downloads when no
+// --chunk-size is given
+const resumeChunkSize = 4 * 1024 * 1024
+
+// Criteria for download --compare
+const (
+	compareSize      = "size"
+	compareSizeMtime = "size-mtime"
+)
+
+func validateCompare(compare string) error {
+	switch compare {
+	case compareSize, compareSizeMtime:
+		return nil
+	}
+	return usagef("invalid --compare %q: must be %s or %s", compare, compareSize, compareSizeMtime)
+}
+
+// download copies the remote object at remotePath into targetDir. Directories
+// are downloaded with their whole subtree.
+func (d *downloader) download(remotePath, targetDir string) error {
+	c := d.cli
+
+	var root *mtpx.FileInfo
+	err := c.withDevice(func() (err error) {
+		root, err = c.objectFromPath(remotePath)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if !root.IsDir {
+		if !d.age.match(root) {
+			transfers.skip()
+			return printJSON(map[string]interface{}{
+				"path":    root.FullPath,
+				"skipped": true,
+				"reason":  "age",
+			})
+		}
+		return d.downloadFile(root, d.filePath(root, targetDir))
+	}
+
+	rootDir := targetDir
+	if root.FullPath != "/" {
+		rootDir = filepath.Join(targetDir, d.localName(root.Name))
+	}
+	if err := makeLocalDir(rootDir); err != nil {
+		return err
+	}
+
+	// local directory of every remote directory seen so far
+	localDirs := map[string]string{path.Clean(root.FullPath): rootDir}
+	hidden := newHiddenFilter(c)
+	var dirs int64
+
+	q := newTransferQueue(c, d.concurrency, d.concurrency > 1)
+	err = q.produce(func() error {
+		_, _, _, err := c.walk(root.FullPath, true, true, d.skipHidden,
+			func(objectId uint32, fi *mtpx.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if d.skipHidden && hidden.skip(fi) {
+					return nil
+				}
+				if rel, ok := relRemotePath(root.FullPath, fi.FullPath); ok && d.filter.skip(rel, fi.IsDir) {
+					return nil
+				}
+
+				parentDir, ok := localDirs[path.Clean(fi.ParentPath)]
+				if !ok {
+					return fmt.Errorf("no local directory for %s", fi.ParentPath)
+				}
+				if fi.IsDir {
+					localPath := filepath.Join(parentDir, d.localName(fi.Name))
+					if err := makeLocalDir(localPath); err != nil {
+						return err
+					}
+					localDirs[path.Clean(fi.FullPath)] = localPath
+					dirs++
+					return nil
+				}
+
+				if !d.format.match(fi) || !d.age.match(fi) {
+					return nil
+				}
+				localPath := d.filePath(fi, parentDir)
+				return q.add(fi.Size, func() error { return d.downloadFile(fi, localPath) })
+			})
+		return err
+	})
+	if qerr := q.wait(); err == nil {
+		err = qerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return printJSON(map[string]interface{}{
+		"files":       d.files,
+		"directories": dirs,
+		"size":        d.size,
+	})
+}
+
+// downloadFile copies a single remote file to localPath, emitting progress
+// lines and a transfer summary. A transfer that fails with the session is
+// retried on a new one, see retry.
+func (d *downloader) downloadFile(fi *mtpx.FileInfo, localPath string) error {
+	c := d.cli
+	return c.retry(fi.FullPath, func(attempt int) error {
+		if attempt > 0 {
+			err := c.withDevice(func() (err error) {
+				fi, err = c.objectFromPath(fi.FullPath)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return d.fetchFile(fi, localPath)
+	})
+}
+
+func (d *downloader) fetchFile(fi *mtpx.FileInfo, localPath string) error {
+	if d.skipExisting && d.unchanged(fi, localPath) {
+		transfers.skip()
+		return printJSON(map[string]interface{}{
+			"path":    fi.FullPath,
+			"target":  localPath,
+			"skipped": true,
+			"reason":  "unchanged",
+		})
+	}
+
+	if d.onConflict != "" && d.onConflict != conflictOverwrite {
+		if st, err := os.Lstat(localPath); err == nil {
+			if d.onConflict == conflictRename {
+				localPath = freeLocalPath(localPath)
+			} else if reason := checkConflict(d.onConflict, fi.ModTime, st.ModTime()); reason != "" {
+				return printConflictSkip(fi.FullPath, localPath, reason)
+			}
+		}
+	}
+
+	if *dryRun {
+		d.count(fi.Size)
+		return printPlanned("download", map[string]interface{}{
+			"source": fi.FullPath,
+			"target": localPath,
+			"size":   fi.Size,
+		})
+	}
+
+	// the file is written as .part and only takes its name once complete
+	partPath := localPath + partSuffix
+	var offset int64
+	if d.resume {
+		if st, err := os.Stat(localPath); err == nil && st.Mode().IsRegular() && st.Size() == fi.Size && fi.Size > 0 {
+			transfers.skip()
+			return printJSON(map[string]interface{}{
+				"path":    fi.FullPath,
+				"target":  localPath,
+				"skipped": true,
+				"reason":  "complete",
+			})
+		}
+		offset = d.resumeOffset(fi, partPath)
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create local file: %w", err)
+	}
+	progress.begin(fi.ObjectId)
+
+	var w io.Writer = f
+	if d.limiter != nil {
+		w = &throttledWriter{w: f, l: d.limiter}
+	}
+
+	err = d.cli.withDevice(func() error {
+		switch {
+		case offset > 0:
+			if err := progress.update(fi.ObjectId, fi.Name, fi.FullPath, offset, fi.Size); err != nil {
+				return err
+			}
+			return d.fetchChunks(fi, w, offset, cmp.Or(int64(d.chunkSize), resumeChunkSize))
+		case d.chunkSize > 0:
+			return d.fetchChunks(fi, w, 0, int64(d.chunkSize))
+		}
+		return d.cli.device.GetObject(fi.ObjectId, w, func(sent int64) error {
+			return progress.update(fi.ObjectId, fi.Name, fi.FullPath, sent, fi.Size)
+		})
+	})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		// a partial file is what --resume continues from
+		if !d.resume {
+			os.Remove(partPath)
+		}
+		return fmt.Errorf("failed to download %s: %w", fi.FullPath, err)
+	}
+
+	// keep the remote modification time, devices without dates report none
+	if !fi.ModTime.IsZero() {
+		if err := os.Chtimes(partPath, time.Now(), fi.ModTime); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(partPath, localPath); err != nil {
+		return fmt.Errorf("failed to download %s: %w", fi.FullPath, err)
+	}
+
+	progress.complete(fi.ObjectId, fi.Name, fi.FullPath, localPath, fi.Size)
+	d.count(fi.Size)
+
+	if d.verify != "" {
+		if err := d.cli.verifyTransfer(localPath, fi, d.verify); err != nil {
+			return err
+		}
+	}
+	if !d.staged {
+		fileCompleted(directionDownload, fi.FullPath, localPath, fi.Size)
+	}
+	return nil
+}
+
+func (d *downloader) count(size int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.files++
+	d.size += size
+}
+
+// unchanged reports whether localPath already holds the remote file. Modification
+// times are compared to the second since MTP dates carry no fractions.
+func (d *downloader) unchanged(fi *mtpx.FileInfo, localPath string) bool {
+	st, err := os.Stat(localPath)
+	if err != nil || !st.Mode().IsRegular() || st.Size() != fi.Size {
+		return false
+	}
+	if d.compare == compareSize {
+		return true
+	}
+	return st.ModTime().Truncate(time.Second).Equal(fi.ModTime.Truncate(time.Second))
+}
+
+// resumeOffset returns the size of the partial copy of fi at partPath, or 0
+// when there is none or it can't belong to fi because it is larger. A .part
+// file of the full size was complete except for its rename.
+func (d *downloader) resumeOffset(fi *mtpx.FileInfo, partPath string) int64 {
+	st, err := os.Stat(partPath)
+	if err != nil || !st.Mode().IsRegular() {
+		return 0
+	}
+	if st.Size() > fi.Size {
+		log.Printf("warning: %s is larger than %s, downloading it again", partPath, fi.FullPath)
+		return 0
+	}
+	return st.Size()
+}
+
+// fetchChunks reads the remote file from offset on with Android partial
+// object transfers of chunk bytes
+func (d *downloader) fetchChunks(fi *mtpx.FileInfo, w io.Writer, offset, chunk int64) error {
+	for offset < fi.Size {
+		n := min(chunk, fi.Size-offset)
+		if err := d.cli.device.AndroidGetPartialObject64(fi.ObjectId, w, offset, uint32(n)); err != nil {
+			return err
+		}
+		offset += n
+		if err := progress.update(fi.ObjectId, fi.Name, fi.FullPath, offset, fi.Size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// localName returns the local file name for a remote object name and reports
+// when it had to be changed
+func (d *downloader) localName(name string) string {
+	safe := confinedName(name)
+	if !d.rawNames {
+		safe = safeLocalName(name)
+	}
+	if safe != name {
+		printJSON(map[string]string{
+			"renamed_from": name,
+			"renamed_to":   safe,
+		})
+	}
+	return safe
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"path"
+	"sort"
+	"strings"
+
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// duEntry is the usage of a remote directory and everything below it
+type duEntry struct {
+	size  int64
+	files int64
+}
+
+// handleDu prints the total size and file count of each directory below a
+// remote path, children before their parent and the path itself last, like
+// du(1). --depth limits the directories printed, not the walk.
+func (c *CLI) handleDu(args []string) error {
+	fs := flag.NewFlagSet("du", flag.ContinueOnError)
+	depth := fs.Int("depth", -1, "only print directories this many levels below the path (-1 prints all)")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) < 1 {
+		return usagef("du requires a remote path")
+	}
+	if *depth < -1 {
+		return usagef("invalid --depth %d: must be -1 or more", *depth)
+	}
+
+	root, err := c.resolveRemote(args[0])
+	if err != nil {
+		return err
+	}
+	root = path.Clean(root)
+
+	fi, err := c.objectFromPath(root)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir {
+		printJSON(map[string]interface{}{
+			"path":  fi.FullPath,
+			"size":  fi.Size,
+			"files": 1,
+		})
+		printDone("MTPX_DU_DONE")
+		return nil
+	}
+
+	level := func(dir string) int {
+		rel := strings.Trim(strings.TrimPrefix(dir, root), "/")
+		if rel == "" {
+			return 0
+		}
+		return strings.Count(rel, "/") + 1
+	}
+	shown := func(dir string) bool {
+		return *depth < 0 || level(dir) <= *depth
+	}
+
+	usage := map[string]*duEntry{root: {}}
+	_, _, _, err = c.walk(root, true, true, false,
+		func(objectId uint32, fi *mtpx.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			p := path.Clean(fi.FullPath)
+			if fi.IsDir {
+				if shown(p) && usage[p] == nil {
+					usage[p] = &duEntry{}
+				}
+				return nil
+			}
+
+			// the file counts towards every printed directory above it
+			for dir := path.Dir(p); ; dir = path.Dir(dir) {
+				if shown(dir) {
+					if usage[dir] == nil {
+						usage[dir] = &duEntry{}
+					}
+					usage[dir].size += fi.Size
+					usage[dir].files++
+				}
+				if dir == root || dir == "/" {
+					break
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+
+	// a path sorts after everything below it with a trailing \xff
+	dirs := make([]string, 0, len(usage))
+	for dir := range usage {
+		dirs = append(dirs, dir)
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		return dirs[i]+"\xff" < dirs[j]+"\xff"
+	})
+
+	for _, dir := range dirs {
+		printJSON(map[string]interface{}{
+			"path":  dir,
+			"size":  usage[dir].size,
+			"files": usage[dir].files,
+		})
+	}
+
+	printDone("MTPX_DU_DONE")
+	return nil
+}
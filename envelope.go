@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// envelopeVersion is the "v" field of every output line. It changes only
+// when existing fields change meaning or go away.
+const envelopeVersion = 1
+
+// Envelope types
+const (
+	typeEntry    = "entry"
+	typeProgress = "progress"
+	typeDone     = "done"
+	typeError    = "error"
+)
+
+// writeEnvelope writes v as one line of type typ. Objects get the "type" and
+// "v" fields in front of their own, which therefore must not use those names;
+// other values are wrapped as "data". With --legacy-output v is written as is,
+// and in human output mode stdout gets writeHuman's rendering instead.
+func writeEnvelope(w io.Writer, typ string, v interface{}) error {
+	if humanOutput && w == os.Stdout {
+		return writeHuman(typ, v)
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	if !*legacyOutput {
+		b = wrapEnvelope(typ, b)
+	}
+
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	fmt.Fprintln(w, string(b))
+	return nil
+}
+
+func wrapEnvelope(typ string, b []byte) []byte {
+	head := fmt.Sprintf(`{"type":%q,"v":%d`, typ, envelopeVersion)
+	switch {
+	case string(b) == "{}":
+		return []byte(head + "}")
+	case len(b) > 0 && b[0] == '{':
+		return append([]byte(head+","), b[1:]...)
+	}
+	return []byte(head + `,"data":` + string(b) + "}")
+}
+
+// doneCommand turns a completion sentinel such as MTPX_DEVICE_INFO_DONE into
+// the command name device-info
+func doneCommand(sentinel string) string {
+	name := strings.TrimSuffix(strings.TrimPrefix(sentinel, "MTPX_"), "_DONE")
+	return strings.ReplaceAll(strings.ToLower(name), "_", "-")
+}
+
+// rawStdout is set once a command wrote file data to stdout, which an error
+// line would corrupt
+var rawStdout bool
+
+// printError reports a failed command as an error line with its errorCode.
+// Legacy output and commands writing file data to stdout only have the
+// message on stderr.
+func printError(err error) {
+	if *legacyOutput || (rawStdout && resultOut == os.Stdout) {
+		return
+	}
+	writeEnvelope(resultOut, typeError, map[string]string{
+		"error": err.Error(),
+		"code":  errorCode(err),
+	})
+}
+
+// fatal reports err and exits with its exit code
+func fatal(err error) {
+	printError(err)
+	log.Print(err)
+	os.Exit(exitCode(err))
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ganeshrvel/go-mtpfs/mtp"
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// Error codes of error lines. Each has its own exit status, see exitCodes.
+const (
+	codeFailed         = "EFAILED"
+	codeUsage          = "EUSAGE"
+	codeNoDevice       = "ENODEV"
+	codeRemoteNotFound = "ENOENT_REMOTE"
+	codeLocalNotFound  = "ENOENT_LOCAL"
+	codeAccess         = "EACCES"
+	codeTransfer       = "ETRANSFER"
+	codeCanceled       = "ECANCELED"
+)
+
+var exitCodes = map[string]int{
+	codeFailed:         1,
+	codeUsage:          2,
+	codeNoDevice:       3,
+	codeRemoteNotFound: 4,
+	codeLocalNotFound:  5,
+	codeAccess:         6,
+	codeTransfer:       7,
+	codeCanceled:       130,
+}
+
+// errNoStorage is returned when a device exposes no storage, which on most
+// phones means the screen is locked or USB file transfer isn't enabled
+var errNoStorage = errors.New("no storage found")
+
+// errNoDevice is returned when no MTP device is connected
+var errNoDevice = errors.New("no MTP devices found")
+
+// usageError is a command line that can't be run as given
+type usageError struct {
+	err error
+}
+
+func (e usageError) Error() string { return e.err.Error() }
+func (e usageError) Unwrap() error { return e.err }
+
+func usagef(format string, args ...interface{}) error {
+	return usageError{fmt.Errorf(format, args...)}
+}
+
+// transferError is a file transfer that failed for good, after retries
+type transferError struct {
+	err error
+}
+
+func (e transferError) Error() string { return e.err.Error() }
+func (e transferError) Unwrap() error { return e.err }
+
+func transferFailed(err error) error {
+	if err == nil {
+		return nil
+	}
+	return transferError{err}
+}
+
+// notFoundError is a remote path or object that doesn't exist, found missing
+// by a lookup of our own rather than by go-mtpx
+type notFoundError struct {
+	err error
+}
+
+func (e notFoundError) Error() string { return e.err.Error() }
+func (e notFoundError) Unwrap() error { return e.err }
+
+func notFoundf(format string, args ...interface{}) error {
+	return notFoundError{fmt.Errorf(format, args...)}
+}
+
+// isNotFound reports whether err says that a remote path doesn't exist.
+// errorCode maps it to codeRemoteNotFound and http to 404.
+func isNotFound(err error) bool {
+	return errors.As(err, new(mtpx.FileNotFoundError)) || errors.As(err, new(mtpx.InvalidPathError)) ||
+		errors.As(err, new(notFoundError)) || errors.Is(err, errNoGlobMatch)
+}
+
+// errorCode classifies err for error lines and the exit status. Missing
+// paths and access problems win over the transfer they made fail.
+func errorCode(err error) string {
+	var rc mtp.RCError
+	switch {
+	case errors.Is(err, errCanceled):
+		return codeCanceled
+	case errors.As(err, new(usageError)):
+		return codeUsage
+	case errors.As(err, new(interfaceBusyError)):
+		return codeAccess
+	case errors.Is(err, errNoDevice), errors.As(err, new(mtpx.MtpDetectFailedError)):
+		return codeNoDevice
+	case errors.Is(err, errNoStorage), errors.As(err, new(mtpx.ConfigureError)):
+		return codeAccess
+	case errors.As(err, &rc) && (rc == mtp.RC_AccessDenied || rc == mtp.RC_StoreReadOnly):
+		return codeAccess
+	case isNotFound(err):
+		return codeRemoteNotFound
+	case errors.Is(err, os.ErrNotExist):
+		return codeLocalNotFound
+	case errors.Is(err, os.ErrPermission):
+		return codeAccess
+	case errors.As(err, new(transferError)):
+		return codeTransfer
+	}
+	return codeFailed
+}
+
+// exitCode returns the exit status for err
+func exitCode(err error) int {
+	return exitCodes[errorCode(err)]
+}
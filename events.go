@@ -0,0 +1,143 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/ganeshrvel/go-mtpfs/mtp"
+)
+
+// allObjects asks GetObjectHandles for every object of a storage, not only
+// the children of one parent
+const allObjects = 0xFFFFFFFF
+
+// handleEvents reports object and storage changes as event lines until
+// interrupted. go-mtpfs doesn't expose the interrupt endpoint the device
+// sends its events on, so they are derived from polling the object handles
+// of every storage, which costs one request per storage and poll.
+func (c *CLI) handleEvents(args []string) error {
+	fs := flag.NewFlagSet("events", flag.ContinueOnError)
+	interval := fs.Duration("interval", 2*time.Second, "time between polls of the device")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if *interval <= 0 {
+		return usagef("--interval must be positive")
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	// object handles by storage as of the last poll, nil until the first one
+	var prev map[uint32]map[uint32]bool
+	// paths of the objects reported as added, for their removal
+	paths := map[uint32]string{}
+
+	for {
+		cur, err := c.objectHandles()
+		switch {
+		case err != nil && !c.sessionAlive():
+			printEvent(mtp.EC_DeviceReset, map[string]interface{}{"error": err.Error()})
+			c.reconnectIfDead()
+			prev = nil
+		case err != nil:
+			printJSON(map[string]string{
+				"event": "error",
+				"error": err.Error(),
+			})
+		default:
+			if prev != nil {
+				c.diffEvents(prev, cur, paths)
+			}
+			prev = cur
+		}
+
+		select {
+		case <-sig:
+			printDone("MTPX_EVENTS_DONE")
+			return nil
+		case <-time.After(*interval):
+		}
+	}
+}
+
+// objectHandles returns the handles of all objects by storage
+func (c *CLI) objectHandles() (map[uint32]map[uint32]bool, error) {
+	var sids mtp.Uint32Array
+	if err := c.device.GetStorageIDs(&sids); err != nil {
+		return nil, fmt.Errorf("failed to list storages: %w", err)
+	}
+
+	stores := map[uint32]map[uint32]bool{}
+	for _, sid := range sids.Values {
+		var handles mtp.Uint32Array
+		if err := c.device.GetObjectHandles(sid, 0, allObjects, &handles); err != nil {
+			return nil, fmt.Errorf("failed to list objects of storage %d: %w", sid, err)
+		}
+		objects := make(map[uint32]bool, len(handles.Values))
+		for _, h := range handles.Values {
+			objects[h] = true
+		}
+		stores[sid] = objects
+	}
+	return stores, nil
+}
+
+// diffEvents prints the events that lead from prev to cur. The objects of an
+// added or removed storage are not reported one by one.
+func (c *CLI) diffEvents(prev, cur map[uint32]map[uint32]bool, paths map[uint32]string) {
+	for sid, objects := range cur {
+		before, ok := prev[sid]
+		if !ok {
+			printEvent(mtp.EC_StoreAdded, map[string]interface{}{"storage": sid})
+			continue
+		}
+		for h := range objects {
+			if before[h] {
+				continue
+			}
+			fields := map[string]interface{}{"storage": sid, "object_id": h}
+			var info mtp.ObjectInfo
+			if err := c.device.GetObjectInfo(h, &info); err == nil {
+				fields["size"] = info.CompressedSize
+				fields["format"] = codeName(info.ObjectFormat, mtp.OFC_names)
+			}
+			// objects can be gone again by the time they are looked up
+			if p, err := objectPath(c.device, h); err == nil {
+				fields["path"] = p
+				paths[h] = p
+			}
+			printEvent(mtp.EC_ObjectAdded, fields)
+		}
+		for h := range before {
+			if objects[h] {
+				continue
+			}
+			fields := map[string]interface{}{"storage": sid, "object_id": h}
+			if p, ok := paths[h]; ok {
+				fields["path"] = p
+				delete(paths, h)
+			}
+			printEvent(mtp.EC_ObjectRemoved, fields)
+		}
+	}
+	for sid := range prev {
+		if _, ok := cur[sid]; !ok {
+			printEvent(mtp.EC_StoreRemoved, map[string]interface{}{"storage": sid})
+		}
+	}
+}
+
+// printEvent prints an event line named after the MTP event code
+func printEvent(code uint16, fields map[string]interface{}) {
+	fields["event"] = codeName(code, mtp.EC_names)
+	fields["code"] = fmt.Sprintf("0x%04X", code)
+	fields["time"] = time.Now().UTC().Format(time.RFC3339)
+	printJSON(fields)
+}
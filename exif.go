@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// EXIF tags holding the capture time, preferred in this order
+const (
+	exifDateTimeOriginal  = 0x9003
+	exifDateTimeDigitized = 0x9004
+	exifDateTime          = 0x0132
+	exifIFDPointer        = 0x8769
+)
+
+// exifDate returns the capture time recorded in the EXIF data of the JPEG
+// file, in local time since EXIF carries no zone. ok is false for files
+// without it.
+func exifDate(file string) (t time.Time, ok bool) {
+	f, err := os.Open(file)
+	if err != nil {
+		return t, false
+	}
+	defer f.Close()
+
+	tiff := jpegExif(bufio.NewReader(f))
+	if tiff == nil {
+		return t, false
+	}
+	return parseExifDate(tiff)
+}
+
+// jpegExif returns the TIFF structure of the Exif APP1 segment, nil when the
+// file is no JPEG or has none
+func jpegExif(r *bufio.Reader) []byte {
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil || soi != [2]byte{0xFF, 0xD8} {
+		return nil
+	}
+
+	for {
+		var hdr [4]byte
+		if _, err := io.ReadFull(r, hdr[:2]); err != nil || hdr[0] != 0xFF {
+			return nil
+		}
+		marker := hdr[1]
+		// start of scan or end of image, no metadata follows
+		if marker == 0xDA || marker == 0xD9 {
+			return nil
+		}
+		if _, err := io.ReadFull(r, hdr[2:]); err != nil {
+			return nil
+		}
+		n := int(binary.BigEndian.Uint16(hdr[2:])) - 2
+		if n < 0 {
+			return nil
+		}
+
+		if marker != 0xE1 {
+			if _, err := r.Discard(n); err != nil {
+				return nil
+			}
+			continue
+		}
+		seg := make([]byte, n)
+		if _, err := io.ReadFull(r, seg); err != nil {
+			return nil
+		}
+		if bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return seg[6:]
+		}
+	}
+}
+
+// parseExifDate reads the capture time from a TIFF structure
+func parseExifDate(tiff []byte) (time.Time, bool) {
+	if len(tiff) < 8 {
+		return time.Time{}, false
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return time.Time{}, false
+	}
+
+	ifd0 := exifIFD(tiff, order, order.Uint32(tiff[4:]))
+	tags := ifd0
+	if off, ok := ifd0[exifIFDPointer]; ok {
+		tags = exifIFD(tiff, order, order.Uint32(off))
+	}
+
+	for _, tag := range []uint16{exifDateTimeOriginal, exifDateTimeDigitized} {
+		if t, ok := exifTime(tiff, order, tags[tag]); ok {
+			return t, true
+		}
+	}
+	return exifTime(tiff, order, ifd0[exifDateTime])
+}
+
+// exifIFD returns the raw 4 byte value fields of the entries of the IFD at
+// offset, by tag
+func exifIFD(tiff []byte, order binary.ByteOrder, offset uint32) map[uint16][]byte {
+	entries := map[uint16][]byte{}
+	if int64(offset)+2 > int64(len(tiff)) {
+		return entries
+	}
+	n := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < n; i++ {
+		start := int(offset) + 2 + 12*i
+		if start+12 > len(tiff) {
+			break
+		}
+		entry := tiff[start : start+12]
+		entries[order.Uint16(entry)] = entry[8:12]
+	}
+	return entries
+}
+
+// exifTime parses an ASCII date value field, which points to its text
+func exifTime(tiff []byte, order binary.ByteOrder, value []byte) (time.Time, bool) {
+	if value == nil {
+		return time.Time{}, false
+	}
+	const layout = "2006:01:02 15:04:05"
+	off := int(order.Uint32(value))
+	if off+len(layout) > len(tiff) {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation(layout, string(tiff[off:off+len(layout)]), time.Local)
+	return t, err == nil
+}
+
+// exifHeadSize is how much of a remote JPEG is read for its EXIF data, which
+// sits in the first segments and is at most 64 KiB
+const exifHeadSize = 128 * 1024
+
+// remoteExifDate returns the capture time of a remote JPEG file like
+// exifDate, from a partial read of the start of the file. ok is false for
+// other files and devices that refuse partial reads.
+func (c *CLI) remoteExifDate(fi *mtpx.FileInfo) (t time.Time, ok bool) {
+	ext := strings.ToLower(path.Ext(fi.Name))
+	if fi.IsDir || ext != ".jpg" && ext != ".jpeg" {
+		return t, false
+	}
+
+	var head bytes.Buffer
+	if err := c.device.GetPartialObject(fi.ObjectId, &head, 0, uint32(min(fi.Size, exifHeadSize))); err != nil {
+		return t, false
+	}
+	tiff := jpegExif(bufio.NewReader(&head))
+	if tiff == nil {
+		return t, false
+	}
+	return parseExifDate(tiff)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// archiveEntry is a file or directory of a local zip or tar archive
+type archiveEntry struct {
+	name  string
+	dir   bool
+	size  int64
+	mtime time.Time
+
+	// open returns the data of a file, only while the entry is visited
+	open func() (io.ReadCloser, error)
+}
+
+// readArchive calls fn with the files and directories of the local zip or tar
+// archive file in archive order. Tar archives may be gzip compressed, which
+// is told from the data rather than the name. Links and other special tar
+// entries are skipped with a warning.
+func readArchive(file string, fn func(e *archiveEntry) error) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("invalid archive: %w", err)
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	magic, _ := br.Peek(4)
+	if bytes.HasPrefix(magic, []byte("PK\x03\x04")) || bytes.HasPrefix(magic, []byte("PK\x05\x06")) {
+		st, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		zr, err := zip.NewReader(f, st.Size())
+		if err != nil {
+			return fmt.Errorf("invalid archive %s: %w", file, err)
+		}
+		for _, zf := range zr.File {
+			e := &archiveEntry{
+				name:  zf.Name,
+				dir:   zf.FileInfo().IsDir(),
+				size:  int64(zf.UncompressedSize64),
+				mtime: zf.Modified,
+				open:  zf.Open,
+			}
+			if err := fn(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var r io.Reader = br
+	if bytes.HasPrefix(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("invalid archive %s: %w", file, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid archive %s: %w", file, err)
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeDir {
+			log.Printf("warning: skipping %s in %s, which is not a regular file or directory", hdr.Name, file)
+			continue
+		}
+		e := &archiveEntry{
+			name:  hdr.Name,
+			dir:   hdr.Typeflag == tar.TypeDir,
+			size:  hdr.Size,
+			mtime: hdr.ModTime,
+			open:  func() (io.ReadCloser, error) { return io.NopCloser(tr), nil },
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+}
+
+// entryPath returns the slash separated path of an archive entry relative to
+// the directory it is extracted into. Names with .. components are refused
+// rather than cleaned, since they were meant to land elsewhere.
+func entryPath(name string) (string, error) {
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("archive entry %s leaves the target directory", name)
+		}
+	}
+	return strings.TrimPrefix(path.Clean("/"+name), "/"), nil
+}
+
+// uploadArchive creates the files and directories of the local archive file
+// below remoteDir entry by entry, streaming each file from the archive, so
+// the archive is never unpacked locally. Files replace existing ones of the
+// same name.
+func (u *uploader) uploadArchive(file, remoteDir string) error {
+	c := u.cli
+
+	// a first pass sizes the files for the free space check
+	var total int64
+	err := readArchive(file, func(e *archiveEntry) error {
+		rel, err := entryPath(e.name)
+		if err != nil {
+			return err
+		}
+		if !e.dir && rel != "" && !u.filter.skip(rel, false) {
+			total += e.size
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := c.checkFreeSpace(total, u.ignoreSpace); err != nil {
+		return err
+	}
+
+	// parents of files are created when archives leave out their entries
+	made := map[string]bool{}
+	makeDir := func(dir string) error {
+		if made[dir] {
+			return nil
+		}
+		made[dir] = true
+		return c.makeRemoteDir(dir)
+	}
+	if err := makeDir(remoteDir); err != nil {
+		return err
+	}
+
+	var files, dirs, size int64
+	err = readArchive(file, func(e *archiveEntry) error {
+		if err := canceled(); err != nil {
+			return err
+		}
+		rel, err := entryPath(e.name)
+		if err != nil {
+			return err
+		}
+		if rel == "" || u.filter.skip(rel, e.dir) {
+			return nil
+		}
+
+		target := path.Join(remoteDir, rel)
+		if e.dir {
+			if !made[target] {
+				dirs++
+			}
+			return makeDir(target)
+		}
+		if err := makeDir(path.Dir(target)); err != nil {
+			return err
+		}
+
+		r, err := e.open()
+		if err != nil {
+			return fmt.Errorf("failed to read %s from %s: %w", e.name, file, err)
+		}
+		defer r.Close()
+		if err := u.uploadStream(r, e.size, file+":"+rel, path.Base(target), path.Dir(target), e.mtime); err != nil {
+			return err
+		}
+		fileCompleted(directionUpload, file+":"+rel, target, e.size)
+		files++
+		size += e.size
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return printJSON(map[string]interface{}{
+		"files":       files,
+		"directories": dirs,
+		"size":        size,
+	})
+}
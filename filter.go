@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// stringList is a flag that can be given several times
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// filterFlags are --include, --exclude and --exclude-from of a transfer
+// command
+type filterFlags struct {
+	include, exclude stringList
+	excludeFrom      string
+}
+
+func addFilterFlags(fs *flag.FlagSet) *filterFlags {
+	f := &filterFlags{}
+	fs.Var(&f.include, "include", "only transfer files matching this pattern (repeatable)")
+	fs.Var(&f.exclude, "exclude", "leave out files and directories matching this pattern (repeatable)")
+	fs.StringVar(&f.excludeFrom, "exclude-from", "", "read exclude patterns from this file, gitignore style")
+	return f
+}
+
+// filter compiles the flags, nil when none were given. Patterns of
+// --exclude-from come first, so --exclude can override them with !pattern.
+func (f *filterFlags) filter() (*pathFilter, error) {
+	var excludes []string
+	if f.excludeFrom != "" {
+		lines, err := readPatternFile(f.excludeFrom)
+		if err != nil {
+			return nil, err
+		}
+		excludes = lines
+	}
+	excludes = append(excludes, f.exclude...)
+
+	if len(excludes) == 0 && len(f.include) == 0 {
+		return nil, nil
+	}
+
+	pf := &pathFilter{}
+	for _, p := range excludes {
+		rule, err := parseFilterRule(p)
+		if err != nil {
+			return nil, usagef("invalid exclude pattern %q: %v", p, err)
+		}
+		pf.excludes = append(pf.excludes, rule)
+	}
+	for _, p := range f.include {
+		rule, err := parseFilterRule(p)
+		if err != nil {
+			return nil, usagef("invalid include pattern %q: %v", p, err)
+		}
+		if rule.negate {
+			return nil, usagef("invalid include pattern %q: ! is only for excludes", p)
+		}
+		pf.includes = append(pf.includes, rule)
+	}
+	return pf, nil
+}
+
+// readPatternFile reads the patterns of an exclude file, skipping blank lines
+// and # comments
+func readPatternFile(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read exclude file: %w", err)
+	}
+	defer f.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, scanner.Err()
+}
+
+// filterRule is a pattern in gitignore syntax: a leading ! negates it, a
+// trailing / only matches directories, and a pattern with a / in it is
+// matched against the whole relative path instead of any name. ** matches
+// any number of directories.
+type filterRule struct {
+	segments []string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+func parseFilterRule(p string) (filterRule, error) {
+	var r filterRule
+	if strings.HasPrefix(p, "!") {
+		r.negate = true
+		p = p[1:]
+	}
+	if strings.HasSuffix(p, "/") {
+		r.dirOnly = true
+		p = strings.TrimRight(p, "/")
+	}
+	r.anchored = strings.Contains(p, "/")
+	p = strings.TrimPrefix(p, "/")
+	if p == "" {
+		return r, fmt.Errorf("empty pattern")
+	}
+
+	r.segments = strings.Split(p, "/")
+	for _, seg := range r.segments {
+		if _, err := path.Match(seg, ""); err != nil {
+			return r, err
+		}
+	}
+	return r, nil
+}
+
+// matches reports whether the rule matches the slash separated path rel
+func (r filterRule) matches(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if !r.anchored {
+		ok, _ := path.Match(r.segments[0], path.Base(rel))
+		return ok
+	}
+	return matchSegments(r.segments, strings.Split(rel, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// pathFilter decides which objects below the root of a transfer take part,
+// by their slash separated path relative to it
+type pathFilter struct {
+	excludes []filterRule
+
+	// includes, when set, are the patterns files must match
+	includes []filterRule
+}
+
+// skip reports whether rel is left out. As with gitignore, the last matching
+// exclude wins and nothing below an excluded directory can be brought back.
+// Directories are never left out for --include, so their files can match.
+func (f *pathFilter) skip(rel string, isDir bool) bool {
+	if f == nil {
+		return false
+	}
+
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		if f.excluded(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	if f.excluded(rel, isDir) {
+		return true
+	}
+
+	if isDir || len(f.includes) == 0 {
+		return false
+	}
+	for _, r := range f.includes {
+		if r.matches(rel, false) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *pathFilter) excluded(rel string, isDir bool) bool {
+	excluded := false
+	for _, r := range f.excludes {
+		if r.matches(rel, isDir) {
+			excluded = !r.negate
+		}
+	}
+	return excluded
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// errStopWalk is returned from a Walk callback to end the walk early
+var errStopWalk = errors.New("stop walk")
+
+// handleFind prints the objects below a path that match every given
+// predicate, filtering during the walk. The argument is the path to search
+// when it contains a slash, which no name can, and otherwise the name
+// pattern as with --name.
+func (c *CLI) handleFind(args []string) error {
+	fs := flag.NewFlagSet("find", flag.ContinueOnError)
+	under := fs.String("under", ".", "only search below this remote path")
+	name := fs.String("name", "", "only match names matching this glob or containing this substring")
+	objType := fs.String("type", "", "only match objects of this type: file (f) or dir (d)")
+	minSize := fs.String("min-size", "", "only match files of at least this size, e.g. 100M")
+	maxSize := fs.String("max-size", "", "only match files of at most this size, e.g. 1G")
+	ages := addAgeFlags(fs, "match")
+	formatName := fs.String("format", "", "only match files of this kind: image, video, audio, document or an object format code")
+	maxResults := fs.Int("max-results", 0, "stop after this many matches (0 means no limit)")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) > 1 {
+		return usagef("find takes one path or name pattern")
+	}
+	if len(args) == 1 {
+		if strings.Contains(args[0], "/") {
+			*under = args[0]
+		} else if *name != "" {
+			return usagef("find got both a name pattern %q and --name", args[0])
+		} else {
+			*name = args[0]
+		}
+	}
+
+	switch *objType {
+	case "f":
+		*objType = "file"
+	case "d":
+		*objType = "dir"
+	case "", "file", "dir":
+	default:
+		return usagef("invalid type: %s (expected file or dir)", *objType)
+	}
+
+	match := func(string) bool { return true }
+	if *name != "" {
+		match, err = nameMatcher(*name)
+		if err != nil {
+			return usageError{err}
+		}
+	}
+
+	var sizeMin, sizeMax int64 = 0, -1
+	if *minSize != "" {
+		if sizeMin, err = parseSize(*minSize); err != nil {
+			return usagef("invalid --min-size: %v", err)
+		}
+	}
+	if *maxSize != "" {
+		if sizeMax, err = parseSize(*maxSize); err != nil {
+			return usagef("invalid --max-size: %v", err)
+		}
+	}
+	age, err := ages.filter()
+	if err != nil {
+		return err
+	}
+	// size bounds only apply to files, directories report no size
+	sizeFilter := sizeMin > 0 || sizeMax >= 0
+	format, err := parseFormat(*formatName)
+	if err != nil {
+		return err
+	}
+
+	root, err := c.resolveRemote(*under)
+	if err != nil {
+		return err
+	}
+
+	found := 0
+	_, _, _, err = c.walk(root, true, true, false,
+		func(objectId uint32, fi *mtpx.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if (*objType == "file" && fi.IsDir) || (*objType == "dir" && !fi.IsDir) {
+				return nil
+			}
+			if sizeFilter && (fi.IsDir || fi.Size < sizeMin || (sizeMax >= 0 && fi.Size > sizeMax)) {
+				return nil
+			}
+			if !match(fi.Name) || !format.match(fi) || !age.match(fi) {
+				return nil
+			}
+
+			printJSON(map[string]interface{}{
+				"path": fi.FullPath,
+				"size": fi.Size,
+			})
+
+			found++
+			if *maxResults > 0 && found >= *maxResults {
+				return errStopWalk
+			}
+			return nil
+		})
+
+	if err != nil && !errors.Is(err, errStopWalk) {
+		return err
+	}
+
+	printDone("MTPX_FIND_DONE")
+	return nil
+}
+
+// parseSize parses a byte count with an optional K, M, G or T suffix (powers
+// of 1024, a trailing B or iB is allowed)
+func parseSize(s string) (int64, error) {
+	num := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B"), "I")
+	shift := 0
+	if num != "" {
+		if i := strings.IndexByte("KMGT", num[len(num)-1]); i >= 0 {
+			shift = 10 * (i + 1)
+			num = num[:len(num)-1]
+		}
+	}
+
+	n, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a size like 500K or 1.5G", s)
+	}
+	return int64(n * float64(int64(1)<<shift)), nil
+}
+
+// ageFlags are --newer-than and --older-than of a command
+type ageFlags struct {
+	newer, older string
+}
+
+// addAgeFlags adds the flags, whose usage says what the command does with
+// the objects in range
+func addAgeFlags(fs *flag.FlagSet, verb string) *ageFlags {
+	a := &ageFlags{}
+	fs.StringVar(&a.newer, "newer-than", "", "only "+verb+" objects modified after this date, time or age, e.g. 2024-01-01 or 7d")
+	fs.StringVar(&a.older, "older-than", "", "only "+verb+" objects modified before this date, time or age")
+	return a
+}
+
+// ageFilter keeps objects modified after after and before before, zero times
+// are no bound
+type ageFilter struct {
+	after, before time.Time
+}
+
+// filter parses the flags, nil when neither was given
+func (a *ageFlags) filter() (*ageFilter, error) {
+	if a.newer == "" && a.older == "" {
+		return nil, nil
+	}
+	f := &ageFilter{}
+	var err error
+	if a.newer != "" {
+		if f.after, err = parseTimeBound(a.newer); err != nil {
+			return nil, usagef("invalid --newer-than: %v", err)
+		}
+	}
+	if a.older != "" {
+		if f.before, err = parseTimeBound(a.older); err != nil {
+			return nil, usagef("invalid --older-than: %v", err)
+		}
+	}
+	return f, nil
+}
+
+// match reports whether fi was modified within the bounds, a nil filter
+// matches everything
+func (f *ageFilter) match(fi *mtpx.FileInfo) bool {
+	if f == nil {
+		return true
+	}
+	return (f.after.IsZero() || fi.ModTime.After(f.after)) && (f.before.IsZero() || fi.ModTime.Before(f.before))
+}
+
+// parseTimeBound parses a date (2024-01-01, local time), an RFC 3339 time or
+// an age like 36h or 7d, meaning that long before now
+func parseTimeBound(s string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	age := s
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%q is not a date, time or age", s)
+		}
+		age = strconv.Itoa(n*24) + "h"
+	}
+	d, err := time.ParseDuration(age)
+	if err != nil || d < 0 {
+		return time.Time{}, fmt.Errorf("%q is not a date, time or age", s)
+	}
+	return time.Now().Add(-d), nil
+}
+
+// nameMatcher returns a case-insensitive matcher for pattern. Patterns
+// containing glob characters are matched against the whole name, anything
+// else is a substring search.
+func nameMatcher(pattern string) (func(name string) bool, error) {
+	pattern = strings.ToLower(pattern)
+
+	if !strings.ContainsAny(pattern, "*?[") {
+		return func(name string) bool {
+			return strings.Contains(strings.ToLower(name), pattern)
+		}, nil
+	}
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+
+	return func(name string) bool {
+		ok, _ := path.Match(pattern, strings.ToLower(name))
+		return ok
+	}, nil
+}
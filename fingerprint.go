@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// deviceIdentity identifies a physical device across runs
+type deviceIdentity struct {
+	Fingerprint  string   `json:"fingerprint"`
+	Serial       string   `json:"serial"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+	Storages     []string `json:"storages"`
+}
+
+// handleFingerprint prints a stable identifier for the connected device
+func (c *CLI) handleFingerprint(args []string) error {
+	id, err := c.deviceIdentity()
+	if err != nil {
+		return err
+	}
+
+	printJSON(id)
+
+	printDone("MTPX_FINGERPRINT_DONE")
+	return nil
+}
+
+func (c *CLI) deviceIdentity() (*deviceIdentity, error) {
+	info, err := mtpx.FetchDeviceInfo(c.device)
+	if err != nil {
+		return nil, err
+	}
+
+	storages, err := mtpx.FetchStorages(c.device)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch storage info: %w", err)
+	}
+
+	descriptions := []string{}
+	for _, s := range storages {
+		descriptions = append(descriptions, s.Info.StorageDescription)
+	}
+	sort.Strings(descriptions)
+
+	return &deviceIdentity{
+		Fingerprint:  deviceFingerprint(info.Manufacturer, info.Model, info.SerialNumber, descriptions),
+		Serial:       info.SerialNumber,
+		Manufacturer: info.Manufacturer,
+		Model:        info.Model,
+		Storages:     descriptions,
+	}, nil
+}
+
+// deviceFingerprint hashes the identifying device fields. Fields are NUL
+// separated so that no two different inputs produce the same hash input.
+// storages must be sorted.
+func deviceFingerprint(manufacturer, model, serial string, storages []string) string {
+	fields := append([]string{manufacturer, model, serial}, storages...)
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+var errNoGlobMatch = errors.New("no remote paths match")
+
+// expandRemote resolves a remote path argument that may hold glob patterns
+// into the paths it matches. "*", "?" and "[...]" match within a path segment
+// and a "**" segment matches any number of directories. A path that exists
+// as written is used literally, so names such as "[2019] Album" keep working.
+// The matches of a pattern are printed before they are used.
+func (c *CLI) expandRemote(arg string) ([]string, error) {
+	if !hasGlobMeta(arg) {
+		p, err := c.resolveRemote(arg)
+		return []string{p}, err
+	}
+
+	p := remotePath(arg)
+	if c.remoteExists(p) {
+		return []string{p}, nil
+	}
+
+	matches, err := c.globRemote(p)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%w %s", errNoGlobMatch, arg)
+	}
+
+	printJSON(map[string]interface{}{
+		"glob":    p,
+		"matches": matches,
+	})
+	return matches, nil
+}
+
+// globRemote expands pattern one segment at a time, listing only the
+// directories the pattern can reach
+func (c *CLI) globRemote(pattern string) ([]string, error) {
+	segs := strings.Split(strings.Trim(pattern, "/"), "/")
+	current := []string{"/"}
+
+	for i, seg := range segs {
+		last := i == len(segs)-1
+		var next []string
+
+		for _, dir := range current {
+			switch {
+			case seg == "**":
+				// "**" matches dir itself and everything below it; only
+				// directories can continue the pattern
+				if !last {
+					next = append(next, dir)
+				}
+				found, err := c.globChildren(dir, true, func(fi *mtpx.FileInfo) bool {
+					return last || fi.IsDir
+				})
+				if err != nil {
+					return nil, err
+				}
+				next = append(next, found...)
+
+			case !hasGlobMeta(seg):
+				p := path.Join(dir, seg)
+				if !last || c.remoteExists(p) {
+					next = append(next, p)
+				}
+
+			default:
+				found, err := c.globChildren(dir, false, func(fi *mtpx.FileInfo) bool {
+					return (last || fi.IsDir) && globMatch(seg, fi.Name)
+				})
+				if err != nil {
+					return nil, err
+				}
+				next = append(next, found...)
+			}
+		}
+
+		current = dedupe(next)
+	}
+
+	sort.Strings(current)
+	return current, nil
+}
+
+// globChildren lists the entries below dir that keep returns true for. A dir
+// that doesn't exist has no entries.
+func (c *CLI) globChildren(dir string, recursive bool, keep func(fi *mtpx.FileInfo) bool) ([]string, error) {
+	var found []string
+	_, _, _, err := c.walk(dir, recursive, true, false,
+		func(objectId uint32, fi *mtpx.FileInfo, err error) error {
+			if err == nil && path.Clean(fi.FullPath) != path.Clean(dir) && keep(fi) {
+				found = append(found, path.Clean(fi.FullPath))
+			}
+			return nil
+		})
+	if _, ok := err.(mtpx.InvalidPathError); ok {
+		return nil, nil
+	}
+	return found, err
+}
+
+func hasGlobMeta(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
+
+// globMatch matches a single path segment, ignoring case with --ignore-case
+func globMatch(pattern, name string) bool {
+	if *ignoreCase {
+		pattern, name = strings.ToLower(pattern), strings.ToLower(name)
+	}
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+func dedupe(paths []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, p := range paths {
+		if !seen[p] {
+			seen[p] = true
+			out = append(out, p)
+		}
+	}
+	return out
+}
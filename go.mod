@@ -3,7 +3,9 @@ module mtpx-cli
 go 1.24.3
 
 require (
-	github.com/ganeshrvel/go-mtpfs v1.0.4-0.20240426083057-1c3302b3c476 // indirect
-	github.com/ganeshrvel/go-mtpx v0.0.0-20240426092756-18f12db021cc // indirect
-	github.com/ganeshrvel/usb v0.0.0-20210103155855-14d96f5ae403 // indirect
+	github.com/ganeshrvel/go-mtpfs v1.0.4-0.20240426083057-1c3302b3c476
+	github.com/ganeshrvel/go-mtpx v0.0.0-20240426092756-18f12db021cc
+	github.com/ganeshrvel/usb v0.0.0-20210103155855-14d96f5ae403
+	github.com/hanwen/go-fuse/v2 v2.0.3
+	golang.org/x/text v0.30.0
 )
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+)
+
+// handleHash prints the digest of remote files, streaming them from the
+// device without writing anything to disk
+func (c *CLI) handleHash(args []string) error {
+	fs := flag.NewFlagSet("hash", flag.ContinueOnError)
+	algo := fs.String("algo", hashSHA256, "hash algorithm: md5, sha1 or sha256")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) < 1 {
+		return usagef("hash requires a remote path")
+	}
+	if _, err := newHasher(*algo); err != nil {
+		return err
+	}
+
+	remotes, err := c.expandRemote(args[0])
+	if err != nil {
+		return err
+	}
+
+	for _, remote := range remotes {
+		fi, err := c.objectFromPath(remote)
+		if err != nil {
+			return err
+		}
+		if fi.IsDir {
+			return fmt.Errorf("%s is a directory", fi.FullPath)
+		}
+
+		digest, err := c.remoteDigest(fi.ObjectId, *algo)
+		if err != nil {
+			return fmt.Errorf("failed to hash %s: %w", fi.FullPath, err)
+		}
+		printJSON(map[string]interface{}{
+			"path":   fi.FullPath,
+			"size":   fi.Size,
+			"algo":   *algo,
+			"digest": hex.EncodeToString(digest),
+		})
+	}
+
+	printDone("MTPX_HASH_DONE")
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"path"
+	"strings"
+
+	"github.com/ganeshrvel/go-mtpfs/mtp"
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// uint16Value decodes a single 16 bit MTP property value
+type uint16Value struct {
+	Value uint16
+}
+
+// isHidden reports whether an object is hidden: either its name starts with a
+// dot or the device flags it through the MTP Hidden property. The property is
+// only queried for object formats the device says support it.
+func (c *CLI) isHidden(fi *mtpx.FileInfo) bool {
+	if strings.HasPrefix(fi.Name, ".") {
+		return true
+	}
+	if o := c.cachedTree().object(fi.ObjectId); o != nil {
+		return o.Hidden
+	}
+
+	if fi.Info == nil || !c.supportsProp(fi.Info.ObjectFormat, mtp.OPC_Hidden) {
+		return false
+	}
+
+	var val uint16Value
+	if err := c.device.GetObjectPropValue(fi.ObjectId, mtp.OPC_Hidden, &val); err != nil {
+		return false
+	}
+	return val.Value != 0
+}
+
+// supportsProp reports whether objects of format have the property prop,
+// asking the device once per format
+func (c *CLI) supportsProp(format, prop uint16) bool {
+	if c.objectProps == nil {
+		c.objectProps = map[uint16][]uint16{}
+	}
+
+	props, ok := c.objectProps[format]
+	if !ok {
+		var supported mtp.Uint16Array
+		if err := c.device.GetObjectPropsSupported(format, &supported); err == nil {
+			props = supported.Values
+		}
+		c.objectProps[format] = props
+	}
+
+	for _, p := range props {
+		if p == prop {
+			return true
+		}
+	}
+	return false
+}
+
+// hiddenFilter drops hidden objects and everything below hidden directories
+// from a Walk
+type hiddenFilter struct {
+	cli     *CLI
+	skipped map[string]bool
+}
+
+func newHiddenFilter(c *CLI) *hiddenFilter {
+	return &hiddenFilter{cli: c, skipped: map[string]bool{}}
+}
+
+func (h *hiddenFilter) skip(fi *mtpx.FileInfo) bool {
+	if !h.skipped[path.Clean(fi.ParentPath)] && !h.cli.isHidden(fi) {
+		return false
+	}
+
+	if fi.IsDir {
+		h.skipped[path.Clean(fi.FullPath)] = true
+	}
+	return true
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Directions of a transferred file, MTPX_DIRECTION of --on-file-complete
+const (
+	directionDownload = "download"
+	directionUpload   = "upload"
+)
+
+// fileCompleted runs --on-file-complete for a file transferred from source to
+// target, once it is in place under its final name and verified
+func fileCompleted(direction, source, target string, size int64) {
+	if *onFileComplete == "" {
+		return
+	}
+	runHook("--on-file-complete", *onFileComplete, map[string]string{
+		"path":      target,
+		"source":    source,
+		"size":      strconv.FormatInt(size, 10),
+		"direction": direction,
+		"status":    "ok",
+	})
+}
+
+// jobCompleted runs --on-job-complete after command, one of the jobCommands,
+// ended with err, with the counts of its transfer summary. Under job resume
+// the hook also gets the id of the queued job.
+func (c *CLI) jobCompleted(command string, err error) {
+	if *onJobComplete == "" || *dryRun {
+		return
+	}
+	status := "ok"
+	switch {
+	case errors.Is(err, errCanceled):
+		status = "canceled"
+	case err != nil:
+		status = "failed"
+	}
+
+	transfers.mu.Lock()
+	vars := map[string]string{
+		"command": command,
+		"status":  status,
+		"files":   strconv.FormatInt(transfers.files, 10),
+		"skipped": strconv.FormatInt(transfers.skipped, 10),
+		"size":    strconv.FormatInt(transfers.bytes, 10),
+	}
+	transfers.mu.Unlock()
+	if err != nil {
+		vars["error"] = err.Error()
+	}
+	if c.jobID != 0 {
+		vars["job"] = strconv.Itoa(c.jobID)
+	}
+	runHook("--on-job-complete", *onJobComplete, vars)
+}
+
+// runHook runs the command line of a hook flag through the shell and waits
+// for it. Each of vars is passed as an MTPX_<NAME> environment variable and
+// replaces {name} in the command line, quoted for the shell. The placeholders
+// are replaced in one pass, so a value that holds a placeholder, as a file
+// name can, is never expanded again. The hook's output goes to stderr,
+// stdout is for results. A failing hook only logs a warning.
+func runHook(flagName, cmdline string, vars map[string]string) {
+	env := os.Environ()
+	var placeholders []string
+	for _, name := range sortedLabels(vars) {
+		env = append(env, "MTPX_"+strings.ToUpper(name)+"="+vars[name])
+		placeholders = append(placeholders, "{"+name+"}", shellQuote(vars[name]))
+	}
+	cmdline = strings.NewReplacer(placeholders...).Replace(cmdline)
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", cmdline)
+	} else {
+		cmd = exec.Command("/bin/sh", "-c", cmdline)
+	}
+	cmd.Env = env
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.Printf("warning: %s hook failed: %v", flagName, err)
+	}
+}
+
+// shellQuote quotes s as one word for the shell that runs hooks
+func shellQuote(s string) string {
+	if runtime.GOOS == "windows" {
+		return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
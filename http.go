@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// restServer exposes the device over HTTP. Requests are handled one at a time
+// since they share the device session.
+type restServer struct {
+	cli *CLI
+	mu  sync.Mutex
+}
+
+// handleHTTP serves the REST API on --listen until interrupted
+func (c *CLI) handleHTTP(args []string) error {
+	fs := flag.NewFlagSet("http", flag.ContinueOnError)
+	listen := fs.String("listen", "127.0.0.1:8080", "address to listen on")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return usagef("http takes no arguments")
+	}
+
+	s := &restServer{cli: c}
+	metrics = newMetricsRegistry()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/files", s.device(s.handleFiles))
+	mux.HandleFunc("/download", s.device(s.handleDownload))
+	mux.HandleFunc("/upload", s.device(s.handleUpload))
+	mux.Handle("/metrics", metrics)
+	srv := &http.Server{Addr: *listen, Handler: mux}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	go func() {
+		<-sig
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+
+	printJSON(map[string]string{"listening": *listen})
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	printDone("MTPX_HTTP_DONE")
+	return nil
+}
+
+// httpError carries the status code of a failed request
+type httpError struct {
+	status int
+	error
+}
+
+func badRequest(format string, a ...interface{}) error {
+	return httpError{http.StatusBadRequest, fmt.Errorf(format, a...)}
+}
+
+// device wraps a handler with the request lock, metrics and error reporting.
+// Errors are answered as {"error": "..."}; a dead session is reconnected
+// before the next request.
+func (s *restServer) device(h func(w http.ResponseWriter, r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		tw := &trackingWriter{ResponseWriter: w}
+		start := time.Now()
+		var err error
+		if crossOrigin(r) {
+			err = httpError{http.StatusForbidden, fmt.Errorf("cross-origin %s requests are not allowed", r.Method)}
+		} else {
+			err = h(tw, r)
+		}
+		metrics.observe(r.Method+" "+r.URL.Path, time.Since(start), err)
+		if err == nil {
+			return
+		}
+
+		status := http.StatusInternalServerError
+		var herr httpError
+		switch {
+		case errors.As(err, &herr):
+			status = herr.status
+		case isNotFound(err):
+			status = http.StatusNotFound
+		}
+		if status >= http.StatusInternalServerError {
+			// the session goes stale when the device sleeps or is replugged
+			s.cli.reconnectIfDead()
+		}
+
+		// a started body can't carry an error status anymore
+		if tw.started {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		writeJSON(w, map[string]string{"error": err.Error()})
+	}
+}
+
+// crossOrigin reports whether r would change the device on behalf of a web
+// page of another origin. Any page can send requests to a loopback listener,
+// so uploads and deletes from browsers must come from the server's own
+// origin. Browsers set Sec-Fetch-Site, older ones at least Origin; clients
+// such as curl send neither.
+func crossOrigin(r *http.Request) bool {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return false
+	}
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+	default:
+		return true
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		return err != nil || u.Host != r.Host
+	}
+	return false
+}
+
+// handleFiles lists a path with GET and deletes paths with DELETE
+func (s *restServer) handleFiles(w http.ResponseWriter, r *http.Request) error {
+	c := s.cli
+	q := r.URL.Query()
+
+	switch r.Method {
+	case http.MethodGet:
+		p := q.Get("path")
+		if p == "" {
+			return badRequest("path is required")
+		}
+		roots, err := c.expandRemote(p)
+		if err != nil {
+			return err
+		}
+
+		skipHidden := q.Get("skip_hidden") == "true"
+		entries := []map[string]interface{}{}
+		for _, root := range roots {
+			err := c.listTree(root, skipHidden, false, nil, -1, func(entry map[string]interface{}, _ *mtpx.FileInfo) {
+				entries = append(entries, entry)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		return writeJSON(w, entries)
+
+	case http.MethodDelete:
+		if len(q["path"]) == 0 {
+			return badRequest("path is required")
+		}
+		results := []deleteResult{}
+		planned := []map[string]interface{}{}
+		for _, p := range q["path"] {
+			remotes, err := c.expandRemote(p)
+			if err != nil {
+				return err
+			}
+			for _, remote := range remotes {
+				if *dryRun {
+					planned = append(planned, map[string]interface{}{"action": "delete", "path": remote})
+					continue
+				}
+				results = append(results, c.deletePath(mtpx.FileProp{FullPath: remote}))
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if *dryRun {
+			return writeJSON(w, planned)
+		}
+		return writeJSON(w, results)
+	}
+
+	return httpError{http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method)}
+}
+
+// handleDownload streams the body of a remote file
+func (s *restServer) handleDownload(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return httpError{http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method)}
+	}
+	c := s.cli
+
+	p := r.URL.Query().Get("path")
+	if p == "" {
+		return badRequest("path is required")
+	}
+	remote, err := c.resolveRemote(p)
+	if err != nil {
+		return err
+	}
+
+	fi, err := c.objectFromPath(remote)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir {
+		return badRequest("%s is a directory", fi.FullPath)
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "application/octet-stream")
+	h.Set("Content-Length", strconv.FormatInt(fi.Size, 10))
+	h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fi.Name}))
+	h.Set("Last-Modified", fi.ModTime.UTC().Format(http.TimeFormat))
+
+	var out io.Writer = w
+	if bwLimiter != nil {
+		out = &throttledWriter{w: w, l: bwLimiter}
+	}
+	if err := c.device.GetObject(fi.ObjectId, out, func(sent int64) error { return nil }); err != nil {
+		return fmt.Errorf("failed to download %s: %w", fi.FullPath, err)
+	}
+	metrics.transferred(fi.Size)
+	return nil
+}
+
+// handleUpload stores the request body as ?path=<remote_dir>&name=<file_name>.
+// MTP needs the size up front, so the request must carry a Content-Length.
+func (s *restServer) handleUpload(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return httpError{http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method)}
+	}
+
+	q := r.URL.Query()
+	dir, name := q.Get("path"), q.Get("name")
+	if dir == "" || name == "" {
+		return badRequest("path and name are required")
+	}
+	if name != path.Base(name) || name == "." || name == ".." {
+		return badRequest("invalid name %q", name)
+	}
+	if r.ContentLength < 0 {
+		return httpError{http.StatusLengthRequired, fmt.Errorf("Content-Length is required")}
+	}
+
+	remoteDir := remotePath(dir)
+	if *dryRun {
+		w.Header().Set("Content-Type", "application/json")
+		return writeJSON(w, map[string]interface{}{
+			"action": "upload",
+			"source": r.RemoteAddr,
+			"target": path.Join(remoteDir, name),
+			"size":   r.ContentLength,
+		})
+	}
+	u := &uploader{cli: s.cli, limiter: newRateLimiter(0)}
+	if err := u.uploadStream(r.Body, r.ContentLength, r.RemoteAddr, name, remoteDir, time.Now()); err != nil {
+		return err
+	}
+	fileCompleted(directionUpload, r.RemoteAddr, path.Join(remoteDir, name), r.ContentLength)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	return writeJSON(w, map[string]interface{}{
+		"path": path.Join(remoteDir, name),
+		"size": r.ContentLength,
+	})
+}
+
+// trackingWriter remembers whether the response body was started
+type trackingWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (t *trackingWriter) Write(p []byte) (int, error) {
+	t.started = true
+	return t.ResponseWriter.Write(p)
+}
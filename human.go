@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Output modes for --output
+const (
+	outputJSON  = "json"
+	outputHuman = "human"
+)
+
+// humanOutput renders stdout for people instead of as JSON, see setOutputMode
+var humanOutput bool
+
+// setOutputMode resolves --output. Without it, human output is used when
+// stdout is a terminal and JSON otherwise.
+func setOutputMode(mode string) error {
+	switch mode {
+	case "":
+		humanOutput = !*legacyOutput && isTerminal(os.Stdout)
+	case outputJSON:
+		humanOutput = false
+	case outputHuman:
+		if *legacyOutput {
+			return usagef("--output human can't be combined with --legacy-output")
+		}
+		humanOutput = true
+	default:
+		return usagef("invalid --output %q: must be %s or %s", mode, outputJSON, outputHuman)
+	}
+	return nil
+}
+
+// humanProgress reports whether progress is drawn as bars, which is only done
+// when it goes to stdout
+func humanProgress() bool {
+	return humanOutput && progressOut == os.Stdout
+}
+
+// writeHuman renders an output line of type typ for a terminal. Entries with
+// a path and size, like those of list, find, du and stat, are printed as
+// aligned columns, tree nodes as an indented tree and other objects as
+// key=value pairs. done lines are left out and errors already went to stderr.
+func writeHuman(typ string, v interface{}) error {
+	if typ == typeDone || typ == typeError {
+		return nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal JSON: %w", err)
+	}
+	var fields map[string]interface{}
+	var line string
+	if json.Unmarshal(b, &fields) != nil {
+		indented, _ := json.MarshalIndent(v, "", "  ")
+		line = string(indented)
+	} else if _, ok := fields["children"]; ok {
+		line = humanTree(fields)
+	} else {
+		line = humanLine(fields)
+	}
+
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	bars.clear()
+	fmt.Println(line)
+	return nil
+}
+
+func humanLine(fields map[string]interface{}) string {
+	p, hasPath := fields["path"].(string)
+	size, hasSize := fields["size"].(float64)
+
+	switch {
+	case fields["event"] == "summary":
+		num := func(k string) int64 { n, _ := fields[k].(float64); return int64(n) }
+		return fmt.Sprintf("%s: %d files, %s in %s (%s/s), %d skipped", fields["command"], num("files"),
+			humanReadableSize(num("bytes")), fields["elapsed"], humanReadableSize(num("throughput")), num("skipped"))
+	case fields["capacity"] != nil && fields["storage"] != nil:
+		percent, _ := fields["used_percent"].(float64)
+		return fmt.Sprintf("%10s %10s %10s %5.1f%%  %s", fields["capacity_human"], fields["used_human"],
+			fields["free_human"], percent, fields["storage"])
+	case hasPath && fields["diff"] != nil:
+		return humanDiff(fields)
+	case hasPath && fields["exists"] == false:
+		return fmt.Sprintf("%10s  %s  (not found)", "-", p)
+	case hasPath && hasSize:
+		line := fmt.Sprintf("%10s  %s", humanReadableSize(int64(size)), p)
+		if s, ok := fields["mtime"].(string); ok {
+			if t, err := time.Parse(time.RFC3339, s); err == nil {
+				line = fmt.Sprintf("%10s  %s  %s", humanReadableSize(int64(size)), t.Local().Format("2006-01-02 15:04"), p)
+			}
+		}
+		if files, ok := fields["files"].(float64); ok {
+			line += fmt.Sprintf("  (%d files)", int64(files))
+		}
+		if fields["hidden"] == true {
+			line += "  (hidden)"
+		}
+		return line
+	}
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		var val string
+		switch v := fields[k].(type) {
+		case string:
+			val = v
+		case float64:
+			val = fmt.Sprint(v)
+		default:
+			b, _ := json.Marshal(v)
+			val = string(b)
+		}
+		parts = append(parts, k+"="+val)
+	}
+	return strings.Join(parts, " ")
+}
+
+// humanTree draws a tree entry with its children below it, files with their
+// size
+func humanTree(root map[string]interface{}) string {
+	var b strings.Builder
+	b.WriteString(root["path"].(string))
+
+	var draw func(node map[string]interface{}, indent string)
+	draw = func(node map[string]interface{}, indent string) {
+		children, _ := node["children"].([]interface{})
+		for i, c := range children {
+			child, _ := c.(map[string]interface{})
+			branch, next := "├── ", "│   "
+			if i == len(children)-1 {
+				branch, next = "└── ", "    "
+			}
+
+			b.WriteString("\n" + indent + branch + fmt.Sprint(child["name"]))
+			if _, dir := child["children"]; dir {
+				b.WriteString("/")
+				draw(child, indent+next)
+			} else if size, ok := child["size"].(float64); ok {
+				b.WriteString("  (" + humanReadableSize(int64(size)) + ")")
+			}
+		}
+	}
+	draw(root, "")
+	return b.String()
+}
+
+// progressBars draws the progress of the active transfer as a single line that
+// is redrawn in place
+type progressBars struct {
+	start    map[uint32]time.Time
+	lastDraw time.Time
+	active   bool
+}
+
+var bars = &progressBars{start: map[uint32]time.Time{}}
+
+// barRedraw limits how often a bar is redrawn
+const barRedraw = 100 * time.Millisecond
+
+// draw shows sent of size bytes of a file with speed and ETA. The finished
+// bar is kept on its own line.
+func (b *progressBars) draw(objectId uint32, name string, sent, size int64) {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+
+	now := time.Now()
+	start, ok := b.start[objectId]
+	if !ok {
+		start = now
+		b.start[objectId] = now
+	}
+	done := sent >= size
+	if !done && now.Sub(b.lastDraw) < barRedraw {
+		return
+	}
+	b.lastDraw = now
+
+	var rate float64
+	if elapsed := now.Sub(start).Seconds(); elapsed > 0 {
+		rate = float64(sent) / elapsed
+	}
+
+	pct := percent(sent, size)
+	width := 25
+	filled := min(int(pct/100*float64(width)), width)
+	bar := strings.Repeat("#", filled) + strings.Repeat("-", width-filled)
+
+	status := "ETA -"
+	switch {
+	case done:
+		status = humanReadableSize(size)
+	case rate > 0:
+		eta := time.Duration(float64(size-sent) / rate * float64(time.Second))
+		status = "ETA " + eta.Round(time.Second).String()
+	}
+
+	fmt.Printf("\r\033[K%-30s [%s] %3.0f%%  %10s/s  %s", truncateName(name, 30), bar, pct, humanReadableSize(int64(rate)), status)
+	b.active = !done
+	if done {
+		fmt.Println()
+		delete(b.start, objectId)
+	}
+}
+
+// clear removes an unfinished bar so a line can be printed in its place; the
+// next update draws it again. Callers hold outputMu.
+func (b *progressBars) clear() {
+	if b.active {
+		fmt.Print("\r\033[K")
+		b.active = false
+		b.lastDraw = time.Time{}
+	}
+}
+
+// drawTree prints the aggregate progress of a tree transfer below the bar of
+// the file that just finished
+func (b *progressBars) drawTree(filesDone, filesTotal, bytesDone, bytesTotal int64) {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	b.clear()
+	fmt.Printf("%d/%d files, %s of %s (%.0f%%)\n", filesDone, filesTotal,
+		humanReadableSize(bytesDone), humanReadableSize(bytesTotal), percent(bytesDone, bytesTotal))
+}
+
+func truncateName(name string, n int) string {
+	r := []rune(name)
+	if len(r) <= n {
+		return name
+	}
+	return string(r[:n-3]) + "..."
+}
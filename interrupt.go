@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// errCanceled ends a command interrupted with SIGINT or SIGTERM
+var errCanceled = errors.New("canceled by signal")
+
+// interrupted is set by the first SIGINT or SIGTERM, see catchInterrupts
+var interrupted atomic.Bool
+
+// canceled returns errCanceled once the process was interrupted. Transfer
+// progress callbacks return it to abort the running MTP operation, and the
+// transfer queue checks it before each file.
+func canceled() error {
+	if interrupted.Load() {
+		return errCanceled
+	}
+	return nil
+}
+
+// catchInterrupts makes the first SIGINT or SIGTERM cancel the running
+// transfer, so the command can remove partial files and close the session
+// before exiting. A second signal exits right away. The shell clears
+// interrupted before each command, so an interrupt only ends that command.
+func catchInterrupts() {
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		for range sig {
+			if interrupted.Swap(true) {
+				os.Exit(exitCodes[codeCanceled])
+			}
+			log.Print("interrupted, cleaning up (interrupt again to quit at once)")
+		}
+	}()
+}
+
+// handlesSignals reports whether cmd runs until interrupted and handles
+// SIGINT and SIGTERM itself
+func handlesSignals(cmd string) bool {
+	switch cmd {
+	case "watch", "events", "serve", "http", "mount":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,570 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Job statuses. A job stays running while the process of job resume works on
+// it; once that process is gone, after a reboot say, it lists as interrupted.
+const (
+	jobQueued      = "queued"
+	jobRunning     = "running"
+	jobInterrupted = "interrupted"
+	jobDone        = "done"
+	jobFailed      = "failed"
+	jobCanceled    = "canceled"
+)
+
+// jobCommands are the commands a job can run, those that can carry on where
+// an earlier run stopped, see resumeArgs
+var jobCommands = map[string]bool{
+	"download":      true,
+	"upload":        true,
+	"sync":          true,
+	"backup":        true,
+	"import-photos": true,
+}
+
+// queuedJob is a transfer recorded in the job state file
+type queuedJob struct {
+	ID      int      `json:"id"`
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+
+	// Dir is the working directory of job add, relative local paths in
+	// Args are below it
+	Dir string `json:"dir"`
+
+	Status   string     `json:"status"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Attempts int        `json:"attempts"`
+	Error    string     `json:"error,omitempty"`
+
+	// Pid is the process running the job
+	Pid int `json:"pid,omitempty"`
+}
+
+// jobState is the content of the job state file
+type jobState struct {
+	NextID int          `json:"next_id"`
+	Jobs   []*queuedJob `json:"jobs"`
+}
+
+func (s *jobState) find(id int) *queuedJob {
+	for _, j := range s.Jobs {
+		if j.ID == id {
+			return j
+		}
+	}
+	return nil
+}
+
+// status is the status of j, with a running job whose process is gone
+// reported as interrupted
+func (j *queuedJob) status() string {
+	if j.Status == jobRunning && !processAlive(j.Pid) {
+		return jobInterrupted
+	}
+	return j.Status
+}
+
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	return err == nil && p.Signal(syscall.Signal(0)) == nil
+}
+
+// defaultJobStatePath returns ~/.local/state/mtpx-cli/jobs.json, or the same
+// below $XDG_STATE_HOME
+func defaultJobStatePath() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "mtpx-cli", "jobs.json")
+}
+
+func addJobStateFlag(fs *flag.FlagSet) *string {
+	return fs.String("state", defaultJobStatePath(), "file that records the jobs")
+}
+
+func readJobState(file string) (*jobState, error) {
+	state := &jobState{NextID: 1}
+	b, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read job state: %w", err)
+	}
+	if err := json.Unmarshal(b, state); err != nil {
+		return nil, fmt.Errorf("invalid job state %s: %w", file, err)
+	}
+	return state, nil
+}
+
+// writeJobState replaces file in one rename, so a crash leaves the old state
+// and readers never see half of the new one
+func writeJobState(file string, state *jobState) error {
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*"+partSuffix)
+	if err != nil {
+		return fmt.Errorf("failed to write job state: %w", err)
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), file)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to write job state: %w", err)
+	}
+	return nil
+}
+
+// updateJobState applies update to the state in file and saves it. Every
+// change reads the file again under lockJobState, so job add and job cancel
+// can run while job resume works through the queue.
+func updateJobState(file string, update func(*jobState) error) error {
+	unlock, err := lockJobState(file)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := readJobState(file)
+	if err != nil {
+		return err
+	}
+	if err := update(state); err != nil {
+		return err
+	}
+	return writeJobState(file, state)
+}
+
+// handleJob runs the job actions that leave the device alone, job resume is
+// handleJobResume
+func handleJob(args []string) error {
+	if len(args) < 1 {
+		return usagef("job requires add, list, cancel or resume")
+	}
+	switch args[0] {
+	case "add":
+		return handleJobAdd(args[1:])
+	case "list":
+		return handleJobList(args[1:])
+	case "cancel":
+		return handleJobCancel(args[1:])
+	}
+	return usagef("unknown job action %q: must be add, list, cancel or resume", args[0])
+}
+
+// handleJobAdd queues a transfer command with its arguments, which job resume
+// runs. The flags of job add come before the command, everything after it is
+// the command's.
+func handleJobAdd(args []string) error {
+	fs := flag.NewFlagSet("job add", flag.ContinueOnError)
+	stateFile := addJobStateFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return usageError{err}
+	}
+	args = fs.Args()
+	if len(args) < 2 {
+		return usagef("job add requires a command and its arguments")
+	}
+	if !jobCommands[args[0]] {
+		return usagef("invalid job command %q: must be download, upload, sync, backup or import-photos", args[0])
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	j := &queuedJob{
+		Command: args[0],
+		Args:    args[1:],
+		Dir:     dir,
+		Status:  jobQueued,
+		Created: time.Now(),
+	}
+	err = updateJobState(*stateFile, func(s *jobState) error {
+		j.ID = s.NextID
+		s.NextID++
+		s.Jobs = append(s.Jobs, j)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	printJSON(j)
+	printDone("MTPX_JOB_DONE")
+	return nil
+}
+
+// handleJobList prints the jobs, oldest first
+func handleJobList(args []string) error {
+	fs := flag.NewFlagSet("job list", flag.ContinueOnError)
+	stateFile := addJobStateFlag(fs)
+	status := fs.String("status", "", "only list jobs with this status")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return usagef("job list takes no arguments")
+	}
+
+	state, err := readJobState(*stateFile)
+	if err != nil {
+		return err
+	}
+	for _, j := range state.Jobs {
+		j.Status = j.status()
+		if *status != "" && j.Status != *status {
+			continue
+		}
+		if j.Status != jobRunning {
+			j.Pid = 0
+		}
+		printJSON(j)
+	}
+	printDone("MTPX_JOB_DONE")
+	return nil
+}
+
+// handleJobCancel keeps jobs from running. A running job is stopped by its
+// runner, which polls the state file, see watchCancel.
+func handleJobCancel(args []string) error {
+	fs := flag.NewFlagSet("job cancel", flag.ContinueOnError)
+	stateFile := addJobStateFlag(fs)
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) < 1 {
+		return usagef("job cancel requires a job id")
+	}
+	ids, err := parseJobIds(args)
+	if err != nil {
+		return err
+	}
+
+	err = updateJobState(*stateFile, func(s *jobState) error {
+		for _, id := range ids {
+			j := s.find(id)
+			if j == nil {
+				return fmt.Errorf("job %d not found", id)
+			}
+			switch status := j.status(); status {
+			case jobDone, jobCanceled:
+				return fmt.Errorf("job %d is already %s", id, status)
+			}
+			now := time.Now()
+			j.Status, j.Finished = jobCanceled, &now
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		printJSON(map[string]interface{}{"id": id, "status": jobCanceled})
+	}
+	printDone("MTPX_JOB_DONE")
+	return nil
+}
+
+func parseJobIds(args []string) ([]int, error) {
+	ids := make([]int, 0, len(args))
+	for _, a := range args {
+		id, err := strconv.Atoi(a)
+		if err != nil || id < 1 {
+			return nil, usagef("invalid job id %q", a)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// handleJobResume runs the queued and interrupted jobs in the order they were
+// added, or the jobs given by id, which may also have failed or been
+// canceled. A job that ran before is run with resumeArgs, so it carries on
+// with the files it didn't finish. Output lines of a job are tagged with its
+// id as in batch mode. Ctrl-C stops the run and leaves the job interrupted
+// for the next resume.
+func (c *CLI) handleJobResume(args []string) error {
+	fs := flag.NewFlagSet("job resume", flag.ContinueOnError)
+	stateFile := addJobStateFlag(fs)
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	ids, err := parseJobIds(args)
+	if err != nil {
+		return err
+	}
+
+	state, err := readJobState(*stateFile)
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		for _, j := range state.Jobs {
+			if s := j.status(); s == jobQueued || s == jobInterrupted {
+				ids = append(ids, j.ID)
+			}
+		}
+	}
+	expect := map[int]string{}
+	for _, id := range ids {
+		j := state.find(id)
+		if j == nil {
+			return fmt.Errorf("job %d not found", id)
+		}
+		switch j.status() {
+		case jobDone:
+			return usagef("job %d is done", id)
+		case jobRunning:
+			return usagef("job %d is running in process %d", id, j.Pid)
+		}
+		expect[id] = j.status()
+	}
+
+	if *dryRun {
+		for _, id := range ids {
+			j := state.find(id)
+			printPlanned("job", map[string]interface{}{"id": id, "command": j.Command, "args": j.Args})
+		}
+		printDone("MTPX_JOB_DONE")
+		return nil
+	}
+
+	var run, failed int
+	for _, id := range ids {
+		status, err := c.runJob(*stateFile, id, expect[id])
+		if status == "" {
+			continue
+		}
+		run++
+		switch {
+		case status == jobCanceled:
+			// canceled by job cancel, the next job goes ahead
+			interrupted.Store(false)
+		case errors.Is(err, errCanceled):
+			return err
+		case err != nil:
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d jobs failed", failed, run)
+	}
+	printDone("MTPX_JOB_DONE")
+	return nil
+}
+
+// runJob runs the job id, whose status was expect when the run began, and
+// records how it ended. It returns the final status, or "" for a job that
+// was canceled or taken by another process in the meantime.
+func (c *CLI) runJob(file string, id int, expect string) (string, error) {
+	var j queuedJob
+	err := updateJobState(file, func(s *jobState) error {
+		found := s.find(id)
+		if found == nil || found.status() != expect {
+			return nil
+		}
+		now := time.Now()
+		found.Status, found.Pid, found.Started, found.Finished, found.Error = jobRunning, os.Getpid(), &now, nil, ""
+		found.Attempts++
+		j = *found
+		return nil
+	})
+	if err != nil || j.ID == 0 {
+		return "", err
+	}
+
+	args := j.Args
+	if j.Attempts > 1 {
+		args = resumeArgs(j.Command, args)
+	}
+	printJSON(map[string]interface{}{
+		"event":   "job_started",
+		"id":      j.ID,
+		"command": j.Command,
+		"args":    args,
+		"attempt": j.Attempts,
+	})
+
+	stop := watchCancel(file, id)
+	err = c.runJobCommand(&j, args)
+	stop()
+	if err != nil {
+		printError(err)
+		log.Print(err)
+		// the session goes stale when the device sleeps or is replugged
+		c.reconnectIfDead()
+	}
+
+	status := jobDone
+	switch {
+	case errors.Is(err, errCanceled):
+		status = jobInterrupted
+	case err != nil:
+		status = jobFailed
+	}
+	uerr := updateJobState(file, func(s *jobState) error {
+		found := s.find(id)
+		if found == nil {
+			return nil
+		}
+		if found.Status == jobCanceled {
+			status = jobCanceled
+			return nil
+		}
+		now := time.Now()
+		found.Status, found.Pid, found.Finished = status, 0, &now
+		if err != nil {
+			found.Error = err.Error()
+		}
+		return nil
+	})
+	if uerr != nil {
+		log.Printf("warning: failed to record the end of job %d: %v", id, uerr)
+	}
+
+	event := map[string]interface{}{"event": "job_finished", "id": j.ID, "status": status}
+	if err != nil && status != jobCanceled {
+		event["error"] = err.Error()
+	}
+	printJSON(event)
+	return status, err
+}
+
+// watchCancel polls file while the job id runs, and once job cancel marked
+// it canceled interrupts the running command as Ctrl-C would, which removes
+// its partial file. Polling rather than signalling the recorded pid means a
+// cancel can only ever stop the job it names. The returned function stops
+// the polling; runJob calls it before recording the end of the job, which
+// then finds the job canceled and keeps it so.
+func watchCancel(file string, id int) (stop func()) {
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(devicePoll)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+			}
+			state, err := readJobState(file)
+			if err != nil {
+				continue
+			}
+			if j := state.find(id); j != nil && j.Status == jobCanceled {
+				interrupted.Store(true)
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// runJobCommand runs the command of j in its working directory, with the
+// output tagged with its id
+func (c *CLI) runJobCommand(j *queuedJob, args []string) error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if err := os.Chdir(j.Dir); err != nil {
+		return fmt.Errorf("failed to enter the directory of job %d: %w", j.ID, err)
+	}
+	defer os.Chdir(cwd)
+
+	c.jobID = j.ID
+	defer func() { c.jobID = 0 }()
+
+	outputMu.Lock()
+	prevResult, prevProgress := resultOut, progressOut
+	id := json.RawMessage(strconv.Itoa(j.ID))
+	resultOut = &taggedWriter{w: prevResult, id: id}
+	progressOut = &taggedWriter{w: prevProgress, id: id}
+	outputMu.Unlock()
+
+	defer func() {
+		outputMu.Lock()
+		resultOut, progressOut = prevResult, prevProgress
+		outputMu.Unlock()
+	}()
+
+	return c.run(j.Command, args)
+}
+
+// resumeArgs returns the arguments that make a job that ran before skip the
+// files it finished: download keeps local files that match and continues
+// .part files, upload keeps remote files that match. upload --extract takes
+// no --skip-existing and unpacks the whole archive again. sync, backup and
+// import-photos only transfer what is missing anyway.
+func resumeArgs(command string, args []string) []string {
+	switch command {
+	case "download":
+		flags := []string{"--skip-existing"}
+		// --resume only goes with --on-conflict overwrite
+		if !hasFlag(args, "on-conflict") {
+			flags = append(flags, "--resume")
+		}
+		return append(flags, args...)
+	case "upload":
+		if hasFlag(args, "extract") {
+			return args
+		}
+		return append([]string{"--skip-existing"}, args...)
+	}
+	return args
+}
+
+// hasFlag reports whether args set the flag name, before a "--" ending the
+// flags
+func hasFlag(args []string, name string) bool {
+	for _, a := range args {
+		if a == "--" {
+			return false
+		}
+		if !strings.HasPrefix(a, "-") {
+			continue
+		}
+		a = strings.TrimPrefix(strings.TrimPrefix(a, "-"), "-")
+		if a == name || strings.HasPrefix(a, name+"=") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE
+
+// watchLocalTree sends the paths of files written or moved into the tree
+// below root, and of directories created there, to changed until stop is
+// called. New directories are watched as they appear. Linux uses inotify and
+// has no use for interval.
+func watchLocalTree(root string, interval time.Duration, changed chan<- string) (stop func(), err error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	// a non-blocking descriptor goes through the runtime poller, so Close
+	// ends a pending Read
+	f := os.NewFile(uintptr(fd), "inotify")
+
+	dirs := map[int32]string{}
+	addTree := func(dir string) {
+		filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+			if err != nil || !fi.IsDir() {
+				return nil
+			}
+			if wd, err := syscall.InotifyAddWatch(fd, p, inotifyMask); err == nil {
+				dirs[int32(wd)] = p
+			}
+			return nil
+		})
+	}
+	addTree(root)
+	if len(dirs) == 0 {
+		f.Close()
+		return nil, &os.PathError{Op: "watch", Path: root, Err: syscall.ENOTDIR}
+	}
+
+	done := make(chan struct{})
+	send := func(p string) {
+		select {
+		case changed <- p:
+		case <-done:
+		}
+	}
+
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+			for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+				ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+				nameStart := off + syscall.SizeofInotifyEvent
+				name := strings.TrimRight(string(buf[nameStart:nameStart+int(ev.Len)]), "\x00")
+				off = nameStart + int(ev.Len)
+
+				// events were dropped, everything may have changed
+				if ev.Mask&syscall.IN_Q_OVERFLOW != 0 {
+					send(root)
+					continue
+				}
+				dir, ok := dirs[ev.Wd]
+				if !ok || name == "" {
+					continue
+				}
+				p := filepath.Join(dir, name)
+				switch {
+				case ev.Mask&syscall.IN_ISDIR != 0:
+					addTree(p)
+					send(p)
+				case ev.Mask&(syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO) != 0:
+					send(p)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		f.Close()
+	}, nil
+}
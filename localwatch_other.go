@@ -0,0 +1,58 @@
+//go:build !linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// watchLocalTree sends the paths of files and directories that appeared or
+// changed size or modification time in the tree below root to changed until
+// stop is called. Without inotify the tree is scanned every interval.
+func watchLocalTree(root string, interval time.Duration, changed chan<- string) (stop func(), err error) {
+	type state struct {
+		size  int64
+		mtime time.Time
+	}
+	scan := func() map[string]state {
+		files := map[string]state{}
+		filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+			if err == nil && p != root {
+				files[p] = state{fi.Size(), fi.ModTime()}
+			}
+			return nil
+		})
+		return files
+	}
+
+	if _, err := os.Stat(root); err != nil {
+		return nil, err
+	}
+	prev := scan()
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(interval):
+			}
+			cur := scan()
+			for p, s := range cur {
+				if old, ok := prev[p]; !ok || old != s {
+					select {
+					case changed <- p:
+					case <-done:
+						return
+					}
+				}
+			}
+			prev = cur
+		}
+	}()
+
+	return func() { close(done) }, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/ganeshrvel/go-mtpfs/mtp"
+)
+
+// Levels of --log-level
+const (
+	logInfo  = "info"
+	logDebug = "debug"
+	logTrace = "trace"
+)
+
+// debugMTP and debugData turn on the MTP and USB tracing of go-mtpfs for
+// every device opened, see setupLogging
+var debugMTP, debugData bool
+
+// setupLogging points the standard logger, which carries warnings and the
+// go-mtpfs tracing, at file instead of stderr, and sets the tracing up for
+// level. Tracing lines are timestamped to the microsecond so request and
+// response pairs can be timed. The file is appended to, so runs add up.
+func setupLogging(level, file string) error {
+	switch level {
+	case logInfo:
+	case logDebug:
+		debugMTP = true
+	case logTrace:
+		debugMTP, debugData = true, true
+	default:
+		return usagef("invalid --log-level %q: must be %s, %s or %s", level, logInfo, logDebug, logTrace)
+	}
+
+	if file != "" {
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to open --log-file: %w", err)
+		}
+		log.SetOutput(f)
+	}
+	if debugMTP {
+		log.SetFlags(log.LstdFlags | log.Lmicroseconds)
+	}
+	return nil
+}
+
+// debugDevice sets the tracing of --log-level on dev. debug logs each MTP
+// request and response code along with the USB transfers, trace adds a dump
+// of the data phases.
+func debugDevice(dev *mtp.Device) {
+	dev.MTPDebug = debugMTP
+	dev.USBDebug = debugMTP
+	dev.DataDebug = debugData
+}
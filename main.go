@@ -2,116 +2,571 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
-	mtpx "github.com/ganeshrvel/go-mtpx"
 	"github.com/ganeshrvel/go-mtpfs/mtp"
+	mtpx "github.com/ganeshrvel/go-mtpx"
 )
 
 // CLI represents the command line interface
 type CLI struct {
 	device  *mtp.Device
 	storage uint32
+
+	// object properties the device supports per object format, see
+	// supportsProp
+	objectProps map[uint16][]uint16
+
+	// noDateModified is set once the device refused a DateModified
+	// property, see setModTime
+	noDateModified bool
+
+	// noRename is set once the device turned out not to rename objects,
+	// see tempName
+	noRename bool
+
+	// noPropList is set once the device refused to list a directory with
+	// GetObjPropList, see propList
+	noPropList bool
+
+	// deviceMu gives transfer queue workers turns on the device, see
+	// withDevice
+	deviceMu sync.Mutex
+
+	// ids are the object ids of paths given as id:N, so lookups of those
+	// paths skip resolving them segment by segment, see resolveObjectIds
+	ids map[string]uint32
+
+	// cacheOn is set while a command runs that is served from the object
+	// cache, trees holds the cached trees by storage, see cachedTree
+	cacheOn bool
+	trees   map[uint32]*objectTree
+
+	// jobID is the queued job that job resume runs, for --on-job-complete
+	jobID int
 }
 
 // ProgressHandler manages progress output for transfers
 type ProgressHandler struct {
-	printedDone bool
-	targetDir   string
-	sourcePath  string
+	targetDir  string
+	sourcePath string
+
+	// limiter throttles the transfer from inside the progress callback,
+	// sent is the byte count of the active file seen so far
+	limiter *rateLimiter
+	sent    int64
+
+	// objectId is the handle of the uploaded object, once known
+	objectId uint32
 }
 
+// Global flags, given before the command
+var (
+	noSentinel       = flag.Bool("no-sentinel", false, "Suppress the done lines (MTPX_*_DONE markers with --legacy-output)")
+	remoteCwd        = flag.String("cwd", "/", "Remote working directory that relative remote paths resolve against")
+	ignoreCase       = flag.Bool("ignore-case", false, "Match remote path segments case-insensitively and fail on ambiguous matches")
+	deviceSel        = flag.String("device", "", "Device to use when several are connected: serial number, vendor:product id or index from list-devices")
+	dryRun           = flag.Bool("dry-run", false, "Print the actions delete, sync, upload and download would take without taking them")
+	storageSel       = flag.String("storage", "", "Storage to use: index or id from storage-info, or a storage label")
+	progressFd       = flag.Int("progress-fd", 0, "Write progress and transfer summary lines to this open file descriptor instead of stdout")
+	progressInterval = flag.Duration("progress-interval", 0, "Print at most one progress line per file in this interval (the 100% line is always printed)")
+	noProgress       = flag.Bool("no-progress", false, "Print no progress lines, only transfer summaries")
+	noTempNames      = flag.Bool("no-temp-names", false, "Upload files under their final names instead of .mtpxtmp-<name> renamed once complete")
+	outputMode       = flag.String("output", "", "Output format: json or human (default human when stdout is a terminal, json otherwise)")
+	legacyOutput     = flag.Bool("legacy-output", false, "Print bare JSON lines, tab-separated stat lines and MTPX_*_DONE sentinels instead of typed envelopes")
+	bwLimit          = flag.String("bwlimit", "", "Limit all transfers together to this many bytes per second, such as 5M (the per-command --max-rate applies on top)")
+	retries          = flag.Int("retries", 3, "Retry a file transfer this many times when a USB error ends the session, reconnecting first")
+	retryDelay       = flag.Duration("retry-delay", time.Second, "Delay before the first retry, doubled for each further one")
+	waitFor          = flag.Duration("wait", 0, "Wait up to this long for a device with a storage to appear instead of failing right away")
+	profileName      = flag.String("profile", "", "Apply the settings of this profile from the config file")
+	configFile       = flag.String("config", "", "Config file with profiles (default ~/.config/mtpx-cli/config.yaml)")
+	useCache         = flag.Bool("cache", false, "Serve list, stat, find, du and tree from a cached copy of the object tree, read again once objects were added or removed")
+	refreshCache     = flag.Bool("refresh", false, "Read the object tree cache again from the device before using it (implies --cache)")
+	logLevel         = flag.String("log-level", logInfo, "Log level: info, debug to trace each MTP request and response, or trace to dump the data as well")
+	logFile          = flag.String("log-file", "", "Append warnings and debug logs to this file instead of stderr")
+	opTimeout        = flag.Duration("timeout", mtpTimeout*time.Millisecond, "Fail an MTP operation when the device doesn't answer within this long")
+	transferTimeout  = flag.Duration("transfer-timeout", 0, "Fail a file transfer that takes longer than this (0 for no limit)")
+	steal            = flag.Bool("steal", false, "Detach a kernel driver from the MTP interface or stop the desktop MTP clients holding it before opening the device")
+	onFileComplete   = flag.String("on-file-complete", "", "Shell command run after each transferred file, with {path}, {source}, {size} and MTPX_* variables")
+	onJobComplete    = flag.String("on-job-complete", "", "Shell command run after each download, upload, sync, backup or import-photos command, with {status} and MTPX_* variables")
+)
+
 func main() {
-	if len(os.Args) < 2 {
+	flag.Usage = printUsage
+	flag.Parse()
+
+	if flag.NArg() < 1 {
 		printUsage()
-		os.Exit(1)
+		os.Exit(exitCodes[codeUsage])
+	}
+
+	cmd := flag.Arg(0)
+	args := flag.Args()[1:]
+
+	if err := loadProfile(*configFile, *profileName); err != nil {
+		fatal(err)
+	}
+	if err := setOutputMode(*outputMode); err != nil {
+		fatal(err)
+	}
+	if err := setupLogging(*logLevel, *logFile); err != nil {
+		fatal(err)
+	}
+	if err := openProgressOutput(*progressFd); err != nil {
+		fatal(err)
+	}
+	if err := validateRetries(*retries, *retryDelay); err != nil {
+		fatal(err)
+	}
+	if err := validateTimeouts(*opTimeout, *transferTimeout); err != nil {
+		fatal(err)
+	}
+	if *waitFor < 0 {
+		fatal(usagef("invalid --wait %s: must not be negative", *waitFor))
+	}
+	if *progressInterval < 0 {
+		fatal(usagef("invalid --progress-interval %s: must not be negative", *progressInterval))
+	}
+	if err := setBandwidthLimit(*bwLimit); err != nil {
+		fatal(err)
+	}
+
+	// completion scripts are generated without touching the device
+	if cmd == "completion" {
+		if err := handleCompletion(args); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	// doctor opens the device itself so it can report every failure
+	if cmd == "doctor" {
+		if err := handleDoctor(args); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	// listing devices must work while several are connected
+	if cmd == "list-devices" {
+		if err := handleListDevices(args); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	// only job resume needs the device
+	if cmd == "job" && (len(args) == 0 || args[0] != "resume") {
+		if err := handleJob(args); err != nil {
+			fatal(err)
+		}
+		return
 	}
 
 	cli, err := newCLI()
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
+	}
+	defer func() { mtpx.Dispose(cli.device) }()
+
+	if !handlesSignals(cmd) {
+		catchInterrupts()
 	}
-	defer mtpx.Dispose(cli.device)
+	if err := cli.run(cmd, args); err != nil {
+		// fatal skips the deferred Dispose, an interrupted transfer
+		// should still end its session
+		if errors.Is(err, errCanceled) {
+			mtpx.Dispose(cli.device)
+		}
+		fatal(err)
+	}
+}
 
-	cmd := os.Args[1]
-	args := os.Args[2:]
+// run dispatches a device command to its handler
+func (c *CLI) run(cmd string, args []string) (err error) {
+	c.cacheOn = (*useCache || *refreshCache) && cachedCommands[cmd]
+	c.trees = map[uint32]*objectTree{}
+	if renamingCommands[cmd] {
+		defer c.dropCache()
+	}
+	if jobCommands[cmd] {
+		// the hook reports no files for a command that fails early
+		transfers.begin()
+		defer func() { c.jobCompleted(cmd, err) }()
+	}
+
+	args, err = c.resolveObjectIds(args)
+	if err != nil {
+		return err
+	}
 
 	switch cmd {
 	case "list":
-		err = cli.handleList(args)
+		return c.handleList(args)
 	case "download":
-		err = cli.handleDownload(args)
+		return c.handleDownload(args)
 	case "upload":
-		err = cli.handleUpload(args)
+		return c.handleUpload(args)
+	case "put-stdin":
+		return c.handlePutStdin(args)
 	case "delete":
-		err = cli.handleDelete(args)
+		return c.handleDelete(args)
 	case "stat":
-		err = cli.handleStat(args)
+		return c.handleStat(args)
+	case "mkdir":
+		return c.handleMkdir(args)
+	case "mv":
+		return c.handleMove(args)
+	case "cp":
+		return c.handleCopy(args)
+	case "sync":
+		return c.handleSync(args)
+	case "diff":
+		return c.handleDiff(args)
+	case "backup":
+		return c.handleBackup(args)
+	case "restore":
+		return c.handleRestore(args)
+	case "import-photos":
+		return c.handleImportPhotos(args)
+	case "thumbnail":
+		return c.handleThumbnail(args)
+	case "find":
+		return c.handleFind(args)
+	case "du":
+		return c.handleDu(args)
+	case "dedupe":
+		return c.handleDedupe(args)
+	case "trash":
+		return c.handleTrash(args)
+	case "job":
+		if len(args) > 0 && args[0] == "resume" {
+			return c.handleJobResume(args[1:])
+		}
+		return handleJob(args)
+	case "tree":
+		return c.handleTree(args)
+	case "getprop":
+		return c.handleGetProp(args)
+	case "prop":
+		return c.handleProp(args)
+	case "hash":
+		return c.handleHash(args)
+	case "cat":
+		return c.handleCat(args)
+	case "archive":
+		return c.handleArchive(args)
+	case "watch":
+		return c.handleWatch(args)
+	case "events":
+		return c.handleEvents(args)
+	case "device":
+		return c.handleDevice(args)
+	case "battery":
+		return c.handleBattery(args)
+	case "capabilities":
+		return c.handleCapabilities(args)
 	case "device-info":
-		err = cli.handleDeviceInfo(args)
+		return c.handleDeviceInfo(args)
 	case "storage-info":
-		err = cli.handleStorageInfo(args)
+		return c.handleStorageInfo(args)
+	case "df":
+		return c.handleDf(args)
+	case "benchmark":
+		return c.handleBenchmark(args)
+	case "fingerprint":
+		return c.handleFingerprint(args)
+	case "manifest":
+		return c.handleManifest(args)
+	case "reconnect":
+		return c.handleReconnect(args)
+	case "shell":
+		return c.handleShell(args)
+	case "batch":
+		return c.handleBatch(args)
+	case "serve":
+		return c.handleServe(args)
+	case "http":
+		return c.handleHTTP(args)
+	case "mount":
+		return c.handleMount(args)
+	case "__complete":
+		return c.handleComplete(args)
 	default:
-		err = fmt.Errorf("unknown command: %s", cmd)
+		return usagef("unknown command: %s", cmd)
 	}
+}
 
+func newCLI() (*CLI, error) {
+	dev, storage, err := openDeviceWait(*waitFor)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+
+	return &CLI{
+		device:  dev,
+		storage: storage,
+	}, nil
 }
 
-func newCLI() (*CLI, error) {
-	dev, err := mtpx.Initialize(mtpx.Init{})
+// initDevice opens the device chosen with --device, or the only connected
+// one. MTP interfaces held by another program are looked for first, as a
+// failed open only tells why when it knows about them.
+func initDevice() (*mtp.Device, error) {
+	busy := busyInterfaces()
+	if *deviceSel != "" {
+		dev, err := selectDevice(*deviceSel)
+		if err != nil {
+			return nil, busyError(busy, err)
+		}
+		return dev, nil
+	}
+	dev, err := mtpx.Initialize(mtpx.Init{DebugMode: debugMTP})
+	if err != nil {
+		return nil, busyError(busy, err)
+	}
+	debugDevice(dev)
+	setTimeout(dev)
+	return dev, nil
+}
+
+// openDevice initializes the MTP device and selects its storage
+func openDevice() (*mtp.Device, uint32, error) {
+	dev, err := initDevice()
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize MTP: %w", err)
+		return nil, 0, fmt.Errorf("failed to initialize MTP: %w", err)
 	}
 
 	storages, err := mtpx.FetchStorages(dev)
 	if err != nil || len(storages) == 0 {
 		mtpx.Dispose(dev)
-		return nil, fmt.Errorf("no storage found")
+		return nil, 0, errNoStorage
 	}
 
-	return &CLI{
-		device:  dev,
-		storage: storages[0].Sid,
-	}, nil
+	storage, err := selectStorage(storages, *storageSel)
+	if err != nil {
+		mtpx.Dispose(dev)
+		return nil, 0, err
+	}
+
+	return dev, storage.Sid, nil
+}
+
+// command describes a subcommand for usage and completion output
+type command struct {
+	name string
+	args string
+	desc string
+}
+
+var commands = []command{
+	{"list", "[--mtp-info] [--skip-hidden] [--format image|video|audio|document|<code>] [--long] [--sort name|size|mtime] [--reverse] [--limit N] [--offset N] [-r] [--max-depth N] [--all-storages] <remote_path>", "List files at remote path, one level unless -r or --max-depth"},
+	{"download", "[-r] [--raw-names] [--skip-hidden] [--chunk-size <bytes>] [--skip-existing [--compare size|size-mtime]] [--max-rate <bytes/s>] [--resume] [--verify sha256] [--concurrency <n>] [--on-conflict skip|overwrite|rename|newer] [--format image|video|audio|document|<code>] [--rename-template <template>] [--newer-than <time>] [--older-than <time>] [--include <pattern>] [--exclude <pattern>] [--exclude-from <file>] <remote> <local_dir>", "Download a file into target directory"},
+	{"upload", "[-r] [--chunk-size <bytes>] [--max-rate <bytes/s>] [--verify sha256] [--concurrency <n>] [--on-conflict skip|overwrite|rename|newer] [--ignore-space] [--skip-existing] [--extract] [--include <pattern>] [--exclude <pattern>] [--exclude-from <file>] <local_path> <remote_dir>", "Upload a file (or a tree with -r, or the contents of an archive with --extract) into remote directory"},
+	{"put-stdin", "[--size <bytes>] <remote_path>", "Upload stdin as a remote file"},
+	{"delete", "[-i] [--yes] [--report] [-r [--force]] [--newer-than <time>] [--older-than <time>] [--trash <remote_dir>] <remote_path> [...]", "Delete one or more files by remote path"},
+	{"sync", "[--reverse] [--delete [-i] [--yes] [--trash <remote_dir>]] [--skip-hidden] [--manifest <file>] [--concurrency <n>] [--on-conflict skip|overwrite|newer] [--include <pattern>] [--exclude <pattern>] [--exclude-from <file>] <local_dir> <remote_dir>", "Mirror a local directory to the device (or back with --reverse)"},
+	{"diff", "[--checksum] [--skip-hidden] [--include <pattern>] [--exclude <pattern>] [--exclude-from <file>] <local_dir> <remote_dir>", "Compare a local and a remote tree and report files only on one side or different"},
+	{"backup", "[--skip-hidden] <remote_path> <local_dir>", "Back up a remote directory incrementally, writing a manifest snapshot per run"},
+	{"restore", "[--manifest <file>] [--checksum] <local_dir> [<remote_path>]", "Upload the files of a backup snapshot or manifest back to the device"},
+	{"import-photos", "[--layout {yyyy}/{mm}/{dd}] [--rename-template <template>] [--state <file>] [--delete] <remote_dir> <local_dir>", "Import new photos and videos into date directories"},
+	{"mkdir", "[-p] <remote_path> [...]", "Create remote directories (with parents when -p is given)"},
+	{"mv", "[--to-storage <storage>] <remote_src> <remote_dst>", "Rename or move a file or directory on the device"},
+	{"cp", "[-r] [--to-storage <storage>] <remote_src> <remote_dst>", "Copy a file or directory tree on the device"},
+	{"stat", "[--mtp-info] <remote_path>", "Print the metadata of a file or directory, or report it as missing"},
+	{"find", "[<remote_path>|<pattern>] [--under <path>] [--name <pattern>] [--type file|dir] [--min-size <size>] [--max-size <size>] [--newer-than <time>] [--older-than <time>] [--format image|video|audio|document|<code>] [--max-results <n>]", "Search the device for objects matching name, type, size and time predicates"},
+	{"du", "[--depth <n>] <remote_path>", "Print the size and file count of each remote directory"},
+	{"trash", "list | empty [--yes] | restore <remote_path> [...] [--trash <remote_dir>]", "List, empty or restore from the trash that delete --trash moves objects into"},
+	{"dedupe", "[--by size|hash] [--delete [--yes]] [--skip-hidden] <remote_path>", "Report sets of duplicate remote files and optionally delete all but one"},
+	{"tree", "[--max-depth <n>] [--skip-hidden] <remote_path>", "Print the remote hierarchy as a tree"},
+	{"getprop", "<remote_path> [prop...]", "Print raw MTP object properties by name or hex code"},
+	{"prop", "get <remote_path> [prop...] | set <remote_path> <prop> <value>", "Print or change MTP object properties"},
+	{"hash", "[--algo md5|sha1|sha256] <remote_path>", "Print the digest of remote files without downloading them"},
+	{"cat", "<remote_path> [...]", "Stream remote files to stdout"},
+	{"archive", "[--format tar|zip] [--skip-hidden] <remote_dir> [<local_file>|-]", "Write a remote directory tree into a tar or zip archive, - for stdout"},
+	{"thumbnail", "<remote_path> <local_file>", "Save the thumbnail of a media file, - for stdout"},
+	{"watch", "<remote_dir> <local_dir> [--interval 10s] [--skip-existing] | --push <local_dir> <remote_dir> [--debounce 2s]", "Download new remote files, or with --push upload changed local files, until interrupted"},
+	{"events", "[--interval 2s]", "Stream object and storage changes on the device as event lines until interrupted"},
+	{"device-info", "", "Show basic device information"},
+	{"device", "prop list | prop get <prop> | prop set <prop> <value>", "Print or change MTP device properties such as friendly-name"},
+	{"battery", "", "Print the battery level in percent"},
+	{"capabilities", "", "Print the MTP operations, events, formats and properties the device supports"},
+	{"storage-info", "", "Show storage-related information"},
+	{"df", "[<storage>]", "Print the capacity, used and free space of each storage"},
+	{"benchmark", "[--size <bytes>] [--direction up|down|both] [--latency-runs N] [<remote_dir>]", "Measure the latency and transfer throughput of the device with a temporary file"},
+	{"fingerprint", "", "Print a stable identifier for the connected device"},
+	{"manifest", "<remote_path> -o <file>", "Write an inventory of a remote subtree to a JSON file"},
+	{"reconnect", "", "Reopen the device session and re-select the storage"},
+	{"serve", "[--socket <path>] [--metrics-listen <addr>]", "Answer JSON-RPC requests on stdin/stdout or a unix socket with one device session"},
+	{"mount", "<remote_path> <mountpoint>", "Mount the storage as a FUSE file system (builds with -tags fuse)"},
+	{"http", "[--listen 127.0.0.1:8080]", "Serve a REST API for listing, downloading, uploading and deleting files"},
+	{"shell", "", "Run commands interactively on one device session (ls, cd, get, put, rm, pwd)"},
+	{"job", "add [--state <file>] <command> <args...> | list [--status <status>] | cancel <id> [...] | resume [<id> ...]", "Queue transfers in a local state file and run them, resuming interrupted ones where they stopped"},
+	{"batch", "[--stop-on-error]", "Run commands read from stdin on one device session, tagging output with request ids"},
+	{"list-devices", "", "List connected MTP devices for --device"},
+	{"doctor", "", "Check USB access, udev rules, kernel drivers, competing MTP clients, device state and storage and suggest fixes"},
+	{"completion", "bash|zsh|fish", "Print a shell completion script"},
 }
 
 func printUsage() {
-	fmt.Println("Usage: mtpx-cli <command> [arguments]")
+	fmt.Println("Usage: mtpx-cli [global flags] <command> [arguments]")
 	fmt.Println("Commands:")
-	fmt.Println("  list <remote_path>                  List files at remote path")
-	fmt.Println("  download <remote> <local_dir>       Download a file into target directory")
-	fmt.Println("  upload <local_file> <remote_dir>    Upload a file into remote directory")
-	fmt.Println("  delete <remote_path> [...]          Delete one or more files by remote path")
-	fmt.Println("  stat <remote_path>                  Check if a file exists and print its size")
-	fmt.Println("  device-info                         Show basic device information")
-	fmt.Println("  storage-info                        Show storage-related information")
+	for _, c := range commands {
+		fmt.Printf("  %-35s %s\n", strings.TrimSpace(c.name+" "+c.args), c.desc)
+	}
+	fmt.Println("Global flags:")
+	flag.VisitAll(func(f *flag.Flag) {
+		fmt.Printf("  --%-33s %s\n", f.Name, f.Usage)
+	})
+}
+
+// parseArgs parses flags interspersed with positional arguments and returns
+// the positional arguments in order
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, usageError{err}
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// outputMu keeps lines written from concurrent goroutines from interleaving
+var outputMu sync.Mutex
+
+// resultOut receives printJSON output and completion markers. It is stdout
+// except while serve answers a request.
+var resultOut io.Writer = os.Stdout
+
+// progressOut receives progress and transfer summary lines, see --progress-fd
+var progressOut io.Writer = os.Stdout
+
+// openProgressOutput points progressOut at the already open file descriptor
+// fd. 0 keeps progress on stdout.
+func openProgressOutput(fd int) error {
+	if fd == 0 {
+		return nil
+	}
+	if fd < 0 {
+		return usagef("invalid --progress-fd %d", fd)
+	}
+
+	f := os.NewFile(uintptr(fd), fmt.Sprintf("progress-fd-%d", fd))
+	if _, err := f.Stat(); err != nil {
+		return fmt.Errorf("--progress-fd %d is not an open file descriptor: %w", fd, err)
+	}
+	progressOut = f
+	return nil
 }
 
-// JSON output helpers
+// JSON output helpers. printJSON writes entry lines, see writeEnvelope.
 func printJSON(v interface{}) error {
+	return writeEnvelope(resultOut, typeEntry, v)
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
 	b, err := json.Marshal(v)
 	if err != nil {
 		return fmt.Errorf("failed to marshal JSON: %w", err)
 	}
-	fmt.Println(string(b))
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	fmt.Fprintln(w, string(b))
 	return nil
 }
 
-func printProgress(file string, progress float64) error {
-	return printJSON(map[string]interface{}{
-		"file":     file,
-		"progress": progress,
-	})
+// printDone prints an operation's done line, or its completion sentinel with
+// --legacy-output, unless --no-sentinel is set
+func printDone(sentinel string) {
+	if *noSentinel {
+		return
+	}
+	if !*legacyOutput {
+		writeEnvelope(resultOut, typeDone, map[string]string{"command": doneCommand(sentinel)})
+		return
+	}
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	fmt.Fprintln(resultOut, sentinel)
+}
+
+// printProgress reports that sent of size bytes of a file are transferred at
+// rate bytes per second, 0 while unknown
+func printProgress(objectId uint32, file, path string, sent, size int64, rate float64) error {
+	if *noProgress {
+		return nil
+	}
+	if humanProgress() {
+		bars.draw(objectId, file, sent, size)
+		return nil
+	}
+	fields := map[string]interface{}{
+		"file":        file,
+		"path":        path,
+		"object_id":   objectId,
+		"progress":    percent(sent, size),
+		"bytes_done":  sent,
+		"bytes_total": size,
+		"speed":       int64(rate),
+	}
+	if rate > 0 {
+		fields["eta_seconds"] = int64(float64(size-sent)/rate + 0.5)
+	}
+	return writeEnvelope(progressOut, typeProgress, fields)
+}
+
+// printTreeProgress reports the aggregate progress of a tree transfer after
+// each file. worker is the queue worker that finished the file, 0 when the
+// transfer runs without workers.
+func printTreeProgress(worker int, filesDone, filesTotal, bytesDone, bytesTotal int64) error {
+	if *noProgress {
+		return nil
+	}
+	if humanProgress() {
+		bars.drawTree(filesDone, filesTotal, bytesDone, bytesTotal)
+		return nil
+	}
+	fields := map[string]interface{}{
+		"files_done":  filesDone,
+		"files_total": filesTotal,
+		"bytes_done":  bytesDone,
+		"bytes_total": bytesTotal,
+		"progress":    percent(bytesDone, bytesTotal),
+	}
+	if worker > 0 {
+		fields["worker"] = worker
+	}
+	return writeEnvelope(progressOut, typeProgress, fields)
+}
+
+// printPlanned reports an action that --dry-run kept from happening
+func printPlanned(action string, fields map[string]interface{}) error {
+	fields["action"] = action
+	return printJSON(fields)
 }
 
 func printTransferSummary(source, target string) error {
-	return printJSON(map[string]string{
+	// the finished progress bar already says as much
+	if humanProgress() {
+		return nil
+	}
+	return writeEnvelope(progressOut, typeProgress, map[string]string{
 		"source": source,
 		"target": target,
 	})
@@ -119,32 +574,277 @@ func printTransferSummary(source, target string) error {
 
 // Command handlers
 func (c *CLI) handleList(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	mtpInfo := fs.Bool("mtp-info", false, "include raw MTP format and association fields")
+	skipHidden := fs.Bool("skip-hidden", false, "leave out hidden objects and their contents")
+	formatName := fs.String("format", "", "only list files of this kind: image, video, audio, document or an object format code")
+	long := fs.Bool("long", false, "include the type, modification time and object id of each entry")
+	sortBy := fs.String("sort", "", "sort entries by name, size or mtime instead of walk order")
+	reverse := fs.Bool("reverse", false, "reverse the order of the entries")
+	limit := fs.Int("limit", 0, "print at most this many entries (0 prints all)")
+	offset := fs.Int("offset", 0, "leave out this many entries first, for paging with --limit")
+	var recursive bool
+	fs.BoolVar(&recursive, "r", false, "list the whole tree below the remote path, not only its direct children")
+	fs.BoolVar(&recursive, "recursive", false, "same as -r")
+	maxDepth := fs.Int("max-depth", 0, "list this many levels below the remote path (0 is 1 level, or the whole tree with -r)")
+	allStorages := fs.Bool("all-storages", false, "list the path on every storage and add storage and storage_id to each entry")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
 	if len(args) < 1 {
-		return fmt.Errorf("list requires remote path")
+		return usagef("list requires remote path")
+	}
+	format, err := parseFormat(*formatName)
+	if err != nil {
+		return err
+	}
+	switch *sortBy {
+	case "", "name", "size", "mtime":
+	default:
+		return usagef("invalid --sort %q: must be name, size or mtime", *sortBy)
+	}
+	if *limit < 0 || *offset < 0 {
+		return usagef("--limit and --offset must not be negative")
+	}
+	if *maxDepth < 0 {
+		return usagef("invalid --max-depth %d: must not be negative", *maxDepth)
+	}
+	depth := *maxDepth
+	switch {
+	case depth == 0 && recursive:
+		depth = -1
+	case depth == 0:
+		depth = 1
 	}
 
-	_, _, _, err := mtpx.Walk(c.device, c.storage, args[0], true, true, false,
+	storages := []mtpx.StorageData{{Sid: c.storage}}
+	if *allStorages {
+		if storages, err = mtpx.FetchStorages(c.device); err != nil {
+			return fmt.Errorf("failed to fetch storages: %w", err)
+		}
+		defer func(sid uint32) { c.storage = sid }(c.storage)
+	}
+
+	// sorting and paging need the whole listing, otherwise entries are
+	// printed as the walk finds them
+	buffered := *sortBy != "" || *reverse || *limit > 0 || *offset > 0
+	var entries []listEntry
+	var missing error
+	found := false
+	for _, s := range storages {
+		c.storage = s.Sid
+		err := c.listStorage(args[0], *skipHidden, *mtpInfo, format, depth, func(entry map[string]interface{}, fi *mtpx.FileInfo) {
+			if *allStorages {
+				entry["storage"] = storageLabel(s)
+				entry["storage_id"] = s.Sid
+			}
+			if *long {
+				addLongFields(entry, fi)
+			}
+			if buffered {
+				entries = append(entries, listEntry{entry, fi})
+				return
+			}
+			printJSON(entry)
+		})
+		// with --all-storages the path only has to exist on one of them
+		if *allStorages && isNotFound(err) {
+			missing = err
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		found = true
+	}
+	if !found {
+		return missing
+	}
+
+	if buffered {
+		sortListing(entries, *sortBy, *reverse)
+		for _, e := range paginate(entries, *offset, *limit) {
+			printJSON(e.entry)
+		}
+	}
+
+	printDone("MTPX_LIST_DONE")
+	return nil
+}
+
+// listStorage lists the path or glob pattern p on the current storage
+func (c *CLI) listStorage(p string, skipHidden, mtpInfo bool, format *formatFilter, maxDepth int, emit func(entry map[string]interface{}, fi *mtpx.FileInfo)) error {
+	roots, err := c.expandRemote(p)
+	if err != nil {
+		return err
+	}
+	for _, root := range roots {
+		if err := c.listTree(root, skipHidden, mtpInfo, format, maxDepth, emit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listEntry is a buffered entry of list with its object
+type listEntry struct {
+	entry map[string]interface{}
+	fi    *mtpx.FileInfo
+}
+
+// addLongFields adds the fields of list --long
+func addLongFields(entry map[string]interface{}, fi *mtpx.FileInfo) {
+	entry["type"] = "file"
+	if fi.IsDir {
+		entry["type"] = "dir"
+	}
+	if !fi.ModTime.IsZero() {
+		entry["mtime"] = fi.ModTime
+	}
+	entry["object_id"] = fi.ObjectId
+}
+
+// sortListing sorts by key, the walk order when it is empty. Names compare by
+// full path so a directory's contents stay together, ties keep walk order.
+func sortListing(entries []listEntry, key string, reverse bool) {
+	less := func(a, b *mtpx.FileInfo) bool {
+		switch key {
+		case "name":
+			return a.FullPath < b.FullPath
+		case "size":
+			return a.Size < b.Size
+		case "mtime":
+			return a.ModTime.Before(b.ModTime)
+		}
+		return false
+	}
+	if key != "" {
+		sort.SliceStable(entries, func(i, j int) bool { return less(entries[i].fi, entries[j].fi) })
+	}
+	if reverse {
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+	}
+}
+
+// paginate returns the entries after offset, at most limit of them unless
+// limit is 0
+func paginate(entries []listEntry, offset, limit int) []listEntry {
+	if offset >= len(entries) {
+		return nil
+	}
+	entries = entries[offset:]
+	if limit > 0 && limit < len(entries) {
+		entries = entries[:limit]
+	}
+	return entries
+}
+
+// listTree calls emit with the entry and object of everything below root
+// that matches format, nil for all, down to maxDepth levels or all of them
+// when it is negative
+func (c *CLI) listTree(root string, skipHidden, mtpInfo bool, format *formatFilter, maxDepth int, emit func(entry map[string]interface{}, fi *mtpx.FileInfo)) error {
+	hidden := newHiddenFilter(c)
+	return c.walkDepth(root, maxDepth, skipHidden,
 		func(objectId uint32, fi *mtpx.FileInfo, err error) error {
 			if err == nil {
-				printJSON(map[string]interface{}{
+				entry := map[string]interface{}{
 					"path": fi.FullPath,
 					"size": fi.Size,
-				})
+				}
+				// hidden.skip tracks hidden directories, so it sees every object
+				if hidden.skip(fi) {
+					if skipHidden {
+						return nil
+					}
+					entry["hidden"] = true
+				}
+				if !format.match(fi) {
+					return nil
+				}
+				if mtpInfo {
+					c.addMTPInfo(entry, fi)
+				}
+				emit(entry, fi)
 			}
 			return nil
 		})
-	
+}
+
+// walkDepth is mtpx.Walk limited to maxDepth levels below root, unlimited
+// when it is negative. Deeper levels are listed by object id, which saves
+// resolving the path of every directory again, in the same depth-first order.
+func (c *CLI) walkDepth(root string, maxDepth int, skipHidden bool, cb mtpx.WalkCb) error {
+	recursive := maxDepth < 0
+	_, _, _, err := c.walk(root, recursive, true, skipHidden,
+		func(objectId uint32, fi *mtpx.FileInfo, err error) error {
+			if err := cb(objectId, fi, err); err != nil {
+				return err
+			}
+			if err == nil && fi.IsDir && !recursive && maxDepth > 1 {
+				return c.walkChildren(fi, 2, maxDepth, skipHidden, cb)
+			}
+			return nil
+		})
+	return err
+}
+
+// walkChildren calls cb for the objects in dir, which is depth levels below
+// the root of walkDepth, and descends until maxDepth
+func (c *CLI) walkChildren(dir *mtpx.FileInfo, depth, maxDepth int, skipHidden bool, cb mtpx.WalkCb) error {
+	children, err := c.children(dir)
 	if err != nil {
 		return err
 	}
-	
-	fmt.Println("MTPX_LIST_DONE")
+	for _, fi := range children {
+		id := fi.ObjectId
+		if skipHidden && strings.HasPrefix(fi.Name, ".") {
+			continue
+		}
+		if err := cb(id, fi, nil); err != nil {
+			return err
+		}
+		if fi.IsDir && depth < maxDepth {
+			if err := c.walkChildren(fi, depth+1, maxDepth, skipHidden, cb); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
 func (c *CLI) handleDownload(args []string) error {
+	fs := flag.NewFlagSet("download", flag.ContinueOnError)
+	// directories are always downloaded with their subtree, -r only states it
+	fs.Bool("r", false, "download a remote directory tree (the default for directories)")
+	fs.Bool("recursive", false, "same as -r")
+	rawNames := fs.Bool("raw-names", false, "keep remote names verbatim instead of making them safe for the local filesystem")
+	skipHidden := fs.Bool("skip-hidden", false, "don't download hidden objects and their contents")
+	chunkSize := fs.Int("chunk-size", 0, "fetch files in partial transfers of this many bytes (0 fetches each file in one transfer)")
+	skipExisting := fs.Bool("skip-existing", activeProfile.overwrite == overwriteSkip, "skip files that already exist locally and match the remote file")
+	compare := fs.String("compare", compareSizeMtime, "how --skip-existing matches local files: size or size-mtime")
+	maxRate := fs.Int64("max-rate", 0, "limit the transfer rate to this many bytes per second (0 is unlimited)")
+	resume := fs.Bool("resume", false, "continue partial local files and keep them when a transfer fails")
+	verify := fs.String("verify", "", "read each downloaded file back from the device and compare hashes: sha256")
+	concurrency := fs.Int("concurrency", 1, "number of files transferred in parallel")
+	onConflict := fs.String("on-conflict", conflictOverwrite, "what to do with existing local files: skip, overwrite, rename or newer")
+	formatName := fs.String("format", "", "only download files of this kind from directories: image, video, audio, document or an object format code")
+	renameTemplate := fs.String("rename-template", "", "name files after a template of {name}, {base}, {ext}, {date}, {mtime} and {counter}")
+	ages := addAgeFlags(fs, "download")
+	filters := addFilterFlags(fs)
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 1 && activeProfile.downloadDir != "" {
+		args = append(args, activeProfile.downloadDir)
+	}
 	if len(args) < 2 {
-		return fmt.Errorf("download requires remote path and local target dir")
+		return usagef("download requires remote path and local target dir")
 	}
 
 	targetDir, err := filepath.Abs(args[1])
@@ -152,23 +852,98 @@ func (c *CLI) handleDownload(args []string) error {
 		return fmt.Errorf("invalid target path: %w", err)
 	}
 
-	handler := &ProgressHandler{targetDir: targetDir}
-	
-	_, _, err = mtpx.DownloadFiles(c.device, c.storage, []string{args[0]}, targetDir, false,
-		func(fi *mtpx.FileInfo, err error) error { return nil },
-		handler.handleDownloadProgress)
-	
+	if err := validateChunkSize(*chunkSize); err != nil {
+		return err
+	}
+	if *maxRate < 0 {
+		return usagef("invalid --max-rate %d: must not be negative", *maxRate)
+	}
+	if err := validateCompare(*compare); err != nil {
+		return err
+	}
+	if err := validateVerify(*verify); err != nil {
+		return err
+	}
+	if err := validateConcurrency(*concurrency); err != nil {
+		return err
+	}
+	if err := validateConflict(*onConflict); err != nil {
+		return err
+	}
+	if *resume && *onConflict != conflictOverwrite {
+		return usagef("--resume continues existing local files and can't be used with --on-conflict %s", *onConflict)
+	}
+	filter, err := filters.filter()
+	if err != nil {
+		return err
+	}
+	format, err := parseFormat(*formatName)
 	if err != nil {
 		return err
 	}
-	
-	fmt.Println("MTPX_DOWNLOAD_DONE")
+	rename, err := parseRenameTemplate(*renameTemplate)
+	if err != nil {
+		return err
+	}
+	age, err := ages.filter()
+	if err != nil {
+		return err
+	}
+
+	d := &downloader{
+		cli:          c,
+		rawNames:     *rawNames,
+		skipHidden:   *skipHidden,
+		chunkSize:    *chunkSize,
+		skipExisting: *skipExisting,
+		compare:      *compare,
+		limiter:      newRateLimiter(*maxRate),
+		resume:       *resume,
+		verify:       *verify,
+		concurrency:  *concurrency,
+		onConflict:   *onConflict,
+		filter:       filter,
+		format:       format,
+		rename:       rename,
+		age:          age,
+	}
+	remotes, err := c.expandRemote(args[0])
+	if err != nil {
+		return err
+	}
+	transfers.begin()
+	for _, remote := range remotes {
+		if err := d.download(remote, targetDir); err != nil {
+			return err
+		}
+	}
+	transfers.printSummary("download")
+
+	printDone("MTPX_DOWNLOAD_DONE")
 	return nil
 }
 
 func (c *CLI) handleUpload(args []string) error {
+	fs := flag.NewFlagSet("upload", flag.ContinueOnError)
+	var recursive bool
+	fs.BoolVar(&recursive, "r", false, "upload a local directory tree")
+	fs.BoolVar(&recursive, "recursive", false, "upload a local directory tree")
+	chunkSize := fs.Int("chunk-size", 0, "send files in partial transfers of this many bytes (0 sends each file in one transfer)")
+	maxRate := fs.Int64("max-rate", 0, "limit the transfer rate to this many bytes per second (0 is unlimited)")
+	verify := fs.String("verify", "", "read each uploaded file back from the device and compare hashes: sha256")
+	concurrency := fs.Int("concurrency", 1, "number of files transferred in parallel with -r")
+	onConflict := fs.String("on-conflict", conflictOverwrite, "what to do with existing remote files: skip, overwrite, rename or newer")
+	ignoreSpace := fs.Bool("ignore-space", false, "upload even if the files don't fit in the free space of the storage")
+	extract := fs.Bool("extract", false, "create the contents of a local zip or tar archive in the remote directory")
+	skipExisting := fs.Bool("skip-existing", false, "skip files that already exist remotely with the same size and modification time")
+	filters := addFilterFlags(fs)
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
 	if len(args) < 2 {
-		return fmt.Errorf("upload requires local file and remote target dir")
+		return usagef("upload requires local file and remote target dir")
 	}
 
 	localFile, err := filepath.Abs(args[0])
@@ -176,65 +951,399 @@ func (c *CLI) handleUpload(args []string) error {
 		return fmt.Errorf("invalid local file path: %w", err)
 	}
 
-	handler := &ProgressHandler{
-		sourcePath: localFile,
-		targetDir:  args[1],
+	if err := validateChunkSize(*chunkSize); err != nil {
+		return err
 	}
-	
-	_, _, _, err = mtpx.UploadFiles(c.device, c.storage, []string{localFile}, args[1], false,
-		func(fi *os.FileInfo, path string, err error) error { return nil },
-		handler.handleUploadProgress)
-	
+	if *maxRate < 0 {
+		return usagef("invalid --max-rate %d: must not be negative", *maxRate)
+	}
+
+	if err := validateVerify(*verify); err != nil {
+		return err
+	}
+	if err := validateConcurrency(*concurrency); err != nil {
+		return err
+	}
+	if err := validateConflict(*onConflict); err != nil {
+		return err
+	}
+	filter, err := filters.filter()
+	if err != nil {
+		return err
+	}
+	if *extract && (recursive || *chunkSize > 0 || *verify != "" || *concurrency > 1 || *onConflict != conflictOverwrite || *skipExisting) {
+		return usagef("--extract can't be used with -r, --chunk-size, --verify, --concurrency, --on-conflict or --skip-existing")
+	}
+
+	u := &uploader{cli: c, chunkSize: *chunkSize, limiter: newRateLimiter(*maxRate), verify: *verify, concurrency: *concurrency, onConflict: *onConflict, filter: filter, ignoreSpace: *ignoreSpace, skipExisting: *skipExisting}
+	remoteDir := remotePath(args[1])
+	transfers.begin()
+	if *extract {
+		err = u.uploadArchive(localFile, remoteDir)
+	} else if recursive {
+		err = u.uploadTree(localFile, remoteDir)
+	} else {
+		// a file that can't be read is reported by the upload
+		if st, serr := os.Stat(localFile); serr == nil {
+			err = c.checkFreeSpace(st.Size(), *ignoreSpace)
+		}
+		if err == nil {
+			err = u.uploadFile(localFile, remoteDir)
+		}
+	}
+
 	if err != nil {
 		return err
 	}
-	
-	fmt.Println("MTPX_UPLOAD_DONE")
+	transfers.printSummary("upload")
+
+	printDone("MTPX_UPLOAD_DONE")
 	return nil
 }
 
 func (c *CLI) handleDelete(args []string) error {
-	if len(args) < 1 {
-		return fmt.Errorf("delete requires at least one remote path")
+	fs := flag.NewFlagSet("delete", flag.ContinueOnError)
+	var interactive bool
+	fs.BoolVar(&interactive, "i", false, "ask for confirmation before each delete")
+	fs.BoolVar(&interactive, "interactive", false, "ask for confirmation before each delete")
+	yes := fs.Bool("yes", false, "never ask for confirmation")
+	fs.BoolVar(yes, "y", false, "same as --yes")
+	report := fs.Bool("report", false, "delete paths one by one and print whether each existed and was deleted")
+	var recursive bool
+	fs.BoolVar(&recursive, "r", false, "delete directories object by object after confirming their size")
+	fs.BoolVar(&recursive, "recursive", false, "same as -r")
+	force := fs.Bool("force", false, "delete directories with -r without confirmation")
+	ages := addAgeFlags(fs, "delete")
+	trash := fs.String("trash", "", "move objects into this remote trash directory instead of deleting them")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
 	}
 
-	var props []mtpx.FileProp
-	for _, path := range args {
-		props = append(props, mtpx.FileProp{FullPath: path})
+	if len(args) < 1 {
+		return usagef("delete requires at least one remote path")
+	}
+	if recursive && *report {
+		return usagef("--report can't be used with -r, which reports every deleted object")
+	}
+	if *trash != "" && *report {
+		return usagef("--report can't be used with --trash, which reports every trashed object")
 	}
 
-	err := mtpx.DeleteFile(c.device, c.storage, props)
+	age, err := ages.filter()
 	if err != nil {
 		return err
 	}
 
-	fmt.Println("MTPX_DELETE_DONE")
+	prompt := newPrompter(interactive && !*dryRun, *yes)
+
+	var props, trees []mtpx.FileProp
+	for _, arg := range args {
+		remotes, err := c.expandRemote(arg)
+		if err != nil {
+			return err
+		}
+		if age != nil {
+			if remotes, err = c.filesInAge(remotes, recursive, age); err != nil {
+				return err
+			}
+		}
+		for _, remote := range remotes {
+			if !prompt.confirm(fmt.Sprintf("delete %s?", remote)) {
+				printJSON(map[string]interface{}{
+					"path":    remote,
+					"skipped": true,
+					"reason":  "declined",
+				})
+				continue
+			}
+			// directories go to the trash in one move, with -r they are
+			// deleted object by object once confirmed below
+			if recursive && age == nil && *trash == "" {
+				trees = append(trees, mtpx.FileProp{FullPath: remote})
+				continue
+			}
+			props = append(props, mtpx.FileProp{FullPath: remote})
+		}
+	}
+
+	// without -i the paths are confirmed all at once
+	summary := newPrompter(!interactive && !*dryRun, *yes)
+	if all := append(props[:len(props):len(props)], trees...); summary.enabled && len(all) > 0 {
+		verb := "delete"
+		if *trash != "" {
+			verb = "trash"
+		}
+		paths, size, dirs := c.deletionSummary(all)
+		if !summary.confirmDeletion(verb, paths, size, dirs) {
+			printDeclined(len(all))
+			printDone("MTPX_DELETE_DONE")
+			return nil
+		}
+	}
+
+	// a tree confirmed with -i or the summary isn't asked about again
+	for _, tree := range trees {
+		if err := c.deleteTree(tree.FullPath, *force || *yes || prompt.enabled || summary.enabled); err != nil {
+			return err
+		}
+	}
+
+	if *trash != "" {
+		for _, prop := range props {
+			fi, err := c.objectFromPath(prop.FullPath)
+			if err != nil {
+				return err
+			}
+			if err := c.moveToTrash(fi, remotePath(*trash)); err != nil {
+				return err
+			}
+		}
+		printDone("MTPX_DELETE_DONE")
+		return nil
+	}
+
+	if *dryRun {
+		for _, prop := range props {
+			printPlanned("delete", map[string]interface{}{"path": prop.FullPath})
+		}
+		printDone("MTPX_DELETE_DONE")
+		return nil
+	}
+
+	if *report {
+		return c.deleteWithReport(props)
+	}
+
+	if len(props) > 0 {
+		if err := mtpx.DeleteFile(c.device, c.storage, props); err != nil {
+			return err
+		}
+	}
+
+	printDone("MTPX_DELETE_DONE")
+	return nil
+}
+
+// filesInAge returns the files among remotes modified within age. With
+// recursive the files below directories are searched too, without it
+// directories are reported as skipped. Directories themselves are never
+// returned, as their dates say nothing about what they hold.
+func (c *CLI) filesInAge(remotes []string, recursive bool, age *ageFilter) ([]string, error) {
+	var files []string
+	for _, remote := range remotes {
+		fi, err := c.objectFromPath(remote)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir {
+			if age.match(fi) {
+				files = append(files, fi.FullPath)
+			}
+			continue
+		}
+		if !recursive {
+			printJSON(map[string]interface{}{
+				"path":    fi.FullPath,
+				"skipped": true,
+				"reason":  "directory",
+			})
+			continue
+		}
+		_, _, _, err = c.walk(fi.FullPath, true, false, false,
+			func(objectId uint32, fi *mtpx.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if !fi.IsDir && age.match(fi) {
+					files = append(files, fi.FullPath)
+				}
+				return nil
+			})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// deletionSummary looks up the objects about to be deleted for
+// confirmDeletion. Directories are listed with a trailing slash. Paths that
+// can't be looked up are listed as they are, deleting them reports why.
+func (c *CLI) deletionSummary(props []mtpx.FileProp) (paths []string, size int64, dirs int) {
+	for _, prop := range props {
+		fi, err := c.objectFromPath(prop.FullPath)
+		switch {
+		case err != nil:
+			paths = append(paths, prop.FullPath)
+		case fi.IsDir:
+			paths = append(paths, strings.TrimSuffix(fi.FullPath, "/")+"/")
+			dirs++
+		default:
+			paths = append(paths, fi.FullPath)
+			size += fi.Size
+		}
+	}
+	return paths, size, dirs
+}
+
+// deleteResult is the outcome of deleting a single path with --report
+type deleteResult struct {
+	Path    string `json:"path"`
+	Existed bool   `json:"existed"`
+	Deleted bool   `json:"deleted"`
+	Error   string `json:"error,omitempty"`
+}
+
+// deleteWithReport deletes each path on its own and prints a JSON array with
+// the outcome of every path. DeleteFile can't be used here: it doesn't report
+// per path and stops at the first path that doesn't exist.
+func (c *CLI) deleteWithReport(props []mtpx.FileProp) error {
+	results := []deleteResult{}
+	failed := 0
+	for _, prop := range props {
+		r := c.deletePath(prop)
+		if r.Error != "" {
+			failed++
+		}
+		results = append(results, r)
+	}
+
+	printJSON(results)
+	printDone("MTPX_DELETE_DONE")
+
+	if failed > 0 {
+		return fmt.Errorf("failed to delete %d of %d paths", failed, len(results))
+	}
 	return nil
 }
 
+// deletePath deletes a single object looked up by path. Directories are
+// deleted with their contents.
+func (c *CLI) deletePath(prop mtpx.FileProp) deleteResult {
+	r := deleteResult{Path: prop.FullPath}
+
+	exists, err := c.fileExists([]mtpx.FileProp{prop})
+	switch {
+	case err != nil:
+		r.Error = err.Error()
+	case len(exists) == 0:
+		r.Error = "failed to look up path"
+	case exists[0].Exists:
+		r.Existed = true
+		if err := c.device.DeleteObject(exists[0].FileInfo.ObjectId); err != nil {
+			r.Error = err.Error()
+		} else {
+			r.Deleted = true
+		}
+	}
+	return r
+}
+
 func (c *CLI) handleStat(args []string) error {
+	fs := flag.NewFlagSet("stat", flag.ContinueOnError)
+	mtpInfo := fs.Bool("mtp-info", false, "print raw MTP format and association fields as JSON")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
 	if len(args) < 1 {
-		return fmt.Errorf("stat requires a remote path")
+		return usagef("stat requires a remote path")
 	}
 
-	props := []mtpx.FileProp{{FullPath: args[0]}}
-	results, err := mtpx.FileExists(c.device, c.storage, props)
+	remotes, err := c.expandRemote(args[0])
+	if errors.Is(err, errNoGlobMatch) {
+		printNotFound(remotePath(args[0]))
+		printDone("MTPX_STAT_DONE")
+		return nil
+	}
 	if err != nil {
 		return err
 	}
 
-	info := results[0]
-	if info.Exists {
+	for _, remote := range remotes {
+		props := []mtpx.FileProp{{FullPath: remote}}
+		results, err := c.fileExists(props)
+		if err != nil {
+			return err
+		}
+		if len(results) == 0 {
+			return fmt.Errorf("failed to look up %s", remote)
+		}
+
+		info := results[0]
+		if !info.Exists {
+			printNotFound(remote)
+			continue
+		}
+
 		fi := info.FileInfo
+		if !*legacyOutput {
+			entry := map[string]interface{}{
+				"path":       fi.FullPath,
+				"exists":     true,
+				"size":       fi.Size,
+				"is_dir":     fi.IsDir,
+				"object_id":  fi.ObjectId,
+				"parent_id":  fi.ParentId,
+				"storage_id": c.storage,
+			}
+			if fi.Info != nil && fi.Info.StorageID != 0 {
+				entry["storage_id"] = fi.Info.StorageID
+			}
+			if !fi.ModTime.IsZero() {
+				entry["mtime"] = fi.ModTime
+			}
+			if fi.IsDir {
+				n, err := c.childCount(fi.ObjectId)
+				if err != nil {
+					return fmt.Errorf("failed to count the children of %s: %w", fi.FullPath, err)
+				}
+				entry["child_count"] = n
+			}
+			if *mtpInfo {
+				c.addMTPInfo(entry, fi)
+			}
+			printJSON(entry)
+			continue
+		}
+
 		fmt.Printf("STAT\t%s\t%d\t%s\n", fi.FullPath, fi.Size, humanReadableSize(fi.Size))
-	} else {
-		fmt.Println("NOT_FOUND")
+		if *mtpInfo {
+			entry := map[string]interface{}{"path": fi.FullPath}
+			c.addMTPInfo(entry, fi)
+			printJSON(entry)
+		}
 	}
-	
-	fmt.Println("MTPX_STAT_DONE")
+
+	printDone("MTPX_STAT_DONE")
 	return nil
 }
 
+// childCount returns the number of objects directly below a directory
+func (c *CLI) childCount(objectId uint32) (int, error) {
+	if t := c.cachedTree(); t != nil {
+		if o := t.object(objectId); o != nil {
+			return len(t.children[o.Path]), nil
+		}
+	}
+	var handles mtp.Uint32Array
+	if err := c.device.GetObjectHandles(c.storage, 0, objectId, &handles); err != nil {
+		return 0, err
+	}
+	return len(handles.Values), nil
+}
+
+// printNotFound reports a stat of a missing path
+func printNotFound(remote string) {
+	if *legacyOutput {
+		fmt.Println("NOT_FOUND")
+		return
+	}
+	printJSON(map[string]interface{}{"path": remote, "exists": false})
+}
+
 func (c *CLI) handleDeviceInfo(args []string) error {
 	info, err := mtpx.FetchDeviceInfo(c.device)
 	if err != nil {
@@ -242,7 +1351,7 @@ func (c *CLI) handleDeviceInfo(args []string) error {
 	}
 
 	printJSON(info)
-	fmt.Println("MTPX_DEVICE_INFO_DONE")
+	printDone("MTPX_DEVICE_INFO_DONE")
 	return nil
 }
 
@@ -252,40 +1361,102 @@ func (c *CLI) handleStorageInfo(args []string) error {
 		return fmt.Errorf("failed to fetch storage info: %w", err)
 	}
 
-	printJSON(storages)
-	fmt.Println("MTPX_STORAGE_INFO_DONE")
+	entries := []storageEntry{}
+	for i, s := range storages {
+		entries = append(entries, storageEntry{Index: i, Label: storageLabel(s), StorageData: s})
+	}
+
+	printJSON(entries)
+	printDone("MTPX_STORAGE_INFO_DONE")
 	return nil
 }
 
 // Progress handlers
-func (p *ProgressHandler) handleDownloadProgress(pi *mtpx.ProgressInfo, err error) error {
+func (p *ProgressHandler) handleUploadProgress(pi *mtpx.ProgressInfo, err error) error {
+	fi := pi.FileInfo
+	if fi.ObjectId != p.objectId {
+		progress.begin(fi.ObjectId)
+	}
+	p.objectId = fi.ObjectId
+
+	// the callback runs inside the transfer, so sleeping here slows it down
+	sent := pi.ActiveFileSize.Sent
+	if sent < p.sent {
+		p.sent = 0
+	}
+	p.limiter.wait(int(sent - p.sent))
+	p.sent = sent
 	if pi.ActiveFileSize.Progress < 100.0 {
-		printProgress(pi.FileInfo.Name, float64(pi.ActiveFileSize.Progress))
-	} else if pi.ActiveFileSize.Progress == 100.0 && !p.printedDone {
-		printProgress(pi.FileInfo.Name, 100.0)
-		p.printedDone = true
-		
-		sourcePath := pi.FileInfo.FullPath
-		targetPath := filepath.Join(p.targetDir, pi.FileInfo.Name)
-		printTransferSummary(sourcePath, targetPath)
+		return progress.update(fi.ObjectId, fi.Name, fi.FullPath, sent, pi.ActiveFileSize.Total)
+	}
+	targetPath := filepath.Join(p.targetDir, fi.Name)
+	progress.complete(fi.ObjectId, fi.Name, p.sourcePath, targetPath, pi.ActiveFileSize.Total)
+	return canceled()
+}
+
+// Utility functions
+
+// makeRemoteDir creates dir and its parents on the device. With --dry-run it
+// only reports a missing directory.
+func (c *CLI) makeRemoteDir(dir string) error {
+	if *dryRun {
+		if c.remoteExists(dir) {
+			return nil
+		}
+		return printPlanned("mkdir", map[string]interface{}{"path": dir})
+	}
+
+	if _, err := mtpx.MakeDirectory(c.device, c.storage, dir); err != nil {
+		return fmt.Errorf("failed to create remote directory %s: %w", dir, err)
 	}
 	return nil
 }
 
-func (p *ProgressHandler) handleUploadProgress(pi *mtpx.ProgressInfo, err error) error {
-	if pi.ActiveFileSize.Progress < 100.0 {
-		printProgress(pi.FileInfo.Name, float64(pi.ActiveFileSize.Progress))
-	} else if pi.ActiveFileSize.Progress == 100.0 && !p.printedDone {
-		printProgress(pi.FileInfo.Name, 100.0)
-		p.printedDone = true
-		
-		targetPath := filepath.Join(p.targetDir, pi.FileInfo.Name)
-		printTransferSummary(p.sourcePath, targetPath)
+func (c *CLI) remoteExists(p string) bool {
+	results, err := c.fileExists([]mtpx.FileProp{{FullPath: p}})
+	return err == nil && len(results) == 1 && results[0].Exists
+}
+
+// makeLocalDir creates dir and its parents. With --dry-run it only reports a
+// missing directory.
+func makeLocalDir(dir string) error {
+	if *dryRun {
+		if _, err := os.Stat(dir); err == nil {
+			return nil
+		}
+		return printPlanned("mkdir", map[string]interface{}{"path": dir})
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create local directory: %w", err)
+	}
+	return nil
+}
+
+// remotePath resolves a remote path argument against --cwd. Paths starting
+// with "/" are absolute and used as given.
+func remotePath(p string) string {
+	if strings.HasPrefix(p, "/") {
+		return path.Clean(p)
+	}
+	return path.Join("/", *remoteCwd, p)
+}
+
+// Bounds for --chunk-size
+const (
+	minChunkSize = 4 * 1024
+	maxChunkSize = 64 * 1024 * 1024
+)
+
+// validateChunkSize accepts 0 (transfer each file in one go) or a size within
+// the chunk size bounds
+func validateChunkSize(size int) error {
+	if size != 0 && (size < minChunkSize || size > maxChunkSize) {
+		return usagef("invalid --chunk-size %d: must be between %d and %d bytes", size, minChunkSize, maxChunkSize)
 	}
 	return nil
 }
 
-// Utility functions
 func humanReadableSize(bytes int64) string {
 	units := []string{"B", "KB", "MB", "GB"}
 	size := float64(bytes)
@@ -295,4 +1466,4 @@ func humanReadableSize(bytes int64) string {
 		i++
 	}
 	return fmt.Sprintf("%.1f %s", size, units[i])
-}
\ No newline at end of file
+}
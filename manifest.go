@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// manifestEntry describes one object in a manifest
+type manifestEntry struct {
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Mtime    time.Time `json:"mtime"`
+	ObjectId uint32    `json:"object_id"`
+	Type     string    `json:"type"`
+
+	// SHA256 is the digest of the file, recorded by backup
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// handleManifest writes an inventory of a remote subtree to a file. Entries
+// are streamed to the file as the tree is walked, so huge trees are never held
+// in memory.
+func (c *CLI) handleManifest(args []string) error {
+	fs := flag.NewFlagSet("manifest", flag.ContinueOnError)
+	out := fs.String("o", "", "file to write the manifest to")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) < 1 {
+		return usagef("manifest requires a remote path")
+	}
+	if *out == "" {
+		return usagef("manifest requires an output file (-o)")
+	}
+
+	id, err := c.deviceIdentity()
+	if err != nil {
+		return err
+	}
+
+	root := remotePath(args[0])
+	count, err := c.writeManifest(*out, root, id.Fingerprint)
+	if err != nil {
+		os.Remove(*out)
+		return err
+	}
+
+	printJSON(map[string]interface{}{
+		"manifest": *out,
+		"root":     root,
+		"entries":  count,
+	})
+
+	printDone("MTPX_MANIFEST_DONE")
+	return nil
+}
+
+// writeManifest walks root and writes the manifest header followed by one
+// entry per object
+func (c *CLI) writeManifest(file, root, fingerprint string) (int, error) {
+	m, err := createManifest(file, root, fingerprint)
+	if err != nil {
+		return 0, err
+	}
+	defer m.f.Close()
+
+	_, _, _, err = c.walk(root, true, true, false,
+		func(objectId uint32, fi *mtpx.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			return m.add(newManifestEntry(fi))
+		})
+	if err != nil {
+		return 0, err
+	}
+	return m.count, m.close()
+}
+
+func newManifestEntry(fi *mtpx.FileInfo) manifestEntry {
+	entry := manifestEntry{
+		Path:     fi.FullPath,
+		Size:     fi.Size,
+		Mtime:    fi.ModTime,
+		ObjectId: fi.ObjectId,
+		Type:     "file",
+	}
+	if fi.IsDir {
+		entry.Type = "dir"
+	}
+	return entry
+}
+
+// manifestWriter streams a manifest to a file, entry by entry
+type manifestWriter struct {
+	f     *os.File
+	w     *bufio.Writer
+	count int
+}
+
+// createManifest creates file and writes the manifest header
+func createManifest(file, root, fingerprint string) (*manifestWriter, error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create manifest: %w", err)
+	}
+	m := &manifestWriter{f: f, w: bufio.NewWriter(f)}
+
+	header, err := json.Marshal(map[string]interface{}{
+		"generated_at": time.Now().UTC().Format(time.RFC3339),
+		"fingerprint":  fingerprint,
+		"root":         root,
+	})
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	// leave the header object open so the entries array can be appended
+	fmt.Fprintf(m.w, "%s,\"entries\":[", header[:len(header)-1])
+	return m, nil
+}
+
+func (m *manifestWriter) add(entry manifestEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if m.count > 0 {
+		m.w.WriteString(",")
+	}
+	m.w.WriteString("\n")
+	m.w.Write(b)
+	m.count++
+	return nil
+}
+
+// close ends the entries array and closes the file
+func (m *manifestWriter) close() error {
+	m.w.WriteString("\n]}\n")
+	if err := m.w.Flush(); err != nil {
+		m.f.Close()
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return m.f.Close()
+}
+
+// manifestFile is a manifest as read back from disk
+type manifestFile struct {
+	GeneratedAt string          `json:"generated_at"`
+	Fingerprint string          `json:"fingerprint"`
+	Root        string          `json:"root"`
+	Entries     []manifestEntry `json:"entries"`
+}
+
+func readManifest(file string) (*manifestFile, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open manifest: %w", err)
+	}
+	defer f.Close()
+
+	var m manifestFile
+	if err := json.NewDecoder(bufio.NewReader(f)).Decode(&m); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %w", file, err)
+	}
+	return &m, nil
+}
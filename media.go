@@ -0,0 +1,113 @@
+package main
+
+import (
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/ganeshrvel/go-mtpfs/mtp"
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// Media kinds for --format
+const (
+	mediaImage    = "image"
+	mediaVideo    = "video"
+	mediaAudio    = "audio"
+	mediaDocument = "document"
+)
+
+// mediaFormats are the MTP object formats of each media kind
+var mediaFormats = map[string][]uint16{
+	mediaImage: {
+		mtp.OFC_EXIF_JPEG, mtp.OFC_TIFF_EP, mtp.OFC_FlashPix, mtp.OFC_BMP, mtp.OFC_CIFF, mtp.OFC_GIF,
+		mtp.OFC_JFIF, mtp.OFC_PCD, mtp.OFC_PICT, mtp.OFC_PNG, mtp.OFC_TIFF, mtp.OFC_TIFF_IT,
+		mtp.OFC_JP2, mtp.OFC_JPX, mtp.OFC_DNG, mtp.OFC_CANON_CRW, mtp.OFC_CANON_CRW3,
+		mtp.OFC_MTP_WindowsImageFormat,
+	},
+	mediaVideo: {
+		mtp.OFC_AVI, mtp.OFC_MPEG, mtp.OFC_ASF, mtp.OFC_CANON_MOV, mtp.OFC_MTP_UndefinedVideo,
+		mtp.OFC_MTP_WMV, mtp.OFC_MTP_MP4, mtp.OFC_MTP_MP2, mtp.OFC_MTP_3GP,
+	},
+	mediaAudio: {
+		mtp.OFC_AIFF, mtp.OFC_WAV, mtp.OFC_MP3, mtp.OFC_MTP_M4A, mtp.OFC_MTP_UndefinedAudio,
+		mtp.OFC_MTP_WMA, mtp.OFC_MTP_OGG, mtp.OFC_MTP_AAC, mtp.OFC_MTP_AudibleCodec, mtp.OFC_MTP_FLAC,
+	},
+	mediaDocument: {
+		mtp.OFC_Text, mtp.OFC_HTML, mtp.OFC_MTP_UndefinedDocument, mtp.OFC_MTP_AbstractDocument,
+		mtp.OFC_MTP_XMLDocument, mtp.OFC_MTP_MSWordDocument, mtp.OFC_MTP_MHTCompiledHTMLDocument,
+		mtp.OFC_MTP_MSExcelSpreadsheetXLS, mtp.OFC_MTP_MSPowerpointPresentationPPT,
+	},
+}
+
+// mediaExts are the extensions of each media kind, for objects the device
+// reports as OFC_Undefined, as Android does for formats it doesn't know
+var mediaExts = map[string][]string{
+	mediaImage:    {".jpg", ".jpeg", ".png", ".gif", ".webp", ".heic", ".heif", ".bmp", ".tif", ".tiff", ".dng", ".raw", ".cr2", ".cr3", ".nef", ".arw"},
+	mediaVideo:    {".mp4", ".mov", ".m4v", ".3gp", ".mkv", ".avi", ".webm", ".wmv", ".mpg", ".mpeg", ".ts"},
+	mediaAudio:    {".mp3", ".m4a", ".aac", ".ogg", ".opus", ".flac", ".wav", ".wma", ".aiff", ".amr", ".mid"},
+	mediaDocument: {".txt", ".pdf", ".doc", ".docx", ".xls", ".xlsx", ".ppt", ".pptx", ".odt", ".ods", ".odp", ".rtf", ".md", ".html", ".htm", ".xml", ".csv", ".epub"},
+}
+
+// mediaKind returns the media kind of a file from its object format, or from
+// its extension when the format says nothing, and "" for other files
+func mediaKind(fi *mtpx.FileInfo) string {
+	if fi.Info != nil {
+		for kind, formats := range mediaFormats {
+			for _, f := range formats {
+				if fi.Info.ObjectFormat == f {
+					return kind
+				}
+			}
+		}
+	}
+	return mediaKindByName(fi.Name)
+}
+
+func mediaKindByName(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	for kind, exts := range mediaExts {
+		for _, e := range exts {
+			if ext == e {
+				return kind
+			}
+		}
+	}
+	return ""
+}
+
+// formatFilter is the --format of a command: a media kind, or a raw object
+// format code like 0xb982
+type formatFilter struct {
+	kind string
+	code uint16
+}
+
+// parseFormat parses --format, nil when it is empty
+func parseFormat(s string) (*formatFilter, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if _, ok := mediaFormats[s]; ok {
+		return &formatFilter{kind: s}, nil
+	}
+	if code, err := strconv.ParseUint(s, 0, 16); err == nil && strings.HasPrefix(s, "0x") {
+		return &formatFilter{code: uint16(code)}, nil
+	}
+	return nil, usagef("invalid --format %q: must be %s, %s, %s, %s or an object format code like 0x3801",
+		s, mediaImage, mediaVideo, mediaAudio, mediaDocument)
+}
+
+// match reports whether fi is a file of the format, a nil filter matches
+// everything. Directories never match.
+func (f *formatFilter) match(fi *mtpx.FileInfo) bool {
+	switch {
+	case f == nil:
+		return true
+	case fi.IsDir:
+		return false
+	case f.kind == "":
+		return fi.Info != nil && fi.Info.ObjectFormat == f.code
+	}
+	return mediaKind(fi) == f.kind
+}
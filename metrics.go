@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ganeshrvel/go-mtpfs/mtp"
+)
+
+// latencyBuckets are the upper bounds in seconds of the operation latency
+// histogram, from a stat to a multi-gigabyte transfer
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// metricsRegistry counts the requests and transfers of serve and http for
+// the Prometheus /metrics endpoint
+type metricsRegistry struct {
+	mu sync.Mutex
+
+	// operations counts requests by operation and status, latencies holds
+	// their histograms by operation
+	operations map[[2]string]int64
+	latencies  map[string]*histogram
+
+	// errors counts failed requests by MTP response code, or by error code
+	// for failures without one
+	errors map[string]int64
+
+	files, bytes int64
+}
+
+type histogram struct {
+	counts []int64 // per bucket, not cumulative
+	sum    float64
+	count  int64
+}
+
+// metrics is set while serve or http run, the other commands record nothing
+var metrics *metricsRegistry
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{
+		operations: map[[2]string]int64{},
+		latencies:  map[string]*histogram{},
+		errors:     map[string]int64{},
+	}
+}
+
+// observe records a finished request
+func (m *metricsRegistry) observe(operation string, elapsed time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status := "ok"
+	if err != nil {
+		status = "error"
+		m.errors[errorLabel(err)]++
+	}
+	m.operations[[2]string{operation, status}]++
+
+	h, ok := m.latencies[operation]
+	if !ok {
+		h = &histogram{counts: make([]int64, len(latencyBuckets))}
+		m.latencies[operation] = h
+	}
+	secs := elapsed.Seconds()
+	for i, le := range latencyBuckets {
+		if secs <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += secs
+	h.count++
+}
+
+// transferred records a finished file transfer, see progress.complete
+func (m *metricsRegistry) transferred(size int64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files++
+	m.bytes += size
+}
+
+// errorLabel names the MTP response code of err, such as AccessDenied, or
+// its error code for failures the device didn't answer with one
+func errorLabel(err error) string {
+	var rc mtp.RCError
+	if errors.As(err, &rc) {
+		return rc.Error()
+	}
+	return errorCode(err)
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format
+func (m *metricsRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.write(w)
+}
+
+func (m *metricsRegistry) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP mtpx_operations_total Requests answered, by operation and status.")
+	fmt.Fprintln(w, "# TYPE mtpx_operations_total counter")
+	ops := make([][2]string, 0, len(m.operations))
+	for k := range m.operations {
+		ops = append(ops, k)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		if ops[i][0] != ops[j][0] {
+			return ops[i][0] < ops[j][0]
+		}
+		return ops[i][1] < ops[j][1]
+	})
+	for _, k := range ops {
+		fmt.Fprintf(w, "mtpx_operations_total{operation=%s,status=%s} %d\n", labelValue(k[0]), labelValue(k[1]), m.operations[k])
+	}
+
+	fmt.Fprintln(w, "# HELP mtpx_operation_duration_seconds Time to answer a request, by operation.")
+	fmt.Fprintln(w, "# TYPE mtpx_operation_duration_seconds histogram")
+	for _, op := range sortedLabels(m.latencies) {
+		h := m.latencies[op]
+		var cumulative int64
+		for i, le := range latencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "mtpx_operation_duration_seconds_bucket{operation=%s,le=\"%g\"} %d\n", labelValue(op), le, cumulative)
+		}
+		fmt.Fprintf(w, "mtpx_operation_duration_seconds_bucket{operation=%s,le=\"+Inf\"} %d\n", labelValue(op), h.count)
+		fmt.Fprintf(w, "mtpx_operation_duration_seconds_sum{operation=%s} %g\n", labelValue(op), h.sum)
+		fmt.Fprintf(w, "mtpx_operation_duration_seconds_count{operation=%s} %d\n", labelValue(op), h.count)
+	}
+
+	fmt.Fprintln(w, "# HELP mtpx_errors_total Failed requests, by MTP response code or error code.")
+	fmt.Fprintln(w, "# TYPE mtpx_errors_total counter")
+	for _, code := range sortedLabels(m.errors) {
+		fmt.Fprintf(w, "mtpx_errors_total{code=%s} %d\n", labelValue(code), m.errors[code])
+	}
+
+	fmt.Fprintln(w, "# HELP mtpx_transfers_total Files transferred in either direction.")
+	fmt.Fprintln(w, "# TYPE mtpx_transfers_total counter")
+	fmt.Fprintf(w, "mtpx_transfers_total %d\n", m.files)
+	fmt.Fprintln(w, "# HELP mtpx_transferred_bytes_total Bytes of the files transferred.")
+	fmt.Fprintln(w, "# TYPE mtpx_transferred_bytes_total counter")
+	fmt.Fprintf(w, "mtpx_transferred_bytes_total %d\n", m.bytes)
+}
+
+func sortedLabels[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// labelValue quotes a label value, escaping as the text format requires
+func labelValue(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// serveMetrics serves the metrics on /metrics at addr in the background,
+// until stop is called
+func serveMetrics(addr string) (stop func(), err error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics)
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(l)
+	return func() { srv.Close() }, nil
+}
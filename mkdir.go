@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path"
+
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+func (c *CLI) handleMkdir(args []string) error {
+	fs := flag.NewFlagSet("mkdir", flag.ContinueOnError)
+	parents := fs.Bool("p", false, "create missing parent directories and don't fail when the directory exists")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) < 1 {
+		return usagef("mkdir requires at least one remote path")
+	}
+
+	for _, arg := range args {
+		dir := remotePath(arg)
+		if dir == "/" {
+			return fmt.Errorf("mkdir: cannot create /")
+		}
+
+		// MakeDirectory always creates the whole path, so plain mkdir
+		// checks the parent and the target itself first
+		if !*parents {
+			if err := c.checkMkdir(dir); err != nil {
+				return err
+			}
+		}
+
+		objectId, err := mtpx.MakeDirectory(c.device, c.storage, dir)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+
+		printJSON(map[string]interface{}{
+			"path":      dir,
+			"object_id": objectId,
+		})
+	}
+
+	printDone("MTPX_MKDIR_DONE")
+	return nil
+}
+
+// checkMkdir fails when dir exists or its parent is missing
+func (c *CLI) checkMkdir(dir string) error {
+	props := []mtpx.FileProp{{FullPath: dir}, {FullPath: path.Dir(dir)}}
+	results, err := c.fileExists(props)
+	if err != nil {
+		return err
+	}
+	if len(results) != len(props) {
+		return fmt.Errorf("failed to look up %s", dir)
+	}
+
+	if results[0].Exists {
+		return fmt.Errorf("mkdir: %s already exists", dir)
+	}
+	if path.Dir(dir) != "/" && !results[1].Exists {
+		return fmt.Errorf("mkdir: parent directory %s does not exist (use -p)", path.Dir(dir))
+	}
+	if path.Dir(dir) != "/" && !results[1].FileInfo.IsDir {
+		return fmt.Errorf("mkdir: %s is not a directory", path.Dir(dir))
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+//go:build fuse
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/ganeshrvel/go-mtpfs/fs"
+	"github.com/ganeshrvel/go-mtpfs/mtp"
+	fusefs "github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// handleMount mounts the selected storage with the go-mtpfs file system on
+// the open session until interrupted or unmounted. go-mtpfs keeps the object
+// tree in memory and streams file data with the Android extensions where the
+// device has them, falling back to a local backing directory otherwise.
+func (c *CLI) handleMount(args []string) error {
+	args, err := parseMountArgs(args)
+	if err != nil {
+		return err
+	}
+
+	remote, err := c.resolveRemote(args[0])
+	if err != nil {
+		return err
+	}
+	mountpoint, err := filepath.Abs(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid mountpoint: %w", err)
+	}
+
+	fi, err := c.objectFromPath(remote)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir {
+		return fmt.Errorf("not a directory: %s", remote)
+	}
+
+	var info mtp.StorageInfo
+	if err := c.device.GetStorageInfo(c.storage, &info); err != nil {
+		return fmt.Errorf("failed to read storage info: %w", err)
+	}
+
+	root, err := fs.NewDeviceFSRoot(c.device, []uint32{c.storage}, fs.DeviceFsOptions{
+		RemovableVFat: true,
+		Android:       true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set up file system: %w", err)
+	}
+
+	// go-mtpfs must run single threaded since the session can't be shared
+	timeout := time.Second
+	server, err := fusefs.Mount(mountpoint, root, &fusefs.Options{
+		MountOptions: fuse.MountOptions{
+			SingleThreaded: true,
+			FsName:         "mtpx",
+			Name:           "mtpx",
+		},
+		UID:          uint32(os.Getuid()),
+		GID:          uint32(os.Getgid()),
+		AttrTimeout:  &timeout,
+		EntryTimeout: &timeout,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to mount %s: %w", mountpoint, err)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	go func() {
+		<-sig
+		server.Unmount()
+	}()
+
+	server.WaitMount()
+	// go-mtpfs mounts every storage as a directory named by its description
+	printJSON(map[string]string{
+		"mountpoint": mountpoint,
+		"path":       filepath.Join(mountpoint, info.StorageDescription, filepath.FromSlash(fi.FullPath)),
+	})
+	server.Wait()
+	root.OnUnmount()
+
+	printDone("MTPX_MOUNT_DONE")
+	return nil
+}
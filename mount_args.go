@@ -0,0 +1,17 @@
+package main
+
+import "flag"
+
+// parseMountArgs checks the mount arguments the same way with and without the
+// fuse build tag
+func parseMountArgs(args []string) ([]string, error) {
+	fs := flag.NewFlagSet("mount", flag.ContinueOnError)
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) != 2 {
+		return nil, usagef("mount requires remote path and mountpoint")
+	}
+	return args, nil
+}
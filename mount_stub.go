@@ -0,0 +1,14 @@
+//go:build !fuse
+
+package main
+
+import "fmt"
+
+// handleMount is only available in builds with the fuse tag, which pull in
+// go-fuse and need FUSE support on the host
+func (c *CLI) handleMount(args []string) error {
+	if _, err := parseMountArgs(args); err != nil {
+		return err
+	}
+	return fmt.Errorf("mount is not available in this build, rebuild with: go build -tags fuse")
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/ganeshrvel/go-mtpfs/mtp"
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// handleMove renames and/or moves an object on the device without
+// transferring its data. Like mv, a destination that is an existing directory
+// receives the object under its current name. Existing files are never
+// replaced. With --to-storage the object moves to another storage, and when
+// the device can't move it there it is copied through the host and deleted.
+func (c *CLI) handleMove(args []string) error {
+	fs := flag.NewFlagSet("mv", flag.ContinueOnError)
+	toStorage := fs.String("to-storage", "", "move to this storage: index or id from storage-info, or a storage label")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) < 2 {
+		return usagef("mv requires remote source and destination")
+	}
+
+	src, err := c.resolveRemote(args[0])
+	if err != nil {
+		return err
+	}
+	if src == "/" {
+		return fmt.Errorf("mv: cannot move the root directory")
+	}
+
+	srcStorage := c.storage
+	dstStorage, err := c.targetStorage(*toStorage)
+	if err != nil {
+		return err
+	}
+	defer func() { c.storage = srcStorage }()
+
+	results, err := c.fileExists([]mtpx.FileProp{{FullPath: src}})
+	if err != nil {
+		return err
+	}
+	if len(results) != 1 || !results[0].Exists {
+		return notFoundf("file not found: %s", src)
+	}
+	fi := results[0].FileInfo
+
+	// the destination is looked up on the storage it is on
+	c.storage = dstStorage
+	dst, err := c.resolveRemote(args[1])
+	if err != nil {
+		return err
+	}
+	if existing, err := c.fileExists([]mtpx.FileProp{{FullPath: dst}}); err != nil {
+		return err
+	} else if len(existing) == 1 && existing[0].Exists {
+		if !existing[0].FileInfo.IsDir {
+			return fmt.Errorf("mv: %s already exists", dst)
+		}
+		dst = path.Join(dst, fi.Name)
+		if c.remoteExists(dst) {
+			return fmt.Errorf("mv: %s already exists", dst)
+		}
+	}
+
+	if fi.IsDir && dstStorage == srcStorage && (dst == src || strings.HasPrefix(dst, src+"/")) {
+		return fmt.Errorf("mv: cannot move %s into itself", src)
+	}
+
+	result := map[string]interface{}{
+		"source":    src,
+		"target":    dst,
+		"object_id": fi.ObjectId,
+	}
+	if dstStorage != srcStorage {
+		result["storage_id"] = dstStorage
+	}
+
+	c.storage = srcStorage
+	newName := path.Base(dst)
+	if newName != fi.Name {
+		if _, err := mtpx.RenameFile(c.device, c.storage, mtpx.FileProp{ObjectId: fi.ObjectId}, newName); err != nil {
+			return fmt.Errorf("failed to rename %s: %w", src, err)
+		}
+		fi.Name = newName
+		fi.FullPath = path.Join(path.Dir(src), newName)
+	}
+
+	if path.Dir(dst) != path.Dir(src) || dstStorage != srcStorage {
+		c.storage = dstStorage
+		err := c.moveObject(fi.ObjectId, path.Dir(dst))
+		c.storage = srcStorage
+		switch {
+		case err == nil:
+		case dstStorage != srcStorage && (errors.Is(err, errNoMoveObject) || errors.As(err, new(mtp.RCError))):
+			if err := c.copyAndDelete(fi, dst, srcStorage, dstStorage); err != nil {
+				return err
+			}
+			delete(result, "object_id")
+			result["method"] = "copy_and_delete"
+		default:
+			return fmt.Errorf("failed to move %s: %w", src, err)
+		}
+	}
+
+	printJSON(result)
+
+	printDone("MTPX_MV_DONE")
+	return nil
+}
+
+// copyAndDelete moves fi to dst on another storage for devices that can't
+// move objects between storages: the object is copied, through the host
+// with progress where the device can't copy it there either, and deleted
+// once the copy is complete
+func (c *CLI) copyAndDelete(fi *mtpx.FileInfo, dst string, from, to uint32) error {
+	info, err := mtpx.FetchDeviceInfo(c.device)
+	if err != nil {
+		return err
+	}
+	cp := &copier{cli: c, native: supportsOperation(info, mtp.OC_CopyObject), from: from, to: to}
+	if fi.IsDir {
+		err = cp.copyTree(fi, dst)
+	} else {
+		err = cp.copyFile(fi, dst)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.storage = from
+	return c.deleteTree(fi.FullPath, true)
+}
+
+// errNoMoveObject is returned by moveObject on devices without MoveObject
+var errNoMoveObject = errors.New("the device doesn't support MoveObject")
+
+// moveObject moves an object below the existing directory parentDir on the
+// current storage with the MTP MoveObject operation
+func (c *CLI) moveObject(objectId uint32, parentDir string) error {
+	info, err := mtpx.FetchDeviceInfo(c.device)
+	if err != nil {
+		return err
+	}
+	if !supportsOperation(info, mtp.OC_MoveObject) {
+		return errNoMoveObject
+	}
+
+	parent, err := c.objectFromPath(parentDir)
+	if err != nil {
+		return err
+	}
+	if !parent.IsDir {
+		return fmt.Errorf("%s is not a directory", parentDir)
+	}
+
+	// MoveObject addresses the storage root as 0, not as the root parent
+	// handle used for listing
+	parentId := parent.ObjectId
+	if parentDir == "/" {
+		parentId = 0
+	}
+
+	req := mtp.Container{
+		Code:  mtp.OC_MoveObject,
+		Param: []uint32{objectId, c.storage, parentId},
+	}
+	var rep mtp.Container
+	return c.device.RunTransaction(&req, &rep, nil, nil, 0, mtp.EmptyProgressFunc)
+}
+
+func supportsOperation(info *mtp.DeviceInfo, code uint16) bool {
+	for _, op := range info.OperationsSupported {
+		if op == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/ganeshrvel/go-mtpfs/mtp"
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// addMTPInfo adds the raw MTP format and association fields of fi to entry.
+// Objects referencing other objects (playlists, albums) are reported with
+// kind "reference" and their resolved targets so they are not mistaken for
+// regular files.
+func (c *CLI) addMTPInfo(entry map[string]interface{}, fi *mtpx.FileInfo) {
+	if fi.Info == nil {
+		return
+	}
+
+	info := fi.Info
+	entry["object_id"] = fi.ObjectId
+	entry["format"] = info.ObjectFormat
+	entry["format_name"] = mtp.OFC_names[int(info.ObjectFormat)]
+	entry["association_type"] = info.AssociationType
+	entry["association_name"] = mtp.AT_names[int(info.AssociationType)]
+	entry["association_desc"] = info.AssociationDesc
+
+	if fi.IsDir {
+		entry["kind"] = "dir"
+		return
+	}
+
+	entry["kind"] = "file"
+	refs, err := objectReferences(c.device, fi.ObjectId)
+	if err != nil || len(refs) == 0 {
+		return
+	}
+
+	paths := []string{}
+	for _, id := range refs {
+		p, err := objectPath(c.device, id)
+		if err != nil {
+			continue
+		}
+		paths = append(paths, p)
+	}
+	entry["kind"] = "reference"
+	entry["references"] = paths
+}
+
+// objectReferences returns the object IDs referenced by objectId. Devices
+// without reference support answer with an error.
+func objectReferences(dev *mtp.Device, objectId uint32) ([]uint32, error) {
+	req := mtp.Container{
+		Code:  mtp.OC_MTP_GetObjectReferences,
+		Param: []uint32{objectId},
+	}
+
+	var refs mtp.Uint32Array
+	if err := dev.GetData(&req, &refs); err != nil {
+		return nil, err
+	}
+	return refs.Values, nil
+}
+
+// objectPath resolves the full remote path of an object by walking up its parents
+func objectPath(dev *mtp.Device, objectId uint32) (string, error) {
+	var parts []string
+	for objectId != 0 && objectId != mtpx.ParentObjectId {
+		var info mtp.ObjectInfo
+		if err := dev.GetObjectInfo(objectId, &info); err != nil {
+			return "", err
+		}
+		parts = append([]string{info.Filename}, parts...)
+		objectId = info.ParentObject
+	}
+	return "/" + strings.Join(parts, "/"), nil
+}
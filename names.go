@@ -0,0 +1,71 @@
+package main
+
+import (
+	"runtime"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// windowsReservedNames can't be used as a file name on Windows, with or
+// without an extension
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// safeLocalName turns a remote object name into one the host filesystem
+// accepts: the name is normalized to NFC and characters or names the host OS
+// rejects are replaced with "_". The result is a fixed point, so a name that
+// was sanitized on download comes back unchanged when it is uploaded and
+// downloaded again.
+func safeLocalName(name string) string {
+	name = norm.NFC.String(name)
+
+	switch runtime.GOOS {
+	case "windows":
+		name = replaceChars(name, func(r rune) bool {
+			return r < 0x20 || strings.ContainsRune(`<>:"/\|?*`, r)
+		})
+		name = strings.TrimRight(name, ". ")
+		base, ext, _ := strings.Cut(name, ".")
+		if windowsReservedNames[strings.ToUpper(base)] {
+			name = base + "_"
+			if ext != "" {
+				name += "." + ext
+			}
+		}
+	case "darwin":
+		name = replaceChars(name, func(r rune) bool { return r == ':' || r == '/' })
+	default:
+		name = replaceChars(name, func(r rune) bool { return r == '/' || r == 0 })
+	}
+
+	if name == "" || name == "." || name == ".." {
+		return "_"
+	}
+	return name
+}
+
+// confinedName keeps a remote name verbatim except for what would take it out
+// of the directory it is written to: path separators become "_", as do the
+// names "." and "..". --raw-names still goes through it.
+func confinedName(name string) string {
+	name = replaceChars(name, func(r rune) bool { return r == '/' || r == '\\' })
+	if name == "" || name == "." || name == ".." {
+		return "_"
+	}
+	return name
+}
+
+func replaceChars(name string, illegal func(r rune) bool) string {
+	return strings.Map(func(r rune) rune {
+		if illegal(r) {
+			return '_'
+		}
+		return r
+	}, name)
+}
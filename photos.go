@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// importState is the file import-photos keeps between runs, the remote files
+// imported so far by path
+type importState struct {
+	Imported map[string]importedFile `json:"imported"`
+}
+
+type importedFile struct {
+	Size   int64     `json:"size"`
+	Mtime  time.Time `json:"mtime"`
+	Target string    `json:"target"`
+}
+
+// defaultImportState is the name of the state file in the local directory
+const defaultImportState = ".mtpx-import.json"
+
+var layoutToken = regexp.MustCompile(`\{[^}]*\}`)
+
+// validateLayout checks the tokens of --layout
+func validateLayout(layout string) error {
+	for _, tok := range layoutToken.FindAllString(layout, -1) {
+		switch tok {
+		case "{yyyy}", "{yy}", "{mm}", "{dd}":
+		default:
+			return usagef("invalid --layout token %s: must be {yyyy}, {yy}, {mm} or {dd}", tok)
+		}
+	}
+	if filepath.IsAbs(layout) || strings.Contains(layout, "..") {
+		return usagef("invalid --layout %q: must be a relative path", layout)
+	}
+	return nil
+}
+
+// expandLayout returns the directory of layout for a file taken at t
+func expandLayout(layout string, t time.Time) string {
+	return layoutToken.ReplaceAllStringFunc(layout, func(tok string) string {
+		switch tok {
+		case "{yyyy}":
+			return t.Format("2006")
+		case "{yy}":
+			return t.Format("06")
+		case "{mm}":
+			return t.Format("01")
+		case "{dd}":
+			return t.Format("02")
+		}
+		return tok
+	})
+}
+
+func (c *CLI) handleImportPhotos(args []string) (err error) {
+	fs := flag.NewFlagSet("import-photos", flag.ContinueOnError)
+	layout := fs.String("layout", "{yyyy}/{mm}/{dd}", "directories below the local dir by capture date: {yyyy}, {yy}, {mm} and {dd}")
+	stateFile := fs.String("state", "", "file that records imported files (default <local_dir>/"+defaultImportState+")")
+	deleteAfter := fs.Bool("delete", false, "delete each file from the device after its copy was verified by sha256")
+	renameTemplate := fs.String("rename-template", "", "name files after a template of {name}, {base}, {ext}, {date}, {mtime} and {counter}")
+	args, err = parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) < 2 {
+		return usagef("import-photos requires remote dir and local dir")
+	}
+	if err := validateLayout(*layout); err != nil {
+		return err
+	}
+	rename, err := parseRenameTemplate(*renameTemplate)
+	if err != nil {
+		return err
+	}
+
+	localDir, err := filepath.Abs(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid local path: %w", err)
+	}
+	if *stateFile == "" {
+		*stateFile = filepath.Join(localDir, defaultImportState)
+	}
+	state, err := readImportState(*stateFile)
+	if err != nil {
+		return err
+	}
+
+	root := remotePath(args[0])
+	var files []*mtpx.FileInfo
+	hidden := newHiddenFilter(c)
+	_, _, _, err = c.walk(root, true, true, true,
+		func(objectId uint32, fi *mtpx.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			// thumbnail caches are hidden directories full of images
+			if hidden.skip(fi) || strings.HasPrefix(fi.Name, ".") {
+				return nil
+			}
+			if kind := mediaKind(fi); !fi.IsDir && (kind == mediaImage || kind == mediaVideo) {
+				files = append(files, fi)
+			}
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+
+	if err := makeLocalDir(localDir); err != nil {
+		return err
+	}
+
+	d := &downloader{cli: c, limiter: newRateLimiter(0), rename: rename, staged: true}
+	if *deleteAfter {
+		d.verify = hashSHA256
+	}
+
+	// the state is saved however the run ends, so files imported so far
+	// aren't fetched again
+	defer func() {
+		if !*dryRun {
+			if serr := writeImportState(*stateFile, state); serr != nil && err == nil {
+				err = serr
+			}
+		}
+	}()
+
+	transfers.begin()
+	var imported, deleted int64
+	for _, fi := range files {
+		if prev, ok := state.Imported[fi.FullPath]; ok && prev.Size == fi.Size {
+			transfers.skip()
+			continue
+		}
+
+		target, source, err := c.importFile(d, fi, localDir, *layout)
+		if err != nil {
+			return err
+		}
+		imported++
+		if *dryRun {
+			continue
+		}
+		state.Imported[fi.FullPath] = importedFile{Size: fi.Size, Mtime: fi.ModTime, Target: target}
+		printJSON(map[string]interface{}{
+			"path":        fi.FullPath,
+			"target":      target,
+			"date_source": source,
+		})
+
+		if *deleteAfter {
+			if err := c.device.DeleteObject(fi.ObjectId); err != nil {
+				return fmt.Errorf("failed to delete %s: %w", fi.FullPath, err)
+			}
+			deleted++
+			printJSON(map[string]interface{}{
+				"event": "deleted",
+				"path":  fi.FullPath,
+				"size":  fi.Size,
+			})
+		}
+	}
+
+	printJSON(map[string]interface{}{
+		"imported": imported,
+		"skipped":  int64(len(files)) - imported,
+		"deleted":  deleted,
+	})
+	transfers.printSummary("import-photos")
+
+	printDone("MTPX_IMPORT_PHOTOS_DONE")
+	return nil
+}
+
+// importFile downloads fi next to its destination, reads the capture date
+// from its EXIF data, or else takes the remote modification time, and moves
+// it into the layout directory. It returns the local path and where the date
+// came from, exif or mtime.
+func (c *CLI) importFile(d *downloader, fi *mtpx.FileInfo, localDir, layout string) (string, string, error) {
+	name := d.localName(fi.Name)
+	if *dryRun {
+		target := d.renamedPath(fi, filepath.Join(localDir, expandLayout(layout, fi.ModTime)), fi.ModTime)
+		return target, "mtime", printPlanned("import", map[string]interface{}{
+			"source": fi.FullPath,
+			"target": target,
+			"size":   fi.Size,
+		})
+	}
+
+	staging := filepath.Join(localDir, ".mtpx-import-"+name)
+	if err := d.downloadFile(fi, staging); err != nil {
+		return "", "", err
+	}
+
+	taken, source := fi.ModTime, "mtime"
+	if t, ok := exifDate(staging); ok {
+		taken, source = t, "exif"
+	}
+
+	dir := filepath.Join(localDir, expandLayout(layout, taken))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", "", fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	// a different file of the same name, cameras restart their numbering
+	target := d.renamedPath(fi, dir, taken)
+	if _, err := os.Lstat(target); err == nil {
+		target = freeLocalPath(target)
+	}
+	if err := os.Rename(staging, target); err != nil {
+		return "", "", fmt.Errorf("failed to move %s to %s: %w", staging, target, err)
+	}
+	fileCompleted(directionDownload, fi.FullPath, target, fi.Size)
+	return target, source, nil
+}
+
+func readImportState(file string) (*importState, error) {
+	state := &importState{Imported: map[string]importedFile{}}
+	b, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read import state: %w", err)
+	}
+	if err := json.Unmarshal(b, state); err != nil {
+		return nil, fmt.Errorf("invalid import state %s: %w", file, err)
+	}
+	if state.Imported == nil {
+		state.Imported = map[string]importedFile{}
+	}
+	return state, nil
+}
+
+// writeImportState replaces file in one rename, so a crash leaves the old state
+func writeImportState(file string, state *importState) error {
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(file+partSuffix, b, 0644); err != nil {
+		return fmt.Errorf("failed to write import state: %w", err)
+	}
+	return os.Rename(file+partSuffix, file)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// progressRegistry serializes the progress output of concurrent transfers.
+// State is kept per object id from begin until complete, so every transfer
+// reports completion exactly once no matter how many callbacks arrive at
+// 100%, and long running serve, http and watch sessions don't pile up
+// finished files.
+type progressRegistry struct {
+	mu    sync.Mutex
+	files map[uint32]*fileProgress
+}
+
+type fileProgress struct {
+	// rate is the smoothed transfer speed in bytes per second, measured
+	// from the updates since start. base is the offset the transfer resumed
+	// from.
+	start, last    time.Time
+	base, lastSent int64
+	rate           float64
+
+	// printed is when the last progress line went out, for
+	// --progress-interval
+	printed time.Time
+}
+
+// rateInterval is the minimum time between two speed measurements, shorter
+// gaps between callbacks make for noise
+const rateInterval = 250 * time.Millisecond
+
+// measure updates the speed with sent bytes. The first call only sets the
+// baseline, which leaves out the offset of a resumed transfer.
+func (f *fileProgress) measure(sent int64) {
+	now := time.Now()
+	if f.last.IsZero() {
+		f.last, f.base, f.lastSent = now, sent, sent
+		return
+	}
+	elapsed := now.Sub(f.last)
+	if elapsed < rateInterval {
+		return
+	}
+
+	current := float64(sent-f.lastSent) / elapsed.Seconds()
+	if f.rate == 0 {
+		f.rate = current
+	} else {
+		f.rate = 0.3*current + 0.7*f.rate
+	}
+	f.last, f.lastSent = now, sent
+}
+
+// progress is shared by every transfer of the process
+var progress = &progressRegistry{files: map[uint32]*fileProgress{}}
+
+// begin starts tracking objectId, before each transfer of it
+func (r *progressRegistry) begin(objectId uint32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.files[objectId] = &fileProgress{start: time.Now()}
+}
+
+// update reports that sent of size bytes of a running transfer are done.
+// Updates at 100% are dropped, the end of a transfer is reported through
+// complete. So are updates of objects outside of begin and complete.
+func (r *progressRegistry) update(objectId uint32, name, path string, sent, size int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f, ok := r.files[objectId]
+	if !ok {
+		return canceled()
+	}
+	if percent(sent, size) >= 100 {
+		return checkTransfer(f.start)
+	}
+	f.measure(sent)
+
+	now := time.Now()
+	if *progressInterval > 0 && now.Sub(f.printed) < *progressInterval {
+		return checkTransfer(f.start)
+	}
+	f.printed = now
+	printProgress(objectId, name, path, sent, size, f.rate)
+	return checkTransfer(f.start)
+}
+
+// complete reports 100% and the transfer summary of objectId once and drops
+// its state. Later callbacks for objectId are ignored until the next begin.
+func (r *progressRegistry) complete(objectId uint32, name, source, target string, size int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f, ok := r.files[objectId]
+	if !ok {
+		return
+	}
+	delete(r.files, objectId)
+	transfers.transferred(size)
+	metrics.transferred(size)
+
+	// the final line reports the average speed
+	rate := f.rate
+	if elapsed := time.Since(f.start).Seconds(); elapsed > 0 {
+		rate = float64(size-f.base) / elapsed
+	}
+	printProgress(objectId, name, target, size, size, rate)
+	printTransferSummary(source, target)
+}
+
+// percent returns sent as a percentage of size; an empty transfer is complete
+func percent(sent, size int64) float64 {
+	if size <= 0 {
+		return 100
+	}
+	return float64(sent) / float64(size) * 100
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// TestProgressConcurrent fires the progress callbacks of several transfers
+// from concurrent goroutines and checks that each file reports 100% exactly
+// once and that no line is torn by another.
+func TestProgressConcurrent(t *testing.T) {
+	var buf bytes.Buffer
+	out, registry := progressOut, progress
+	progressOut = &buf
+	progress = &progressRegistry{files: map[uint32]*fileProgress{}}
+	defer func() { progressOut, progress = out, registry }()
+
+	const files, callbacks, size = 8, 4, 1000
+	var wg sync.WaitGroup
+	for id := uint32(1); id <= files; id++ {
+		progress.begin(id)
+		name := fmt.Sprintf("file%d", id)
+		for range callbacks {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for sent := int64(0); sent <= size; sent += 50 {
+					if err := progress.update(id, name, "/"+name, sent, size); err != nil {
+						t.Error(err)
+					}
+				}
+				progress.complete(id, name, name, "/"+name, size)
+			}()
+		}
+	}
+	wg.Wait()
+	if len(progress.files) != 0 {
+		t.Errorf("%d completed files are still tracked", len(progress.files))
+	}
+
+	completed := map[uint32]int{}
+	summaries := 0
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var line struct {
+			ObjectId *uint32 `json:"object_id"`
+			Progress float64 `json:"progress"`
+			Source   string  `json:"source"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("torn progress line %q: %v", scanner.Text(), err)
+		}
+		switch {
+		case line.Source != "":
+			summaries++
+		case line.ObjectId == nil:
+			t.Fatalf("progress line without object_id: %s", scanner.Text())
+		case line.Progress >= 100:
+			completed[*line.ObjectId]++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	for id := uint32(1); id <= files; id++ {
+		if completed[id] != 1 {
+			t.Errorf("object %d reached 100%% %d times, want 1", id, completed[id])
+		}
+	}
+	if summaries != files {
+		t.Errorf("got %d transfer summaries, want %d", summaries, files)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// stdin is shared by every reader of standard input so that buffered input
+// isn't lost between the shell and confirmation prompts
+var stdin = bufio.NewReader(os.Stdin)
+
+// prompter asks for confirmation before destructive operations. Prompts go to
+// stderr so stdout stays machine readable.
+type prompter struct {
+	enabled bool
+	in      *bufio.Reader
+}
+
+// newPrompter returns a prompter that only asks when interactive mode was
+// requested, --yes was not given and stdin is a terminal
+func newPrompter(interactive, yes bool) *prompter {
+	return &prompter{
+		enabled: interactive && !yes && isTerminal(os.Stdin),
+		in:      stdin,
+	}
+}
+
+// confirm asks question and reports whether the user answered yes. It always
+// returns true when prompting is disabled.
+func (p *prompter) confirm(question string) bool {
+	if !p.enabled {
+		return true
+	}
+
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := p.in.ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// deletionSample is how many paths confirmDeletion lists
+const deletionSample = 5
+
+// confirmDeletion asks once before paths are deleted, or trashed as verb
+// says, listing how many files and directories go, the size of the files and
+// the first few paths, so a mistyped pattern is caught before anything is
+// lost. It always returns true when prompting is disabled.
+func (p *prompter) confirmDeletion(verb string, paths []string, size int64, dirs int) bool {
+	if !p.enabled || len(paths) == 0 {
+		return true
+	}
+
+	question := fmt.Sprintf("%s %d files (%s)", verb, len(paths)-dirs, humanReadableSize(size))
+	if dirs > 0 {
+		question += fmt.Sprintf(" and %d directories with everything in them", dirs)
+	}
+	for _, path := range paths[:min(len(paths), deletionSample)] {
+		fmt.Fprintf(os.Stderr, "  %s\n", path)
+	}
+	if len(paths) > deletionSample {
+		fmt.Fprintf(os.Stderr, "  ... and %d more\n", len(paths)-deletionSample)
+	}
+	return p.confirm(question + "?")
+}
+
+// printDeclined reports that a confirmation for count paths was declined and
+// nothing was deleted
+func printDeclined(count int) error {
+	return printJSON(map[string]interface{}{
+		"skipped": true,
+		"reason":  "declined",
+		"count":   count,
+	})
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ganeshrvel/go-mtpfs/mtp"
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// propListAll asks GetObjPropList for every property of the objects
+const propListAll = 0xFFFFFFFF
+
+// listedObject is an object assembled from the properties of a
+// GetObjPropList reply
+type listedObject struct {
+	id   uint32
+	info mtp.ObjectInfo
+	size int64
+}
+
+// propList returns the objects directly below dir from one GetObjPropList
+// request, where listing them with GetObjectInfo takes a request per object
+// and another one per file over 4 GiB. For a directory of 2000 songs that is
+// one request instead of 2001. ok is false when the device doesn't list by
+// depth or sent a reply that can't be read, after which it isn't asked again
+// and callers list the directory the usual way.
+func (c *CLI) propList(dir *mtpx.FileInfo) (list []*mtpx.FileInfo, ok bool) {
+	if c.noPropList {
+		return nil, false
+	}
+
+	// objects in the storage root have parent 0, which lists the root of
+	// every storage
+	parent := dir.ObjectId
+	if parent == mtpx.ParentObjectId {
+		parent = 0
+	}
+
+	req := mtp.Container{
+		Code:  mtp.OC_MTP_GetObjPropList,
+		Param: []uint32{parent, 0, propListAll, 0, 1},
+	}
+	var rep mtp.Container
+	var buf bytes.Buffer
+	err := c.device.RunTransaction(&req, &rep, &buf, nil, 0, mtp.EmptyProgressFunc)
+	if errors.As(err, new(mtp.RCError)) {
+		c.noPropList = true
+		return nil, false
+	}
+	if err != nil {
+		return nil, false
+	}
+
+	// devices that leave out names send lists that don't describe the
+	// objects fully either
+	objects, err := parsePropList(buf.Bytes())
+	for _, o := range objects {
+		if o.info.Filename == "" && o.id != parent {
+			err = fmt.Errorf("object %d has no name", o.id)
+		}
+	}
+	if err != nil {
+		c.noPropList = true
+		return nil, false
+	}
+
+	parentPath := path.Clean("/" + dir.FullPath)
+	for _, o := range objects {
+		if o.id == parent || parent == 0 && o.info.StorageID != c.storage {
+			continue
+		}
+		list = append(list, o.fileInfo(parentPath))
+	}
+	return list, true
+}
+
+// parsePropList reads a GetObjPropList dataset: a count, then that many
+// handle, property code, data type and value quadruples. Objects are returned
+// in the order they first appear.
+func parsePropList(data []byte) ([]*listedObject, error) {
+	r := bytes.NewReader(data)
+	var n uint32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return nil, err
+	}
+
+	var objects []*listedObject
+	byId := map[uint32]*listedObject{}
+	for i := uint32(0); i < n; i++ {
+		var head struct {
+			Handle   uint32
+			Code     uint16
+			DataType uint16
+		}
+		if err := binary.Read(r, binary.LittleEndian, &head); err != nil {
+			return nil, err
+		}
+		v, err := readPropValue(r, mtp.DataTypeSelector(head.DataType))
+		if err != nil {
+			return nil, fmt.Errorf("property %s of object %d: %w", propName(head.Code), head.Handle, err)
+		}
+
+		o, ok := byId[head.Handle]
+		if !ok {
+			o = &listedObject{id: head.Handle}
+			byId[head.Handle] = o
+			objects = append(objects, o)
+		}
+		o.set(head.Code, v)
+	}
+	return objects, nil
+}
+
+// set fills in the ObjectInfo field a property corresponds to
+func (o *listedObject) set(code uint16, v interface{}) {
+	s, _ := v.(string)
+	switch code {
+	case mtp.OPC_StorageID:
+		o.info.StorageID = uint32(propUint(v))
+	case mtp.OPC_ObjectFormat:
+		o.info.ObjectFormat = uint16(propUint(v))
+	case mtp.OPC_ProtectionStatus:
+		o.info.ProtectionStatus = uint16(propUint(v))
+	case mtp.OPC_ObjectSize:
+		o.size = int64(propUint(v))
+		o.info.CompressedSize = uint32(min(propUint(v), 0xFFFFFFFF))
+	case mtp.OPC_AssociationType:
+		o.info.AssociationType = uint16(propUint(v))
+	case mtp.OPC_AssociationDesc:
+		o.info.AssociationDesc = uint32(propUint(v))
+	case mtp.OPC_ObjectFileName:
+		o.info.Filename = s
+	case mtp.OPC_DateCreated:
+		o.info.CaptureDate = parseMTPTime(s)
+	case mtp.OPC_DateModified:
+		o.info.ModificationDate = parseMTPTime(s)
+	case mtp.OPC_ParentObject:
+		o.info.ParentObject = uint32(propUint(v))
+	}
+}
+
+// fileInfo returns o as go-mtpx describes objects below parentPath
+func (o *listedObject) fileInfo(parentPath string) *mtpx.FileInfo {
+	isDir := o.info.ObjectFormat == mtp.OFC_Association
+	size := o.size
+	if isDir {
+		size = 0
+	}
+	info := o.info
+	return &mtpx.FileInfo{
+		Info:       &info,
+		Size:       size,
+		IsDir:      isDir,
+		ModTime:    info.ModificationDate,
+		Name:       info.Filename,
+		FullPath:   path.Join(parentPath, info.Filename),
+		ParentPath: parentPath,
+		Extension:  objectExtension(info.Filename, isDir),
+		ParentId:   info.ParentObject,
+		ObjectId:   o.id,
+	}
+}
+
+// propUint returns an unsigned integer property of any width, devices don't
+// agree on the widths of all of them
+func propUint(v interface{}) uint64 {
+	switch n := v.(type) {
+	case uint8:
+		return uint64(n)
+	case uint16:
+		return uint64(n)
+	case uint32:
+		return uint64(n)
+	case uint64:
+		return n
+	}
+	return 0
+}
+
+// parseMTPTime parses an MTP date the way go-mtpfs does for object infos,
+// with the trailing dots and Z some devices add. Invalid dates are zero.
+func parseMTPTime(s string) time.Time {
+	s = strings.TrimRight(strings.TrimRight(s, "."), "Z")
+	for _, layout := range []string{"20060102T150405", "20060102T150405-0700"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// objectExtension is the extension go-mtpx reports for a file name, which
+// keeps the second extension of .tar archives
+func objectExtension(name string, isDir bool) string {
+	if isDir {
+		return ""
+	}
+	parts := strings.Split(name, ".")
+	if len(parts) > 2 && parts[len(parts)-2] == "tar" {
+		return strings.Join(parts[len(parts)-2:], ".")
+	}
+	if len(parts) > 1 {
+		return parts[len(parts)-1]
+	}
+	return ""
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ganeshrvel/go-mtpfs/mtp"
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// handleProp dispatches prop get, the same as getprop, and prop set
+func (c *CLI) handleProp(args []string) error {
+	if len(args) < 1 {
+		return usagef("prop requires get or set")
+	}
+	switch args[0] {
+	case "get":
+		return c.handleGetProp(args[1:])
+	case "set":
+		return c.handleSetProp(args[1:])
+	}
+	return usagef("unknown prop action %q: must be get or set", args[0])
+}
+
+func (c *CLI) handleGetProp(args []string) error {
+	if len(args) < 1 {
+		return usagef("getprop requires a remote path")
+	}
+
+	results, err := c.fileExists([]mtpx.FileProp{{FullPath: remotePath(args[0])}})
+	if err != nil {
+		return err
+	}
+	if len(results) == 0 || !results[0].Exists {
+		return notFoundf("file not found: %s", args[0])
+	}
+	fi := results[0].FileInfo
+
+	var codes []uint16
+	for _, name := range args[1:] {
+		code, err := parsePropCode(name)
+		if err != nil {
+			return err
+		}
+		codes = append(codes, code)
+	}
+
+	// without explicit properties dump everything the device supports for this format
+	if len(codes) == 0 {
+		var supported mtp.Uint16Array
+		if err := c.device.GetObjectPropsSupported(fi.Info.ObjectFormat, &supported); err != nil {
+			return fmt.Errorf("failed to fetch supported properties: %w", err)
+		}
+		codes = supported.Values
+	}
+
+	props := map[string]interface{}{}
+	errs := map[string]string{}
+	for _, code := range codes {
+		value, err := c.objectPropValue(fi.ObjectId, fi.Info.ObjectFormat, code)
+		if err != nil {
+			errs[propName(code)] = err.Error()
+			continue
+		}
+		props[propName(code)] = value
+	}
+
+	result := map[string]interface{}{
+		"path":       fi.FullPath,
+		"object_id":  fi.ObjectId,
+		"properties": props,
+	}
+	if len(errs) > 0 {
+		result["errors"] = errs
+	}
+	printJSON(result)
+
+	printDone("MTPX_GETPROP_DONE")
+	return nil
+}
+
+func (c *CLI) handleSetProp(args []string) error {
+	if len(args) < 3 {
+		return usagef("prop set requires a remote path, a property and a value")
+	}
+
+	results, err := c.fileExists([]mtpx.FileProp{{FullPath: remotePath(args[0])}})
+	if err != nil {
+		return err
+	}
+	if len(results) == 0 || !results[0].Exists {
+		return notFoundf("file not found: %s", args[0])
+	}
+	fi := results[0].FileInfo
+
+	code, err := parsePropCode(args[1])
+	if err != nil {
+		return err
+	}
+	if code == mtp.OPC_ObjectFileName && (args[2] == "" || strings.Contains(args[2], "/")) {
+		return usagef("invalid name %q", args[2])
+	}
+
+	var desc mtp.ObjectPropDesc
+	if err := c.device.GetObjectPropDesc(code, fi.Info.ObjectFormat, &desc); err != nil {
+		return fmt.Errorf("%s is not supported for %s: %w", propName(code), fi.FullPath, err)
+	}
+	if desc.GetSet != mtp.DPGS_GetSet {
+		return fmt.Errorf("%s is read-only on this device", propName(code))
+	}
+	raw, err := encodePropValue(desc.DataType, code, args[2])
+	if err != nil {
+		return usagef("invalid value for %s: %v", propName(code), err)
+	}
+
+	old, err := c.objectPropValue(fi.ObjectId, fi.Info.ObjectFormat, code)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", propName(code), err)
+	}
+	value, _ := decodePropValue(desc.DataType, raw)
+
+	result := map[string]interface{}{
+		"path":      fi.FullPath,
+		"object_id": fi.ObjectId,
+		"property":  propName(code),
+		"old":       old,
+		"value":     value,
+	}
+	if *dryRun {
+		return printPlanned("setprop", result)
+	}
+
+	req := mtp.Container{
+		Code:  mtp.OC_MTP_SetObjectPropValue,
+		Param: []uint32{fi.ObjectId, uint32(code)},
+	}
+	var rep mtp.Container
+	if err := c.device.RunTransaction(&req, &rep, nil, bytes.NewReader(raw), int64(len(raw)), mtp.EmptyProgressFunc); err != nil {
+		return fmt.Errorf("failed to set %s: %w", propName(code), err)
+	}
+	printJSON(result)
+
+	printDone("MTPX_SETPROP_DONE")
+	return nil
+}
+
+// objectPropValue reads an object property and decodes it according to the
+// data type the device declares for it
+func (c *CLI) objectPropValue(objectId uint32, format, code uint16) (interface{}, error) {
+	var desc mtp.ObjectPropDesc
+	if err := c.device.GetObjectPropDesc(code, format, &desc); err != nil {
+		return nil, err
+	}
+
+	req := mtp.Container{
+		Code:  mtp.OC_MTP_GetObjectPropValue,
+		Param: []uint32{objectId, uint32(code)},
+	}
+	var rep mtp.Container
+	var buf bytes.Buffer
+	if err := c.device.RunTransaction(&req, &rep, &buf, nil, 0, mtp.EmptyProgressFunc); err != nil {
+		return nil, err
+	}
+
+	return decodePropValue(desc.DataType, buf.Bytes())
+}
+
+// decodePropValue converts raw MTP property data into a JSON friendly value.
+// 128 bit integers are returned as hex strings.
+func decodePropValue(dataType mtp.DataTypeSelector, raw []byte) (interface{}, error) {
+	return readPropValue(bytes.NewReader(raw), dataType)
+}
+
+// readPropValue reads one property value of dataType from r
+func readPropValue(r *bytes.Reader, dataType mtp.DataTypeSelector) (interface{}, error) {
+	if dataType == mtp.DTC_STR {
+		var s mtp.StringValue
+		if err := mtp.Decode(r, &s); err != nil {
+			return nil, err
+		}
+		return s.Value, nil
+	}
+
+	if dataType&mtp.DTC_ARRAY_MASK != 0 {
+		var n uint32
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return nil, err
+		}
+		values := []interface{}{}
+		for i := uint32(0); i < n; i++ {
+			v, err := readScalar(r, dataType&^mtp.DTC_ARRAY_MASK)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	}
+
+	return readScalar(r, dataType)
+}
+
+// encodePropValue converts a command line value into raw MTP property data of
+// dataType. Date properties also take RFC 3339 times and plain dates, which
+// are sent in the MTP form 20060102T150405.
+func encodePropValue(dataType mtp.DataTypeSelector, code uint16, s string) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if dataType == mtp.DTC_STR {
+		if isDateProp(code) {
+			s = mtpDate(s)
+		}
+		if err := mtp.Encode(&buf, &mtp.StringValue{Value: s}); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	v, err := parseScalar(dataType, s)
+	if err != nil {
+		return nil, err
+	}
+	if err := binary.Write(&buf, binary.LittleEndian, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// parseScalar parses an integer of dataType, in decimal or 0x hex
+func parseScalar(dataType mtp.DataTypeSelector, s string) (interface{}, error) {
+	switch dataType {
+	case mtp.DTC_INT8:
+		n, err := strconv.ParseInt(s, 0, 8)
+		return int8(n), err
+	case mtp.DTC_UINT8:
+		n, err := strconv.ParseUint(s, 0, 8)
+		return uint8(n), err
+	case mtp.DTC_INT16:
+		n, err := strconv.ParseInt(s, 0, 16)
+		return int16(n), err
+	case mtp.DTC_UINT16:
+		n, err := strconv.ParseUint(s, 0, 16)
+		return uint16(n), err
+	case mtp.DTC_INT32:
+		n, err := strconv.ParseInt(s, 0, 32)
+		return int32(n), err
+	case mtp.DTC_UINT32:
+		n, err := strconv.ParseUint(s, 0, 32)
+		return uint32(n), err
+	case mtp.DTC_INT64:
+		return strconv.ParseInt(s, 0, 64)
+	case mtp.DTC_UINT64:
+		return strconv.ParseUint(s, 0, 64)
+	}
+	return nil, fmt.Errorf("setting data type 0x%04x is not supported", uint16(dataType))
+}
+
+func isDateProp(code uint16) bool {
+	return code == mtp.OPC_DateModified || code == mtp.OPC_DateCreated || code == mtp.OPC_DateAdded
+}
+
+// mtpDate rewrites RFC 3339 times and plain dates into the MTP date form and
+// leaves anything else, such as a value already in that form, alone
+func mtpDate(s string) string {
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t.Format("20060102T150405")
+		}
+	}
+	return s
+}
+
+func readScalar(r *bytes.Reader, dataType mtp.DataTypeSelector) (interface{}, error) {
+	var v interface{}
+	switch dataType {
+	case mtp.DTC_INT8:
+		v = new(int8)
+	case mtp.DTC_UINT8:
+		v = new(uint8)
+	case mtp.DTC_INT16:
+		v = new(int16)
+	case mtp.DTC_UINT16:
+		v = new(uint16)
+	case mtp.DTC_INT32:
+		v = new(int32)
+	case mtp.DTC_UINT32:
+		v = new(uint32)
+	case mtp.DTC_INT64:
+		v = new(int64)
+	case mtp.DTC_UINT64:
+		v = new(uint64)
+	case mtp.DTC_INT128, mtp.DTC_UINT128:
+		b := make([]byte, 16)
+		if _, err := r.Read(b); err != nil {
+			return nil, err
+		}
+		return hex.EncodeToString(b), nil
+	default:
+		return nil, fmt.Errorf("unsupported data type 0x%04x", uint16(dataType))
+	}
+
+	if err := binary.Read(r, binary.LittleEndian, v); err != nil {
+		return nil, err
+	}
+
+	return reflect.ValueOf(v).Elem().Interface(), nil
+}
+
+// propAliases are short names for the properties most worth fixing by hand
+var propAliases = map[string]uint16{
+	"name":          mtp.OPC_ObjectFileName,
+	"date_modified": mtp.OPC_DateModified,
+	"date_created":  mtp.OPC_DateCreated,
+	"protection":    mtp.OPC_ProtectionStatus,
+	"association":   mtp.OPC_AssociationType,
+}
+
+// parsePropCode accepts an object property name such as "ObjectFileName"
+// (case-insensitive), an alias from propAliases or a hex code such as "0xDC07"
+func parsePropCode(s string) (uint16, error) {
+	if code, ok := propAliases[strings.ToLower(s)]; ok {
+		return code, nil
+	}
+	for code, name := range mtp.OPC_names {
+		if strings.EqualFold(name, s) {
+			return uint16(code), nil
+		}
+	}
+
+	hexCode := strings.TrimPrefix(strings.ToLower(s), "0x")
+	code, err := strconv.ParseUint(hexCode, 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("unknown object property: %s", s)
+	}
+	return uint16(code), nil
+}
+
+func propName(code uint16) string {
+	if name, ok := mtp.OPC_names[int(code)]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04X", code)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+// spoolMemory is how much of stdin put-stdin keeps in memory before it
+// spools to a temporary file to learn the size
+const spoolMemory = 8 * 1024 * 1024
+
+// handlePutStdin uploads stdin as the remote file remote_path. MTP needs the
+// object size before the data, so without --size stdin is read to the end
+// first, in memory while it is small and in a temporary file after that.
+func (c *CLI) handlePutStdin(args []string) error {
+	fs := flag.NewFlagSet("put-stdin", flag.ContinueOnError)
+	size := fs.Int64("size", -1, "number of bytes stdin will deliver; streams them without spooling")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) < 1 {
+		return usagef("put-stdin requires a remote file path")
+	}
+	remote, err := c.resolveRemote(args[0])
+	if err != nil {
+		return err
+	}
+	dir, name := path.Split(remote)
+	if name == "" {
+		return usagef("put-stdin requires a remote file path, not a directory: %s", remote)
+	}
+
+	u := &uploader{cli: c, limiter: newRateLimiter(0)}
+	if *size >= 0 {
+		r := &io.LimitedReader{R: stdin, N: *size}
+		if err := u.uploadStream(r, *size, "-", name, dir, time.Now()); err != nil {
+			return transferFailed(err)
+		}
+		if r.N > 0 {
+			return transferFailed(fmt.Errorf("stdin ended %d bytes short of --size %d", r.N, *size))
+		}
+		if n, _ := stdin.Read(make([]byte, 1)); n > 0 {
+			return fmt.Errorf("stdin has more than --size %d bytes, the rest was not uploaded", *size)
+		}
+	} else {
+		r, n, cleanup, err := spoolStdin()
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		*size = n
+		if err := u.uploadStream(r, n, "-", name, dir, time.Now()); err != nil {
+			return transferFailed(err)
+		}
+	}
+
+	fileCompleted(directionUpload, "-", remote, *size)
+	printJSON(map[string]interface{}{
+		"path": remote,
+		"size": *size,
+	})
+	printDone("MTPX_PUT_STDIN_DONE")
+	return nil
+}
+
+// spoolStdin reads stdin to the end and returns a reader over the data, its
+// size and a function that removes the temporary file, if one was needed
+func spoolStdin() (io.Reader, int64, func(), error) {
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, stdin, spoolMemory+1)
+	if err == io.EOF {
+		return &buf, n, func() {}, nil
+	}
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("failed to read stdin: %w", err)
+	}
+
+	f, err := os.CreateTemp("", "mtpx-stdin-*")
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("failed to spool stdin: %w", err)
+	}
+	cleanup := func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+
+	if _, err := io.Copy(f, io.MultiReader(&buf, stdin)); err != nil {
+		cleanup()
+		return nil, 0, nil, fmt.Errorf("failed to spool stdin: %w", err)
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		cleanup()
+		return nil, 0, nil, err
+	}
+	return f, size, cleanup, nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+)
+
+// transferQueue runs the file transfers of a command on a pool of workers.
+// An MTP session carries one transaction at a time, so workers take turns on
+// the device through withDevice. What they overlap is the local side of each
+// transfer, creating and closing files, setting times and hashing for
+// --verify, and the walk that feeds the queue. With a single worker jobs run
+// inline as they are added, in the order they are added.
+type transferQueue struct {
+	cli     *CLI
+	workers int
+
+	// report prints aggregate progress after each finished job
+	report bool
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	jobs   []transferJob
+	closed bool
+	wg     sync.WaitGroup
+
+	// err is the first failure, after which no more jobs are started
+	err error
+
+	// producing is set while produce holds the device for the walk
+	producing bool
+
+	// fixedTotal keeps the totals from setTotal instead of counting jobs
+	fixedTotal                                   bool
+	filesDone, filesTotal, bytesDone, bytesTotal int64
+}
+
+type transferJob struct {
+	size int64
+	run  func() error
+}
+
+func validateConcurrency(n int) error {
+	if n < 1 {
+		return usagef("invalid --concurrency %d: must be at least 1", n)
+	}
+	return nil
+}
+
+// newTransferQueue starts workers, report enables aggregate progress lines.
+// Every queue must be finished with wait.
+func newTransferQueue(c *CLI, workers int, report bool) *transferQueue {
+	q := &transferQueue{cli: c, workers: max(workers, 1), report: report && !*dryRun}
+	q.cond = sync.NewCond(&q.mu)
+	if q.workers > 1 {
+		for i := 1; i <= q.workers; i++ {
+			q.wg.Add(1)
+			go q.work(i)
+		}
+	}
+	return q
+}
+
+// setTotal sets the totals of the aggregate progress when they are known up
+// front
+func (q *transferQueue) setTotal(files, bytes int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.fixedTotal = true
+	q.filesTotal, q.bytesTotal = files, bytes
+}
+
+// add queues run, a transfer of size bytes. It returns the error of a failed
+// job, or errCanceled after an interrupt, after which callers stop adding.
+func (q *transferQueue) add(size int64, run func() error) error {
+	q.mu.Lock()
+	if q.err == nil {
+		q.err = canceled()
+	}
+	if q.err != nil {
+		defer q.mu.Unlock()
+		return q.err
+	}
+	if !q.fixedTotal {
+		q.filesTotal++
+		q.bytesTotal += size
+	}
+
+	if q.workers == 1 {
+		q.mu.Unlock()
+		q.finish(0, size, run())
+		return q.failed()
+	}
+
+	q.jobs = append(q.jobs, transferJob{size: size, run: run})
+	q.cond.Signal()
+	producing := q.producing
+	q.mu.Unlock()
+
+	// lend the device to a waiting worker so transfers start during the walk
+	if producing {
+		q.cli.deviceMu.Unlock()
+		runtime.Gosched()
+		q.cli.deviceMu.Lock()
+	}
+	return nil
+}
+
+// produce runs fn, which walks the device and adds jobs. With workers, fn
+// holds the device except for the moments add lends it out.
+func (q *transferQueue) produce(fn func() error) error {
+	if q.workers == 1 {
+		return fn()
+	}
+
+	q.cli.deviceMu.Lock()
+	defer q.cli.deviceMu.Unlock()
+
+	q.mu.Lock()
+	q.producing = true
+	q.mu.Unlock()
+	defer func() {
+		q.mu.Lock()
+		q.producing = false
+		q.mu.Unlock()
+	}()
+
+	return fn()
+}
+
+func (q *transferQueue) work(worker int) {
+	defer q.wg.Done()
+	for {
+		q.mu.Lock()
+		for len(q.jobs) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if len(q.jobs) == 0 {
+			q.mu.Unlock()
+			return
+		}
+		job := q.jobs[0]
+		q.jobs = q.jobs[1:]
+		if q.err == nil {
+			q.err = canceled()
+		}
+		failed := q.err != nil
+		q.mu.Unlock()
+
+		if !failed {
+			q.finish(worker, job.size, job.run())
+		}
+	}
+}
+
+// finish records the outcome of a job run by worker, 0 for inline jobs
+func (q *transferQueue) finish(worker int, size int64, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err != nil {
+		if q.err == nil {
+			q.err = err
+		}
+		return
+	}
+
+	q.filesDone++
+	q.bytesDone += size
+	if q.report {
+		printTreeProgress(worker, q.filesDone, q.filesTotal, q.bytesDone, q.bytesTotal)
+	}
+}
+
+func (q *transferQueue) failed() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.err
+}
+
+// wait lets the workers finish the queued jobs and returns the first error
+func (q *transferQueue) wait() error {
+	q.mu.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+
+	q.wg.Wait()
+	return q.failed()
+}
+
+// done returns the number of finished jobs and their bytes
+func (q *transferQueue) done() (files, bytes int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.filesDone, q.bytesDone
+}
+
+// withDevice runs fn with exclusive use of the device, for transfers that
+// may run on several workers
+func (c *CLI) withDevice(fn func() error) error {
+	c.deviceMu.Lock()
+	defer c.deviceMu.Unlock()
+	return fn()
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ganeshrvel/go-mtpfs/mtp"
+	mtpx "github.com/ganeshrvel/go-mtpx"
+	"github.com/ganeshrvel/usb"
+)
+
+func (c *CLI) handleReconnect(args []string) error {
+	start := time.Now()
+	if err := c.reconnect(); err != nil {
+		printJSON(map[string]interface{}{
+			"reconnected": false,
+			"error":       err.Error(),
+		})
+		return err
+	}
+
+	printJSON(map[string]interface{}{
+		"reconnected": true,
+		"storage":     c.storage,
+		"elapsed_ms":  time.Since(start).Milliseconds(),
+	})
+
+	printDone("MTPX_RECONNECT_DONE")
+	return nil
+}
+
+// reconnectGrace is how long reconnect waits for the device at least. Phones
+// often re-enumerate for a moment, for example when the screen is unlocked.
+const reconnectGrace = 5 * time.Second
+
+// devicePoll is the interval in which a missing device is looked for again
+const devicePoll = time.Second
+
+// reconnect disposes the current device and opens it again, re-resolving the
+// storage the same way newCLI does
+func (c *CLI) reconnect() error {
+	mtpx.Dispose(c.device)
+
+	dev, storage, err := openDeviceWait(max(*waitFor, reconnectGrace))
+	if err != nil {
+		return err
+	}
+
+	c.device = dev
+	c.storage = storage
+	return nil
+}
+
+// sessionAlive probes the device with a cheap request. Ordinary failures such
+// as a missing file leave the session usable, so callers probe after an error
+// instead of guessing from the error itself, which go-mtpx wraps without a way
+// to unwrap it.
+func (c *CLI) sessionAlive() bool {
+	var sids mtp.Uint32Array
+	err := c.device.GetStorageIDs(&sids)
+	return err == nil || !isDeadSession(err)
+}
+
+// isDeadSession reports whether err means the session can't be used anymore.
+// USB errors make go-mtpfs close the device, after which every request fails
+// with a plain error; a device reply is only fatal when the session is gone.
+func isDeadSession(err error) bool {
+	switch e := err.(type) {
+	case nil:
+		return false
+	case usb.Error:
+		return true
+	case mtp.RCError:
+		return e == mtp.RC_SessionNotOpen
+	}
+	return true
+}
+
+// reconnectIfDead reconnects when the session died and reports the outcome as
+// an event line for long-running commands
+func (c *CLI) reconnectIfDead() {
+	if c.sessionAlive() {
+		return
+	}
+
+	if err := c.reconnect(); err != nil {
+		printJSON(map[string]string{
+			"event": "reconnect_failed",
+			"error": err.Error(),
+		})
+		return
+	}
+	printJSON(map[string]interface{}{
+		"event":   "reconnected",
+		"storage": c.storage,
+	})
+}
+
+// openDeviceWait calls openDevice until it succeeds or wait has passed. The
+// first failure is reported as an event line so callers know why nothing
+// happens yet.
+func openDeviceWait(wait time.Duration) (*mtp.Device, uint32, error) {
+	deadline := time.Now().Add(wait)
+	for attempt := 0; ; attempt++ {
+		dev, storage, err := openDevice()
+		if err == nil {
+			return dev, storage, nil
+		}
+		if !time.Now().Before(deadline) {
+			if wait > 0 {
+				err = fmt.Errorf("%w (waited %s)", err, wait)
+			}
+			return nil, 0, err
+		}
+
+		if attempt == 0 {
+			printJSON(map[string]string{
+				"event":   "waiting_for_device",
+				"error":   err.Error(),
+				"timeout": wait.String(),
+			})
+		}
+		time.Sleep(devicePoll)
+	}
+}
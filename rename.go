@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// renameToken matches the variables of --rename-template with their optional
+// argument, such as {date:2006-01-02} or {counter:4}
+var renameToken = regexp.MustCompile(`\{([a-z]+)(?::([^}]*))?\}`)
+
+// defaultDateLayout formats {date} and {mtime} without a layout
+const defaultDateLayout = "2006-01-02"
+
+// renameTemplate names downloaded files after --rename-template
+type renameTemplate struct {
+	template string
+
+	// usesDate is set when the template needs the capture date, which costs
+	// a read of the file's EXIF data
+	usesDate bool
+
+	// counter numbers the files named so far and claimed holds the local
+	// paths given out, guarded by mu
+	mu      sync.Mutex
+	counter int
+	claimed map[string]bool
+}
+
+// parseRenameTemplate checks the variables of a --rename-template, nil for an
+// empty one. Templates name files, so they can't hold a path separator.
+func parseRenameTemplate(template string) (*renameTemplate, error) {
+	if template == "" {
+		return nil, nil
+	}
+	if strings.ContainsAny(template, `/\`) {
+		return nil, usagef("invalid --rename-template %q: must not contain / or \\", template)
+	}
+
+	t := &renameTemplate{template: template, claimed: map[string]bool{}}
+	for _, m := range renameToken.FindAllStringSubmatch(template, -1) {
+		switch m[1] {
+		case "name", "base", "ext":
+			if m[2] != "" {
+				return nil, usagef("invalid --rename-template variable %s: {%s} takes no argument", m[0], m[1])
+			}
+		case "counter":
+			if n, err := strconv.Atoi(m[2]); m[2] != "" && (err != nil || n < 1 || n > 12) {
+				return nil, usagef("invalid --rename-template variable %s: the width must be 1 to 12", m[0])
+			}
+		case "date":
+			t.usesDate = true
+		case "mtime":
+		default:
+			return nil, usagef("invalid --rename-template variable %s: must be {name}, {base}, {ext}, {date}, {mtime} or {counter}", m[0])
+		}
+	}
+	return t, nil
+}
+
+// name expands the template for fi, whose capture date is taken
+func (t *renameTemplate) name(fi *mtpx.FileInfo, taken time.Time) string {
+	t.mu.Lock()
+	t.counter++
+	n := t.counter
+	t.mu.Unlock()
+
+	ext := path.Ext(fi.Name)
+	return renameToken.ReplaceAllStringFunc(t.template, func(tok string) string {
+		m := renameToken.FindStringSubmatch(tok)
+		switch m[1] {
+		case "name":
+			return fi.Name
+		case "base":
+			return strings.TrimSuffix(fi.Name, ext)
+		case "ext":
+			return ext
+		case "counter":
+			width, _ := strconv.Atoi(m[2])
+			return fmt.Sprintf("%0*d", width, n)
+		case "date":
+			return taken.Format(dateLayout(m[2]))
+		case "mtime":
+			return fi.ModTime.Format(dateLayout(m[2]))
+		}
+		return tok
+	})
+}
+
+// claim returns name, or the first conflict name for it that no other file of
+// the run was given in dir, since a template without {name} or {counter} can
+// name several files alike
+func (t *renameTemplate) claim(dir, name string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	candidate := name
+	for n := 1; t.claimed[filepath.Join(dir, candidate)]; n++ {
+		candidate = conflictName(name, n)
+	}
+	t.claimed[filepath.Join(dir, candidate)] = true
+	return candidate
+}
+
+// dateLayout is the time layout of a date variable, the default without one
+func dateLayout(layout string) string {
+	if layout == "" {
+		return defaultDateLayout
+	}
+	return layout
+}
+
+// filePath returns the local path of the remote file fi in dir: its own
+// name, or with --rename-template the expanded template, made safe for the
+// local filesystem either way. The capture date is read from the device only
+// when the template needs it.
+func (d *downloader) filePath(fi *mtpx.FileInfo, dir string) string {
+	taken := fi.ModTime
+	if d.rename != nil && d.rename.usesDate {
+		if t, ok := d.cli.remoteExifDate(fi); ok {
+			taken = t
+		}
+	}
+	return d.renamedPath(fi, dir, taken)
+}
+
+// renamedPath is filePath for a file whose capture date is known
+func (d *downloader) renamedPath(fi *mtpx.FileInfo, dir string, taken time.Time) string {
+	if d.rename == nil {
+		return filepath.Join(dir, d.localName(fi.Name))
+	}
+	return filepath.Join(dir, d.rename.claim(dir, d.localName(d.rename.name(fi, taken))))
+}
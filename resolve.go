@@ -0,0 +1,275 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/ganeshrvel/go-mtpfs/mtp"
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// disallowedFiles are the names go-mtpx leaves out of walks that skip
+// disallowed files
+var disallowedFiles = map[string]bool{
+	".DS_Store":                        true,
+	"[-----DS_Store.mtp.test----].txt": true,
+}
+
+// objectIdPrefix marks a remote argument that names an object by its id, as
+// printed in object_id fields, instead of by path
+const objectIdPrefix = "id:"
+
+// parseObjectId returns the object id of an id:N argument
+func parseObjectId(arg string) (uint32, bool) {
+	if !strings.HasPrefix(arg, objectIdPrefix) {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(arg, objectIdPrefix), 0, 32)
+	return uint32(id), err == nil
+}
+
+// resolveObjectIds replaces the id:N arguments of a command by the paths of
+// their objects and remembers the ids for objectFromPath, fileExists and
+// walk. The path is found by walking up the parents, one request per level,
+// instead of listing every directory on the way down. The storage switches to
+// the one holding the objects.
+func (c *CLI) resolveObjectIds(args []string) ([]string, error) {
+	c.ids = map[string]uint32{}
+	resolved := make([]string, len(args))
+	var storage uint32
+	for i, arg := range args {
+		resolved[i] = arg
+		id, ok := parseObjectId(arg)
+		if !ok {
+			continue
+		}
+
+		var info mtp.ObjectInfo
+		if err := c.device.GetObjectInfo(id, &info); err != nil {
+			// a device that answers refuses the handle, other errors
+			// are the session's
+			if errors.As(err, new(mtp.RCError)) {
+				return nil, notFoundError{fmt.Errorf("object %d not found: %w", id, err)}
+			}
+			return nil, fmt.Errorf("object %d not found: %w", id, err)
+		}
+		if storage != 0 && info.StorageID != storage {
+			return nil, usagef("%s is on another storage than the other %sN arguments", arg, objectIdPrefix)
+		}
+		storage = info.StorageID
+
+		p, err := objectPath(c.device, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve object %d: %w", id, err)
+		}
+		c.ids[p] = id
+		resolved[i] = p
+	}
+	if storage != 0 {
+		c.storage = storage
+	}
+	return resolved, nil
+}
+
+// objectFromPath is mtpx.GetObjectFromPath, by id for paths given as id:N.
+// Paths in the object cache are served from it, and where the device lists
+// directories with GetObjPropList a path takes one request per segment. Paths
+// that aren't found that way are left to go-mtpx to report.
+func (c *CLI) objectFromPath(p string) (*mtpx.FileInfo, error) {
+	if o := c.cachedTree().lookup(p); o != nil {
+		return o.fileInfo(c.storage), nil
+	}
+	if id, ok := c.ids[p]; ok {
+		return mtpx.GetObjectFromObjectId(c.device, id, path.Dir(p))
+	}
+	if fi, ok := c.lookupByPropList(p); ok && fi != nil {
+		return fi, nil
+	}
+	return mtpx.GetObjectFromPath(c.device, c.storage, p)
+}
+
+// fileExists is mtpx.FileExists, by id for paths given as id:N. go-mtpx
+// takes the path of an object given by id as its parent path. When every
+// path can be answered from the object cache or with GetObjPropList, go-mtpx
+// isn't asked.
+func (c *CLI) fileExists(props []mtpx.FileProp) ([]mtpx.FileExistsContainer, error) {
+	results := make([]mtpx.FileExistsContainer, 0, len(props))
+	for _, prop := range props {
+		var fi *mtpx.FileInfo
+		known := false
+		if o := c.cachedTree().lookup(prop.FullPath); o != nil && prop.ObjectId == 0 {
+			fi, known = o.fileInfo(c.storage), true
+		} else if o := c.cachedTree().object(prop.ObjectId); o != nil {
+			fi, known = o.fileInfo(c.storage), true
+		} else if _, byId := c.ids[prop.FullPath]; prop.ObjectId == 0 && !byId {
+			fi, known = c.lookupByPropList(prop.FullPath)
+		}
+		if !known {
+			break
+		}
+		results = append(results, mtpx.FileExistsContainer{Exists: fi != nil, FileInfo: fi})
+	}
+	if len(results) == len(props) {
+		return results, nil
+	}
+
+	lookup := make([]mtpx.FileProp, len(props))
+	for i, prop := range props {
+		lookup[i] = prop
+		if id, ok := c.ids[prop.FullPath]; ok && prop.ObjectId == 0 {
+			lookup[i] = mtpx.FileProp{ObjectId: id, FullPath: path.Dir(prop.FullPath)}
+		}
+	}
+	return mtpx.FileExists(c.device, c.storage, lookup)
+}
+
+// lookupByPropList resolves p one segment at a time with propList, matching
+// names case-insensitively and taking the first match like go-mtpx does. fi
+// is nil for a path that doesn't exist, ok is false when the device doesn't
+// list with GetObjPropList.
+func (c *CLI) lookupByPropList(p string) (fi *mtpx.FileInfo, ok bool) {
+	if c.noPropList {
+		return nil, false
+	}
+
+	fi = &mtpx.FileInfo{IsDir: true, FullPath: "/", ObjectId: mtpx.ParentObjectId, Info: &mtp.ObjectInfo{}}
+	for _, name := range strings.Split(strings.Trim(path.Clean("/"+p), "/"), "/") {
+		if name == "" {
+			continue
+		}
+		if !fi.IsDir {
+			return nil, true
+		}
+		list, ok := c.propList(fi)
+		if !ok {
+			return nil, false
+		}
+		var next *mtpx.FileInfo
+		for _, child := range list {
+			if strings.EqualFold(child.Name, name) {
+				next = child
+				break
+			}
+		}
+		if next == nil {
+			return nil, true
+		}
+		fi = next
+	}
+	return fi, true
+}
+
+// walk is mtpx.Walk on the current storage, with the directories listed by
+// children, so roots given as id:N are looked up by id and cached trees are
+// walked in the object cache
+func (c *CLI) walk(root string, recursive, skipDisallowedFiles, skipHiddenFiles bool, cb mtpx.WalkCb) (uint32, int64, int64, error) {
+	fi, err := c.objectFromPath(root)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	id := fi.ObjectId
+	if skipDisallowedFiles && disallowedFiles[fi.Name] {
+		return 0, 0, 0, fmt.Errorf("disallowed file %s", fi.Name)
+	}
+	if !fi.IsDir {
+		return id, 1, 0, cb(id, fi, nil)
+	}
+
+	maxDepth := 1
+	if recursive {
+		maxDepth = math.MaxInt
+	}
+	var files, dirs int64
+	err = c.walkChildren(fi, 1, maxDepth, skipHiddenFiles, func(objectId uint32, fi *mtpx.FileInfo, err error) error {
+		if skipDisallowedFiles && disallowedFiles[fi.Name] {
+			return nil
+		}
+		if fi.IsDir {
+			dirs++
+		} else {
+			files++
+		}
+		return cb(objectId, fi, err)
+	})
+	return id, files, dirs, err
+}
+
+// children returns the objects directly below dir, from the object cache
+// when it has dir and with one GetObjPropList request where the device
+// supports it. Objects that vanish while they are listed are left out.
+func (c *CLI) children(dir *mtpx.FileInfo) ([]*mtpx.FileInfo, error) {
+	if t := c.cachedTree(); t != nil && t.lookup(dir.FullPath) != nil {
+		var list []*mtpx.FileInfo
+		for _, o := range t.children[path.Clean(dir.FullPath)] {
+			list = append(list, o.fileInfo(c.storage))
+		}
+		return list, nil
+	}
+	if list, ok := c.propList(dir); ok {
+		return list, nil
+	}
+
+	var handles mtp.Uint32Array
+	if err := c.device.GetObjectHandles(c.storage, mtp.GOH_ALL_ASSOCS, dir.ObjectId, &handles); err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", dir.FullPath, err)
+	}
+	var list []*mtpx.FileInfo
+	for _, id := range handles.Values {
+		fi, err := mtpx.GetObjectFromObjectId(c.device, id, dir.FullPath)
+		if err != nil {
+			continue
+		}
+		list = append(list, fi)
+	}
+	return list, nil
+}
+
+// resolveRemote resolves a remote path argument against --cwd and, with
+// --ignore-case, rewrites it to the casing used on the device
+func (c *CLI) resolveRemote(arg string) (string, error) {
+	p := remotePath(arg)
+	if !*ignoreCase {
+		return p, nil
+	}
+	return c.matchPathFold(p)
+}
+
+// matchPathFold resolves p segment by segment, matching each segment against
+// the entries of its parent directory with strings.EqualFold. A segment that
+// matches more than one entry is an error listing the candidates, since the
+// lookups downstream can't tell them apart either. Paths that match nothing
+// are returned unchanged so callers report them as missing the usual way.
+func (c *CLI) matchPathFold(p string) (string, error) {
+	resolved := "/"
+	for _, name := range strings.Split(strings.Trim(p, "/"), "/") {
+		if name == "" {
+			continue
+		}
+
+		var matches []string
+		_, _, _, err := c.walk(resolved, false, true, false,
+			func(objectId uint32, fi *mtpx.FileInfo, err error) error {
+				if err == nil && strings.EqualFold(fi.Name, name) {
+					matches = append(matches, fi.FullPath)
+				}
+				return nil
+			})
+		if err != nil {
+			return "", err
+		}
+
+		switch len(matches) {
+		case 0:
+			return p, nil
+		case 1:
+			resolved = path.Clean(matches[0])
+		default:
+			return "", fmt.Errorf("ambiguous path %s: matches %s", p, strings.Join(matches, ", "))
+		}
+	}
+	return resolved, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+func (c *CLI) handleRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	manifest := fs.String("manifest", "", "snapshot or manifest to restore (default the newest snapshot of the backup)")
+	checksum := fs.Bool("checksum", false, "only skip present remote files whose sha256 matches the manifest, not just their size")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) < 1 {
+		return usagef("restore requires a local backup dir")
+	}
+
+	localDir, err := filepath.Abs(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid local path: %w", err)
+	}
+
+	var m *manifestFile
+	if *manifest != "" {
+		m, err = readManifest(*manifest)
+	} else {
+		m, _, err = latestSnapshot(localDir)
+		if err == nil && m == nil {
+			err = usagef("%s holds no backup snapshot, use --manifest", localDir)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// files go back where they came from unless another remote path is given
+	target := m.Root
+	if len(args) > 1 {
+		target = remotePath(args[1])
+	}
+
+	s := &syncer{cli: c, remoteDir: target}
+	remote := map[string]syncEntry{}
+	if c.remoteExists(target) {
+		if remote, err = s.remoteEntries(); err != nil {
+			return err
+		}
+	}
+	if err := c.makeRemoteDir(target); err != nil {
+		return err
+	}
+
+	transfers.begin()
+	u := &uploader{cli: c, limiter: newRateLimiter(0)}
+	var uploaded, present, missing, dirs int64
+	for _, e := range m.Entries {
+		rel, ok := relRemotePath(m.Root, e.Path)
+		if !ok {
+			continue
+		}
+		remoteFile := path.Join(target, rel)
+
+		if e.Type == "dir" {
+			if _, ok := remote[rel]; !ok {
+				if err := c.makeRemoteDir(remoteFile); err != nil {
+					return err
+				}
+				dirs++
+			}
+			continue
+		}
+
+		localFile := filepath.Join(localDir, filepath.FromSlash(localRel(rel)))
+		if _, err := os.Stat(localFile); err != nil {
+			missing++
+			transfers.skip()
+			printJSON(map[string]interface{}{
+				"path":    localFile,
+				"target":  remoteFile,
+				"skipped": true,
+				"reason":  "missing_local",
+			})
+			continue
+		}
+
+		if r, ok := remote[rel]; ok && !r.isDir && r.size == e.Size {
+			same, err := c.restoredMatches(r, e, *checksum)
+			if err != nil {
+				return err
+			}
+			if same {
+				present++
+				transfers.skip()
+				printJSON(map[string]interface{}{
+					"path":    localFile,
+					"target":  remoteFile,
+					"skipped": true,
+					"reason":  "present",
+				})
+				continue
+			}
+		}
+
+		if err := u.uploadFileNamed(localFile, path.Dir(remoteFile), path.Base(remoteFile)); err != nil {
+			return err
+		}
+		uploaded++
+	}
+
+	printJSON(map[string]interface{}{
+		"uploaded":    uploaded,
+		"present":     present,
+		"missing":     missing,
+		"directories": dirs,
+	})
+	transfers.printSummary("restore")
+
+	printDone("MTPX_RESTORE_DONE")
+	return nil
+}
+
+// restoredMatches reports whether the remote file r of the same size already
+// holds the manifest entry e. With checksum it has to match the recorded
+// sha256, read back from the device; entries without one only compare sizes.
+func (c *CLI) restoredMatches(r syncEntry, e manifestEntry, checksum bool) (bool, error) {
+	if !checksum || e.SHA256 == "" {
+		return true, nil
+	}
+	digest, err := c.remoteDigest(r.fi.ObjectId, hashSHA256)
+	if err != nil {
+		return false, fmt.Errorf("failed to hash %s: %w", r.fi.FullPath, err)
+	}
+	return hex.EncodeToString(digest) == e.SHA256, nil
+}
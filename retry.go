@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// validateRetries checks --retries and --retry-delay
+func validateRetries(retries int, delay time.Duration) error {
+	if retries < 0 {
+		return usagef("invalid --retries %d: must not be negative", retries)
+	}
+	if delay < 0 {
+		return usagef("invalid --retry-delay %s: must not be negative", delay)
+	}
+	return nil
+}
+
+// retry runs fn for the object named what and runs it again when a failure
+// killed the session, at most --retries times. The session is reopened before
+// each retry, after a delay that starts at --retry-delay and doubles. Other
+// failures, such as a missing file, an interrupt or a --transfer-timeout, are
+// returned right away.
+// fn gets the attempt number counting from 0, so it can look objects up again
+// whose ids the new session may not know.
+func (c *CLI) retry(what string, fn func(attempt int) error) error {
+	delay := *retryDelay
+	for attempt := 0; ; attempt++ {
+		err := fn(attempt)
+		if err == nil || attempt >= *retries || errors.Is(err, errCanceled) || errors.Is(err, errTransferTimeout) {
+			return transferFailed(err)
+		}
+
+		var alive bool
+		c.withDevice(func() error {
+			alive = c.sessionAlive()
+			return nil
+		})
+		if alive {
+			return transferFailed(err)
+		}
+
+		printJSON(map[string]interface{}{
+			"event":   "retry",
+			"path":    what,
+			"attempt": attempt + 1,
+			"delay":   delay.String(),
+			"error":   err.Error(),
+		})
+		time.Sleep(delay)
+		delay *= 2
+
+		// a failed reconnect is retried until the attempts run out, the
+		// device may still be re-enumerating
+		rerr := c.withDevice(func() error {
+			if c.sessionAlive() {
+				return nil
+			}
+			return c.reconnect()
+		})
+		if rerr != nil {
+			printJSON(map[string]string{
+				"event": "reconnect_failed",
+				"error": rerr.Error(),
+			})
+		}
+	}
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// JSON-RPC 2.0 error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcCommandFailed  = -32000
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+// rpcMethod answers a request on the open session. Output the command code
+// prints meanwhile reaches the caller as notifications.
+type rpcMethod func(c *CLI, params json.RawMessage) (interface{}, error)
+
+var rpcMethods = map[string]rpcMethod{
+	"list":     rpcList,
+	"stat":     rpcStat,
+	"download": rpcDownload,
+	"upload":   rpcUpload,
+	"delete":   rpcDelete,
+}
+
+// handleServe holds the device session and answers newline delimited JSON-RPC
+// 2.0 requests on stdin/stdout, or on a unix socket with --socket. Requests
+// are answered one at a time since there is only one device session.
+func (c *CLI) handleServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	socket := fs.String("socket", "", "listen on this unix socket instead of stdin/stdout")
+	metricsListen := fs.String("metrics-listen", "", "serve Prometheus metrics on /metrics at this address")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return usagef("serve takes no arguments")
+	}
+
+	metrics = newMetricsRegistry()
+	if *metricsListen != "" {
+		stop, err := serveMetrics(*metricsListen)
+		if err != nil {
+			return err
+		}
+		defer stop()
+	}
+
+	s := &rpcServer{cli: c}
+
+	if *socket == "" {
+		s.serveConn(stdin, os.Stdout)
+		return nil
+	}
+
+	if err := removeStaleSocket(*socket); err != nil {
+		return err
+	}
+	l, err := net.Listen("unix", *socket)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", *socket, err)
+	}
+	defer os.Remove(*socket)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	go func() {
+		<-sig
+		l.Close()
+	}()
+
+	printJSON(map[string]string{"listening": *socket})
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				break
+			}
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			s.serveConn(bufio.NewReader(conn), conn)
+		}()
+	}
+
+	printDone("MTPX_SERVE_DONE")
+	return nil
+}
+
+// removeStaleSocket removes a socket file left behind by an earlier serve.
+// Anything else at that path is left alone.
+func removeStaleSocket(socket string) error {
+	fi, err := os.Lstat(socket)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", socket)
+	}
+	return os.Remove(socket)
+}
+
+type rpcServer struct {
+	cli *CLI
+
+	// mu serializes requests, they all share the device and the global
+	// output writers
+	mu sync.Mutex
+}
+
+// rpcConn writes responses and notifications of one connection
+type rpcConn struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (rc *rpcConn) send(v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("warning: failed to marshal JSON-RPC message: %v", err)
+		return
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.w.Write(append(b, '\n'))
+}
+
+// notifier turns every line written to it into a notification for method.
+// JSON lines become the params as they are, other lines a string.
+type notifier struct {
+	conn   *rpcConn
+	method string
+}
+
+func (n *notifier) Write(p []byte) (int, error) {
+	line := p
+	if len(line) > 0 && line[len(line)-1] == '\n' {
+		line = line[:len(line)-1]
+	}
+
+	var params interface{} = string(line)
+	if json.Valid(line) {
+		params = json.RawMessage(line)
+	}
+	n.conn.send(rpcNotification{JSONRPC: "2.0", Method: n.method, Params: params})
+	return len(p), nil
+}
+
+func (s *rpcServer) serveConn(r *bufio.Reader, w io.Writer) {
+	conn := &rpcConn{w: w}
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			if resp := s.handle(conn, line); resp != nil {
+				conn.send(resp)
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// handle answers one request line. Notifications (requests without an id)
+// get no response.
+func (s *rpcServer) handle(conn *rpcConn, line []byte) *rpcResponse {
+	var req rpcRequest
+	if err := json.Unmarshal(line, &req); err != nil {
+		return &rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"),
+			Error: &rpcError{Code: rpcParseError, Message: err.Error()}}
+	}
+
+	resp := &rpcResponse{JSONRPC: "2.0", ID: req.ID}
+	if resp.ID == nil {
+		resp.ID = json.RawMessage("null")
+	}
+
+	method, ok := rpcMethods[req.Method]
+	switch {
+	case req.JSONRPC != "2.0" || req.Method == "":
+		resp.Error = &rpcError{Code: rpcInvalidRequest, Message: "invalid request"}
+	case !ok:
+		resp.Error = &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf("unknown method: %s", req.Method)}
+	default:
+		start := time.Now()
+		result, err := s.call(conn, method, req.Params)
+		metrics.observe(req.Method, time.Since(start), err)
+		var perr paramsError
+		switch {
+		case errors.As(err, &perr):
+			resp.Error = &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+		case err != nil:
+			resp.Error = &rpcError{Code: rpcCommandFailed, Message: err.Error(),
+				Data: map[string]string{"code": errorCode(err)}}
+		default:
+			resp.Result = result
+		}
+	}
+
+	if req.ID == nil {
+		return nil
+	}
+	return resp
+}
+
+// call runs method with printJSON output and progress redirected to the
+// connection as "output" and "progress" notifications
+func (s *rpcServer) call(conn *rpcConn, method rpcMethod, params json.RawMessage) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	outputMu.Lock()
+	prevResult, prevProgress := resultOut, progressOut
+	resultOut = &notifier{conn: conn, method: "output"}
+	progressOut = &notifier{conn: conn, method: "progress"}
+	outputMu.Unlock()
+
+	defer func() {
+		outputMu.Lock()
+		resultOut, progressOut = prevResult, prevProgress
+		outputMu.Unlock()
+	}()
+
+	result, err := method(s.cli, params)
+	if err != nil {
+		// the session goes stale when the device sleeps or is replugged
+		s.cli.reconnectIfDead()
+	}
+	return result, err
+}
+
+// paramsError marks errors in the request params
+type paramsError struct {
+	error
+}
+
+func decodeParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 {
+		return paramsError{fmt.Errorf("missing params")}
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return paramsError{err}
+	}
+	return nil
+}
+
+func rpcList(c *CLI, params json.RawMessage) (interface{}, error) {
+	var p struct {
+		Path       string `json:"path"`
+		SkipHidden bool   `json:"skip_hidden"`
+		MTPInfo    bool   `json:"mtp_info"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Path == "" {
+		return nil, paramsError{fmt.Errorf("list requires path")}
+	}
+
+	roots, err := c.expandRemote(p.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []map[string]interface{}{}
+	for _, root := range roots {
+		err := c.listTree(root, p.SkipHidden, p.MTPInfo, nil, -1, func(entry map[string]interface{}, _ *mtpx.FileInfo) {
+			entries = append(entries, entry)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+func rpcStat(c *CLI, params json.RawMessage) (interface{}, error) {
+	var p struct {
+		Path string `json:"path"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Path == "" {
+		return nil, paramsError{fmt.Errorf("stat requires path")}
+	}
+
+	remote, err := c.resolveRemote(p.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := c.fileExists([]mtpx.FileProp{{FullPath: remote}})
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("failed to look up %s", remote)
+	}
+
+	info := results[0]
+	if !info.Exists {
+		return map[string]interface{}{"path": remote, "exists": false}, nil
+	}
+	return map[string]interface{}{
+		"path":   info.FileInfo.FullPath,
+		"exists": true,
+		"size":   info.FileInfo.Size,
+		"is_dir": info.FileInfo.IsDir,
+	}, nil
+}
+
+func rpcDownload(c *CLI, params json.RawMessage) (interface{}, error) {
+	var p struct {
+		Path         string `json:"path"`
+		Target       string `json:"target"`
+		SkipHidden   bool   `json:"skip_hidden"`
+		SkipExisting bool   `json:"skip_existing"`
+		Resume       bool   `json:"resume"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Path == "" || p.Target == "" {
+		return nil, paramsError{fmt.Errorf("download requires path and target")}
+	}
+
+	targetDir, err := filepath.Abs(p.Target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target path: %w", err)
+	}
+
+	d := &downloader{
+		cli:          c,
+		skipHidden:   p.SkipHidden,
+		skipExisting: p.SkipExisting,
+		compare:      compareSizeMtime,
+		limiter:      newRateLimiter(0),
+		resume:       p.Resume,
+	}
+	remotes, err := c.expandRemote(p.Path)
+	if err != nil {
+		return nil, err
+	}
+	for _, remote := range remotes {
+		if err := d.download(remote, targetDir); err != nil {
+			return nil, err
+		}
+	}
+
+	return map[string]interface{}{"files": d.files, "size": d.size}, nil
+}
+
+func rpcUpload(c *CLI, params json.RawMessage) (interface{}, error) {
+	var p struct {
+		Source    string `json:"source"`
+		Target    string `json:"target"`
+		Recursive bool   `json:"recursive"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Source == "" || p.Target == "" {
+		return nil, paramsError{fmt.Errorf("upload requires source and target")}
+	}
+
+	localPath, err := filepath.Abs(p.Source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid local file path: %w", err)
+	}
+
+	u := &uploader{cli: c, limiter: newRateLimiter(0)}
+	remoteDir := remotePath(p.Target)
+	if p.Recursive {
+		err = u.uploadTree(localPath, remoteDir)
+	} else {
+		err = u.uploadFile(localPath, remoteDir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"uploaded": true}, nil
+}
+
+func rpcDelete(c *CLI, params json.RawMessage) (interface{}, error) {
+	var p struct {
+		Paths []string `json:"paths"`
+	}
+	if err := decodeParams(params, &p); err != nil {
+		return nil, err
+	}
+	if len(p.Paths) == 0 {
+		return nil, paramsError{fmt.Errorf("delete requires paths")}
+	}
+
+	results := []deleteResult{}
+	for _, arg := range p.Paths {
+		remotes, err := c.expandRemote(arg)
+		if err != nil {
+			return nil, err
+		}
+		for _, remote := range remotes {
+			if *dryRun {
+				printPlanned("delete", map[string]interface{}{"path": remote})
+				continue
+			}
+			results = append(results, c.deletePath(mtpx.FileProp{FullPath: remote}))
+		}
+	}
+	return results, nil
+}
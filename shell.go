@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// shellAliases maps the short shell command names to CLI commands
+var shellAliases = map[string]string{
+	"ls":  "list",
+	"get": "download",
+	"put": "upload",
+	"rm":  "delete",
+}
+
+// handleShell reads commands from stdin and runs them on the open session
+// until exit or end of input. Besides the aliases above and cd/pwd, every
+// device command is available under its usual name and prints its usual
+// output. A failing command is reported on stderr and doesn't end the shell.
+func (c *CLI) handleShell(args []string) error {
+	if len(args) > 0 {
+		return usagef("shell takes no arguments")
+	}
+
+	prompt := isTerminal(os.Stdin)
+	for {
+		if prompt {
+			fmt.Fprintf(os.Stderr, "mtpx:%s> ", remotePath("."))
+		}
+
+		line, err := stdin.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			if prompt {
+				fmt.Fprintln(os.Stderr)
+			}
+			break
+		}
+
+		words, perr := splitShellLine(line)
+		if perr != nil {
+			log.Print(perr)
+			continue
+		}
+		if len(words) == 0 {
+			continue
+		}
+		if words[0] == "exit" || words[0] == "quit" {
+			break
+		}
+
+		interrupted.Store(false)
+		if err := c.runShellCommand(words[0], words[1:]); err != nil {
+			printError(err)
+			log.Print(err)
+			// the session goes stale when the device sleeps or is replugged
+			c.reconnectIfDead()
+		}
+	}
+
+	printDone("MTPX_SHELL_DONE")
+	return nil
+}
+
+func (c *CLI) runShellCommand(cmd string, args []string) error {
+	switch cmd {
+	case "pwd":
+		return printJSON(map[string]string{"cwd": remotePath(".")})
+	case "cd":
+		return c.changeDir(args)
+	case "help":
+		printShellHelp()
+		return nil
+	case "shell":
+		return fmt.Errorf("already in a shell")
+	}
+
+	if name, ok := shellAliases[cmd]; ok {
+		cmd = name
+	}
+
+	// default the optional arguments the interactive names make obvious
+	switch {
+	case cmd == "list" && len(args) == 0:
+		args = []string{"."}
+	case cmd == "download" && len(args) == 1:
+		args = append(args, ".")
+	case cmd == "upload" && len(args) == 1:
+		args = append(args, ".")
+	}
+
+	return c.run(cmd, args)
+}
+
+// changeDir sets the remote working directory after checking that the target
+// is a directory. Without an argument it returns to the root.
+func (c *CLI) changeDir(args []string) error {
+	if len(args) > 1 {
+		return usagef("cd takes one remote directory")
+	}
+
+	dir := "/"
+	if len(args) == 1 {
+		resolved, err := c.resolveRemote(args[0])
+		if err != nil {
+			return err
+		}
+		dir = resolved
+	}
+
+	fi, err := c.objectFromPath(dir)
+	if err != nil {
+		return fmt.Errorf("cd %s: %w", dir, err)
+	}
+	if !fi.IsDir {
+		return fmt.Errorf("cd %s: not a directory", dir)
+	}
+
+	*remoteCwd = dir
+	return nil
+}
+
+func printShellHelp() {
+	fmt.Println("Shell commands:")
+	fmt.Printf("  %-35s %s\n", "ls [remote_path]", "List files (list)")
+	fmt.Printf("  %-35s %s\n", "cd [remote_dir]", "Change the remote working directory")
+	fmt.Printf("  %-35s %s\n", "pwd", "Print the remote working directory")
+	fmt.Printf("  %-35s %s\n", "get <remote> [local_dir]", "Download into local_dir or . (download)")
+	fmt.Printf("  %-35s %s\n", "put <local_path> [remote_dir]", "Upload into remote_dir or the working directory (upload)")
+	fmt.Printf("  %-35s %s\n", "rm <remote_path> [...]", "Delete files (delete)")
+	fmt.Printf("  %-35s %s\n", "exit", "Leave the shell")
+	fmt.Println("All other device commands work under their usual names.")
+}
+
+// splitShellLine splits a shell line into words. Single and double quotes
+// group words with spaces and a backslash escapes the next character outside
+// single quotes.
+func splitShellLine(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+
+	runes := []rune(strings.TrimRight(line, "\r\n"))
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else if r == '\\' && quote == '"' && i+1 < len(runes) {
+				i++
+				word.WriteRune(runes[i])
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == '\\' && i+1 < len(runes):
+			i++
+			word.WriteRune(runes[i])
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
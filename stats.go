@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// transferStats counts the files of a transfer command for its summary.
+// Finished transfers are counted by progress.complete, so every upload and
+// download path reports them without help.
+type transferStats struct {
+	mu           sync.Mutex
+	start        time.Time
+	files, bytes int64
+	skipped      int64
+}
+
+// transfers is shared by every transfer of the process, like progress
+var transfers = &transferStats{start: time.Now()}
+
+// begin starts counting for a new command
+func (s *transferStats) begin() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.start = time.Now()
+	s.files, s.bytes, s.skipped = 0, 0, 0
+}
+
+func (s *transferStats) transferred(size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files++
+	s.bytes += size
+}
+
+// skip counts a file that was left alone, such as an unchanged one
+func (s *transferStats) skip() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.skipped++
+}
+
+// printSummary prints the summary event of command: files transferred and
+// skipped, bytes, elapsed time and average throughput in bytes per second.
+// Dry runs transfer nothing and print none.
+func (s *transferStats) printSummary(command string) error {
+	if *dryRun {
+		return nil
+	}
+
+	s.mu.Lock()
+	elapsed := time.Since(s.start)
+	summary := map[string]interface{}{
+		"event":           "summary",
+		"command":         command,
+		"files":           s.files,
+		"skipped":         s.skipped,
+		"bytes":           s.bytes,
+		"elapsed":         elapsed.Round(time.Millisecond).String(),
+		"elapsed_seconds": elapsed.Seconds(),
+		"throughput":      int64(0),
+	}
+	if elapsed > 0 {
+		summary["throughput"] = int64(float64(s.bytes) / elapsed.Seconds())
+	}
+	s.mu.Unlock()
+
+	return printJSON(summary)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// storageEntry is a storage as printed by storage-info. The embedded
+// StorageData keeps the original Sid and Info fields.
+type storageEntry struct {
+	Index int    `json:"index"`
+	Label string `json:"label"`
+	mtpx.StorageData
+}
+
+// storageLabel names a storage by its volume label, falling back to the
+// description most devices fill in instead
+func storageLabel(s mtpx.StorageData) string {
+	if s.Info.VolumeLabel != "" {
+		return s.Info.VolumeLabel
+	}
+	return s.Info.StorageDescription
+}
+
+// selectStorage picks the storage chosen with --storage: an index into the
+// storage-info output, a storage id (decimal or 0x hex), or a label or
+// description matched case-insensitively. An empty selector picks the first
+// storage.
+func selectStorage(storages []mtpx.StorageData, selector string) (mtpx.StorageData, error) {
+	if len(storages) == 0 {
+		return mtpx.StorageData{}, errNoStorage
+	}
+	if selector == "" {
+		return storages[0], nil
+	}
+
+	if i, err := strconv.Atoi(selector); err == nil && i >= 0 && i < len(storages) {
+		return storages[i], nil
+	}
+
+	if sid, err := strconv.ParseUint(selector, 0, 32); err == nil {
+		for _, s := range storages {
+			if s.Sid == uint32(sid) {
+				return s, nil
+			}
+		}
+	}
+
+	var matches []mtpx.StorageData
+	for _, s := range storages {
+		if strings.EqualFold(s.Info.VolumeLabel, selector) || strings.EqualFold(s.Info.StorageDescription, selector) {
+			matches = append(matches, s)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return mtpx.StorageData{}, fmt.Errorf("no storage matches %s, see storage-info", selector)
+	case 1:
+		return matches[0], nil
+	}
+	return mtpx.StorageData{}, fmt.Errorf("several storages match %s, select one by index or id", selector)
+}
+
+// targetStorage returns the id of the storage a --to-storage selector picks,
+// the current storage for an empty one
+func (c *CLI) targetStorage(selector string) (uint32, error) {
+	if selector == "" {
+		return c.storage, nil
+	}
+	storages, err := mtpx.FetchStorages(c.device)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch storage info: %w", err)
+	}
+	s, err := selectStorage(storages, selector)
+	if err != nil {
+		return 0, err
+	}
+	return s.Sid, nil
+}
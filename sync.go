@@ -0,0 +1,539 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// syncEntry is a file or directory on either side of a sync, keyed by its
+// slash separated path relative to the synced directory
+type syncEntry struct {
+	size  int64
+	mtime time.Time
+	isDir bool
+
+	// remote object, only set for remote entries read with Walk
+	fi *mtpx.FileInfo
+}
+
+// syncer mirrors a local directory to the device or, reversed, a remote
+// directory to the local disk
+type syncer struct {
+	cli         *CLI
+	localDir    string
+	remoteDir   string
+	skipHidden  bool
+	deleteExtra bool
+
+	// prompt asks before each delete with -i, confirmAll once before all of
+	// them otherwise
+	prompt     *prompter
+	confirmAll *prompter
+
+	// concurrency is the number of transfer queue workers
+	concurrency int
+
+	// onConflict is the --on-conflict policy for changed files that exist on
+	// the target side
+	onConflict string
+
+	// filter leaves paths out on both sides, nil keeps all
+	filter *pathFilter
+
+	// trash is the remote directory --delete moves objects into, empty
+	// deletes them
+	trash string
+
+	transferred, unchanged, skipped, deleted, dirs int64
+}
+
+func (c *CLI) handleSync(args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+	reverse := fs.Bool("reverse", false, "mirror the remote directory to the local directory instead")
+	deleteExtra := fs.Bool("delete", false, "delete files on the target side that are missing on the source side")
+	var interactive bool
+	fs.BoolVar(&interactive, "i", false, "ask for confirmation before each delete")
+	fs.BoolVar(&interactive, "interactive", false, "ask for confirmation before each delete")
+	yes := fs.Bool("yes", false, "never ask for confirmation")
+	fs.BoolVar(yes, "y", false, "same as --yes")
+	skipHidden := fs.Bool("skip-hidden", false, "leave hidden files alone on both sides")
+	manifest := fs.String("manifest", "", "read the remote state from a manifest file instead of walking the device")
+	concurrency := fs.Int("concurrency", 1, "number of files transferred in parallel")
+	onConflict := fs.String("on-conflict", conflictOverwrite, "what to do with changed files on the target side: skip, overwrite or newer")
+	trash := fs.String("trash", "", "with --delete, move remote objects into this remote trash directory instead of deleting them")
+	filters := addFilterFlags(fs)
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) < 2 {
+		return usagef("sync requires local dir and remote dir")
+	}
+	if *reverse && *manifest != "" {
+		return usagef("--manifest can't be used with --reverse")
+	}
+	if err := validateConcurrency(*concurrency); err != nil {
+		return err
+	}
+	if err := validateConflict(*onConflict); err != nil {
+		return err
+	}
+	// the renamed copy would be copied again on every run
+	if *onConflict == conflictRename {
+		return usagef("--on-conflict rename can't be used with sync")
+	}
+	if *trash != "" && *reverse {
+		return usagef("--trash can't be used with --reverse, which deletes local files")
+	}
+	// a trash inside the mirrored directory would be trashed again
+	if t, dir := path.Clean(remotePath(*trash)), path.Clean(remotePath(args[1])); *trash != "" && (t == dir || dir == "/" || strings.HasPrefix(t, dir+"/")) {
+		return usagef("the trash %s must be outside the remote directory %s", t, dir)
+	}
+
+	filter, err := filters.filter()
+	if err != nil {
+		return err
+	}
+
+	localDir, err := filepath.Abs(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid local path: %w", err)
+	}
+
+	s := &syncer{
+		cli:         c,
+		localDir:    localDir,
+		remoteDir:   remotePath(args[1]),
+		skipHidden:  *skipHidden,
+		deleteExtra: *deleteExtra,
+		prompt:      newPrompter(interactive && !*dryRun, *yes),
+		confirmAll:  newPrompter(!interactive && !*dryRun, *yes),
+		concurrency: *concurrency,
+		onConflict:  *onConflict,
+		filter:      filter,
+		trash:       *trash,
+	}
+
+	transfers.begin()
+	if *reverse {
+		err = s.pull()
+	} else {
+		err = s.push(*manifest)
+	}
+	if err != nil {
+		return err
+	}
+
+	printJSON(map[string]interface{}{
+		"transferred": s.transferred,
+		"unchanged":   s.unchanged,
+		"skipped":     s.skipped,
+		"deleted":     s.deleted,
+		"directories": s.dirs,
+	})
+	transfers.printSummary("sync")
+
+	printDone("MTPX_SYNC_DONE")
+	return nil
+}
+
+// push uploads new and changed local files and, with --delete, removes remote
+// objects that don't exist locally
+func (s *syncer) push(manifest string) error {
+	c := s.cli
+
+	rootExisted := c.remoteExists(s.remoteDir)
+	if err := c.makeRemoteDir(s.remoteDir); err != nil {
+		return err
+	}
+
+	local, err := s.localEntries()
+	if err != nil {
+		return err
+	}
+
+	remote := map[string]syncEntry{}
+	switch {
+	case manifest != "":
+		remote, err = s.manifestEntries(manifest)
+	case rootExisted:
+		remote, err = s.remoteEntries()
+	}
+	if err != nil {
+		return err
+	}
+
+	u := &uploader{cli: c, limiter: newRateLimiter(0)}
+	q := newTransferQueue(c, s.concurrency, s.concurrency > 1)
+	for _, rel := range sortedKeys(local) {
+		l := local[rel]
+		remoteFile := path.Join(s.remoteDir, rel)
+		r, exists := remote[rel]
+
+		if exists && r.isDir != l.isDir {
+			log.Printf("warning: skipping %s, it is a file on one side and a directory on the other", rel)
+			continue
+		}
+
+		if l.isDir {
+			if !exists {
+				if err := c.withDevice(func() error { return c.makeRemoteDir(remoteFile) }); err != nil {
+					q.wait()
+					return err
+				}
+				s.dirs++
+			}
+			continue
+		}
+
+		if exists && !syncChanged(l, r) {
+			s.unchanged++
+			transfers.skip()
+			continue
+		}
+		localFile := filepath.Join(s.localDir, filepath.FromSlash(rel))
+		if exists && s.conflict(localFile, remoteFile, l, r) {
+			continue
+		}
+		if err := q.add(l.size, func() error { return u.uploadFile(localFile, path.Dir(remoteFile)) }); err != nil {
+			break
+		}
+	}
+	if err := q.wait(); err != nil {
+		return err
+	}
+	s.transferred, _ = q.done()
+
+	if !s.deleteExtra {
+		return nil
+	}
+
+	return s.deleteMissing(remote, local, func(rel string) (bool, error) {
+		if s.trash != "" {
+			fi, err := c.objectFromPath(path.Join(s.remoteDir, rel))
+			if err != nil {
+				return false, err
+			}
+			return true, c.moveToTrash(fi, remotePath(s.trash))
+		}
+		if *dryRun {
+			return true, printPlanned("delete", map[string]interface{}{"path": path.Join(s.remoteDir, rel)})
+		}
+
+		r := c.deletePath(mtpx.FileProp{FullPath: path.Join(s.remoteDir, rel)})
+		printJSON(r)
+		if r.Error != "" {
+			return false, fmt.Errorf("failed to delete %s: %s", r.Path, r.Error)
+		}
+		return r.Deleted, nil
+	})
+}
+
+// pull downloads new and changed remote files and, with --delete, removes
+// local files that don't exist on the device. Local names are made safe the
+// same way download does.
+func (s *syncer) pull() error {
+	if err := makeLocalDir(s.localDir); err != nil {
+		return err
+	}
+
+	remote, err := s.remoteEntries()
+	if err != nil {
+		return err
+	}
+	local, err := s.localEntries()
+	if err != nil {
+		return err
+	}
+
+	// remote entries keyed by the local path they are synced to
+	mapped := map[string]syncEntry{}
+	for rel, r := range remote {
+		mapped[localRel(rel)] = r
+	}
+
+	d := &downloader{cli: s.cli, limiter: newRateLimiter(0)}
+	q := newTransferQueue(s.cli, s.concurrency, s.concurrency > 1)
+	for _, rel := range sortedKeys(mapped) {
+		r := mapped[rel]
+		localPath := filepath.Join(s.localDir, filepath.FromSlash(rel))
+		l, exists := local[rel]
+
+		if exists && r.isDir != l.isDir {
+			log.Printf("warning: skipping %s, it is a file on one side and a directory on the other", rel)
+			continue
+		}
+
+		if r.isDir {
+			if !exists {
+				if err := makeLocalDir(localPath); err != nil {
+					q.wait()
+					return err
+				}
+				s.dirs++
+			}
+			continue
+		}
+
+		if exists && !syncChanged(r, l) {
+			s.unchanged++
+			transfers.skip()
+			continue
+		}
+		if exists && s.conflict(r.fi.FullPath, localPath, r, l) {
+			continue
+		}
+		if err := q.add(r.size, func() error { return d.downloadFile(r.fi, localPath) }); err != nil {
+			break
+		}
+	}
+	if err := q.wait(); err != nil {
+		return err
+	}
+	s.transferred, _ = q.done()
+
+	if !s.deleteExtra {
+		return nil
+	}
+
+	return s.deleteMissing(local, mapped, func(rel string) (bool, error) {
+		localPath := filepath.Join(s.localDir, filepath.FromSlash(rel))
+		if *dryRun {
+			return true, printPlanned("delete", map[string]interface{}{"path": localPath})
+		}
+
+		if err := os.RemoveAll(localPath); err != nil {
+			return false, err
+		}
+		printJSON(map[string]interface{}{
+			"path":    localPath,
+			"deleted": true,
+		})
+		return true, nil
+	})
+}
+
+// conflict applies --on-conflict to a changed file that exists on both sides
+// and reports whether it is skipped
+func (s *syncer) conflict(source, target string, src, dst syncEntry) bool {
+	reason := checkConflict(s.onConflict, src.mtime, dst.mtime)
+	if reason == "" {
+		return false
+	}
+	s.skipped++
+	printConflictSkip(source, target, reason)
+	return true
+}
+
+// deleteVerb says what --delete does to the target side
+func (s *syncer) deleteVerb() string {
+	if s.trash != "" {
+		return "trash"
+	}
+	return "delete"
+}
+
+// deleteMissing calls del for every entry of target that source lacks, after
+// confirmation. Entries below a deleted directory go with it and are skipped.
+func (s *syncer) deleteMissing(target, source map[string]syncEntry, del func(rel string) (bool, error)) error {
+	if s.confirmAll.enabled {
+		var paths, dirPaths []string
+		var size int64
+		for _, rel := range sortedKeys(target) {
+			if _, ok := source[rel]; ok || underAny(rel, dirPaths) {
+				continue
+			}
+			if target[rel].isDir {
+				dirPaths = append(dirPaths, rel)
+				paths = append(paths, rel+"/")
+				continue
+			}
+			paths = append(paths, rel)
+			size += target[rel].size
+		}
+		if !s.confirmAll.confirmDeletion(s.deleteVerb(), paths, size, len(dirPaths)) {
+			return printDeclined(len(paths))
+		}
+	}
+
+	var removedDirs []string
+	for _, rel := range sortedKeys(target) {
+		if _, ok := source[rel]; ok {
+			continue
+		}
+		if underAny(rel, removedDirs) {
+			continue
+		}
+
+		if !s.prompt.confirm(fmt.Sprintf("delete %s?", rel)) {
+			printJSON(map[string]interface{}{
+				"path":    rel,
+				"skipped": true,
+				"reason":  "declined",
+			})
+			continue
+		}
+
+		deleted, err := del(rel)
+		if err != nil {
+			return err
+		}
+		if deleted {
+			s.deleted++
+			if target[rel].isDir {
+				removedDirs = append(removedDirs, rel)
+			}
+		}
+	}
+	return nil
+}
+
+// localEntries lists the local directory. Symlinks and other non-regular
+// files are skipped with a warning, as upload -r does.
+func (s *syncer) localEntries() (map[string]syncEntry, error) {
+	entries := map[string]syncEntry{}
+
+	// only possible with --dry-run, which doesn't create the directory
+	if _, err := os.Stat(s.localDir); os.IsNotExist(err) {
+		return entries, nil
+	}
+
+	err := filepath.Walk(s.localDir, func(localPath string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if localPath == s.localDir {
+			return nil
+		}
+
+		if s.skipHidden && strings.HasPrefix(fi.Name(), ".") {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !fi.IsDir() && !fi.Mode().IsRegular() {
+			log.Printf("warning: skipping %s, not a regular file", localPath)
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.localDir, localPath)
+		if err != nil {
+			return err
+		}
+		if s.filter.skip(filepath.ToSlash(rel), fi.IsDir()) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		entries[filepath.ToSlash(rel)] = syncEntry{size: fi.Size(), mtime: fi.ModTime(), isDir: fi.IsDir()}
+		return nil
+	})
+	return entries, err
+}
+
+// remoteEntries walks the remote directory
+func (s *syncer) remoteEntries() (map[string]syncEntry, error) {
+	c := s.cli
+	entries := map[string]syncEntry{}
+	hidden := newHiddenFilter(c)
+
+	_, _, _, err := c.walk(s.remoteDir, true, true, s.skipHidden,
+		func(objectId uint32, fi *mtpx.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if s.skipHidden && hidden.skip(fi) {
+				return nil
+			}
+
+			rel, ok := relRemotePath(s.remoteDir, fi.FullPath)
+			if !ok || s.filter.skip(rel, fi.IsDir) {
+				return nil
+			}
+			entries[rel] = syncEntry{size: fi.Size, mtime: fi.ModTime, isDir: fi.IsDir, fi: fi}
+			return nil
+		})
+	return entries, err
+}
+
+// manifestEntries reads the remote state below the remote directory from a
+// manifest. Hidden objects can only be recognized by their dot names here.
+func (s *syncer) manifestEntries(file string) (map[string]syncEntry, error) {
+	m, err := readManifest(file)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := map[string]syncEntry{}
+	for _, e := range m.Entries {
+		rel, ok := relRemotePath(s.remoteDir, e.Path)
+		if !ok || s.filter.skip(rel, e.Type == "dir") {
+			continue
+		}
+		if s.skipHidden && (strings.HasPrefix(rel, ".") || strings.Contains(rel, "/.")) {
+			continue
+		}
+		entries[rel] = syncEntry{size: e.Size, mtime: e.Mtime, isDir: e.Type == "dir"}
+	}
+	return entries, nil
+}
+
+// syncChanged reports whether the target copy of a file is out of date: the
+// sizes differ or the source was modified after the target. Devices often
+// stamp uploads with the upload time, so equal times aren't required.
+func syncChanged(src, dst syncEntry) bool {
+	if src.size != dst.size {
+		return true
+	}
+	return src.mtime.Truncate(time.Second).After(dst.mtime.Truncate(time.Second))
+}
+
+// relRemotePath returns p relative to root, and false when p is root itself
+// or not below it
+func relRemotePath(root, p string) (string, bool) {
+	root, p = path.Clean(root), path.Clean(p)
+	prefix := strings.TrimSuffix(root, "/") + "/"
+	if !strings.HasPrefix(p, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(p, prefix), true
+}
+
+// localRel maps a remote relative path to the local one
+func localRel(rel string) string {
+	parts := strings.Split(rel, "/")
+	for i, name := range parts {
+		parts[i] = safeLocalName(name)
+	}
+	return strings.Join(parts, "/")
+}
+
+func underAny(rel string, dirs []string) bool {
+	for _, dir := range dirs {
+		if strings.HasPrefix(rel, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]syncEntry) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimiter caps the average transfer rate with a token bucket holding at
+// most one second worth of bytes. A transfer may take more tokens than are
+// available; the bucket then goes into debt and the caller sleeps it off, so
+// chunks larger than the bucket never block forever.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+
+	// parent is the --bwlimit limiter, which every transfer also waits for
+	parent *rateLimiter
+}
+
+// bwLimiter caps the rate of all transfers of the process together, nil
+// without --bwlimit
+var bwLimiter *rateLimiter
+
+// newRateLimiter returns a limiter for bytesPerSec, or nil for no limit. The
+// limiter is chained to bwLimiter, which it returns for bytesPerSec 0. All
+// methods accept a nil limiter.
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	if bytesPerSec <= 0 {
+		return bwLimiter
+	}
+	return &rateLimiter{rate: float64(bytesPerSec), tokens: float64(bytesPerSec), last: time.Now(), parent: bwLimiter}
+}
+
+// setBandwidthLimit parses --bwlimit, a rate such as 5M in bytes per second,
+// into bwLimiter
+func setBandwidthLimit(limit string) error {
+	if limit == "" {
+		return nil
+	}
+	rate, err := parseSize(limit)
+	if err != nil {
+		return usagef("invalid --bwlimit: %v", err)
+	}
+	bwLimiter = newRateLimiter(rate)
+	return nil
+}
+
+// wait accounts for n transferred bytes and sleeps as long as needed to keep
+// the average rate under the limit
+func (l *rateLimiter) wait(n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	debt := l.tokens
+	l.mu.Unlock()
+
+	if debt < 0 {
+		time.Sleep(time.Duration(-debt / l.rate * float64(time.Second)))
+	}
+	l.parent.wait(n)
+}
+
+// throttledWriter passes writes through a rateLimiter
+type throttledWriter struct {
+	w io.Writer
+	l *rateLimiter
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	n, err := t.w.Write(p)
+	t.l.wait(n)
+	return n, err
+}
+
+// throttledReader passes reads through a rateLimiter
+type throttledReader struct {
+	r io.Reader
+	l *rateLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.l.wait(n)
+	return n, err
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/ganeshrvel/go-mtpfs/mtp"
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// handleThumbnail saves the thumbnail the device keeps for a media file,
+// without transferring the file itself. A local file of - writes the image to
+// stdout, which then carries nothing else.
+func (c *CLI) handleThumbnail(args []string) error {
+	fs := flag.NewFlagSet("thumbnail", flag.ContinueOnError)
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) < 2 {
+		return usagef("thumbnail requires remote path and local file")
+	}
+
+	remote, err := c.resolveRemote(args[0])
+	if err != nil {
+		return err
+	}
+	fi, err := c.objectFromPath(remote)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir {
+		return fmt.Errorf("%s is a directory", fi.FullPath)
+	}
+	if fi.Info != nil && fi.Info.ThumbFormat == 0 {
+		return fmt.Errorf("%s has no thumbnail", fi.FullPath)
+	}
+
+	if args[1] == "-" {
+		rawStdout = true
+		w := bufio.NewWriter(os.Stdout)
+		if err := c.getThumb(fi, w); err != nil {
+			return err
+		}
+		return w.Flush()
+	}
+
+	target, err := filepath.Abs(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid local path: %w", err)
+	}
+	f, err := os.Create(target + partSuffix)
+	if err != nil {
+		return fmt.Errorf("failed to create local file: %w", err)
+	}
+	err = c.getThumb(fi, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(target+partSuffix, target)
+	}
+	if err != nil {
+		os.Remove(target + partSuffix)
+		return err
+	}
+
+	st, err := os.Stat(target)
+	if err != nil {
+		return err
+	}
+	result := map[string]interface{}{
+		"path":   fi.FullPath,
+		"target": target,
+		"size":   st.Size(),
+	}
+	if info := fi.Info; info != nil {
+		result["format"] = info.ThumbFormat
+		result["format_name"] = mtp.OFC_names[int(info.ThumbFormat)]
+		result["width"] = info.ThumbPixWidth
+		result["height"] = info.ThumbPixHeight
+	}
+	printJSON(result)
+
+	printDone("MTPX_THUMBNAIL_DONE")
+	return nil
+}
+
+// getThumb writes the thumbnail of fi to w with the GetThumb operation
+func (c *CLI) getThumb(fi *mtpx.FileInfo, w io.Writer) error {
+	req := mtp.Container{
+		Code:  mtp.OC_GetThumb,
+		Param: []uint32{fi.ObjectId},
+	}
+	var rep mtp.Container
+	err := c.device.RunTransaction(&req, &rep, w, nil, 0, mtp.EmptyProgressFunc)
+	var rc mtp.RCError
+	if errors.As(err, &rc) && rc == mtp.RC_NoThumbnailPresent {
+		return fmt.Errorf("%s has no thumbnail", fi.FullPath)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the thumbnail of %s: %w", fi.FullPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ganeshrvel/go-mtpfs/mtp"
+)
+
+// errTransferTimeout ends a file transfer that ran longer than
+// --transfer-timeout
+var errTransferTimeout = errors.New("transfer timed out")
+
+func validateTimeouts(op, transfer time.Duration) error {
+	if op < time.Millisecond {
+		return usagef("invalid --timeout %s: must be at least 1ms", op)
+	}
+	if transfer < 0 {
+		return usagef("invalid --transfer-timeout %s: must not be negative", transfer)
+	}
+	return nil
+}
+
+// setTimeout applies --timeout to dev. It bounds every USB transfer of an MTP
+// operation, so a device that stops answering fails the operation instead of
+// blocking it forever.
+func setTimeout(dev *mtp.Device) {
+	dev.Timeout = int(opTimeout.Milliseconds())
+}
+
+// checkTransfer is what the progress callback of a file transfer that began
+// at start returns: errCanceled once the process was interrupted, and an
+// errTransferTimeout once the transfer ran past --transfer-timeout. Either
+// aborts the running MTP operation.
+func checkTransfer(start time.Time) error {
+	if err := canceled(); err != nil {
+		return err
+	}
+	if *transferTimeout > 0 && time.Since(start) > *transferTimeout {
+		return fmt.Errorf("%w after %s", errTransferTimeout, *transferTimeout)
+	}
+	return nil
+}
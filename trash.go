@@ -0,0 +1,231 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path"
+	"strings"
+
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// defaultTrashDir is the trash of the trash command without --trash
+const defaultTrashDir = "/.mtpx-trash"
+
+// handleTrash dispatches trash list, empty and restore
+func (c *CLI) handleTrash(args []string) error {
+	if len(args) < 1 {
+		return usagef("trash requires list, empty or restore")
+	}
+	switch args[0] {
+	case "list":
+		return c.handleTrashList(args[1:])
+	case "empty":
+		return c.handleTrashEmpty(args[1:])
+	case "restore":
+		return c.handleTrashRestore(args[1:])
+	}
+	return usagef("unknown trash action %q: must be list, empty or restore", args[0])
+}
+
+// handleTrashList prints the files in the trash under the paths they were
+// deleted from
+func (c *CLI) handleTrashList(args []string) error {
+	fs := flag.NewFlagSet("trash list", flag.ContinueOnError)
+	trash := fs.String("trash", defaultTrashDir, "remote trash directory")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	root := path.Clean(remotePath(*trash))
+	if !c.remoteExists(root) {
+		printDone("MTPX_TRASH_DONE")
+		return nil
+	}
+	_, _, _, err := c.walk(root, true, false, false,
+		func(objectId uint32, fi *mtpx.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if fi.IsDir {
+				return nil
+			}
+			return printJSON(map[string]interface{}{
+				"path":    strings.TrimPrefix(fi.FullPath, root),
+				"trashed": fi.FullPath,
+				"size":    fi.Size,
+				"mtime":   fi.ModTime,
+			})
+		})
+	if err != nil {
+		return err
+	}
+
+	printDone("MTPX_TRASH_DONE")
+	return nil
+}
+
+// handleTrashEmpty deletes the trash with everything in it, after the same
+// confirmation as delete
+func (c *CLI) handleTrashEmpty(args []string) error {
+	fs := flag.NewFlagSet("trash empty", flag.ContinueOnError)
+	trash := fs.String("trash", defaultTrashDir, "remote trash directory")
+	yes := fs.Bool("yes", false, "never ask for confirmation")
+	fs.BoolVar(yes, "y", false, "same as --yes")
+	if _, err := parseArgs(fs, args); err != nil {
+		return err
+	}
+
+	root := path.Clean(remotePath(*trash))
+	if root == "/" {
+		return usagef("the trash can't be the storage root")
+	}
+	if !c.remoteExists(root) {
+		printDone("MTPX_TRASH_DONE")
+		return nil
+	}
+
+	var paths []string
+	var size int64
+	_, _, _, err := c.walk(root, true, false, false,
+		func(objectId uint32, fi *mtpx.FileInfo, err error) error {
+			if err == nil && !fi.IsDir {
+				paths = append(paths, fi.FullPath)
+				size += fi.Size
+			}
+			return err
+		})
+	if err != nil {
+		return err
+	}
+	if !newPrompter(!*dryRun, *yes).confirmDeletion("delete", paths, size, 0) {
+		printDeclined(len(paths))
+		printDone("MTPX_TRASH_DONE")
+		return nil
+	}
+
+	// confirmed above, deleteTree needn't ask again
+	if err := c.deleteTree(root, true); err != nil {
+		return err
+	}
+	printDone("MTPX_TRASH_DONE")
+	return nil
+}
+
+// handleTrashRestore moves trashed objects back to the paths they were
+// deleted from. A directory whose original path exists again is restored
+// object by object, other objects never replace existing ones.
+func (c *CLI) handleTrashRestore(args []string) error {
+	fs := flag.NewFlagSet("trash restore", flag.ContinueOnError)
+	trash := fs.String("trash", defaultTrashDir, "remote trash directory")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) < 1 {
+		return usagef("trash restore requires the original path of a trashed object")
+	}
+
+	root := path.Clean(remotePath(*trash))
+	for _, arg := range args {
+		orig := path.Clean(remotePath(arg))
+		fi, err := c.objectFromPath(path.Join(root, orig))
+		if err != nil {
+			return fmt.Errorf("%s is not in the trash: %w", orig, err)
+		}
+		if err := c.restoreObject(fi, orig); err != nil {
+			return err
+		}
+	}
+
+	printDone("MTPX_TRASH_DONE")
+	return nil
+}
+
+// restoreObject moves the trashed object fi back to orig
+func (c *CLI) restoreObject(fi *mtpx.FileInfo, orig string) error {
+	existing, err := c.fileExists([]mtpx.FileProp{{FullPath: orig}})
+	if err != nil {
+		return err
+	}
+	if len(existing) == 1 && existing[0].Exists {
+		if !fi.IsDir || !existing[0].FileInfo.IsDir {
+			return printJSON(map[string]interface{}{
+				"path":    orig,
+				"skipped": true,
+				"reason":  "exists",
+			})
+		}
+		children, err := c.children(fi)
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			if err := c.restoreObject(child, path.Join(orig, child.Name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if *dryRun {
+		return printPlanned("restore", map[string]interface{}{"source": fi.FullPath, "target": orig})
+	}
+	if err := c.makeRemoteDir(path.Dir(orig)); err != nil {
+		return err
+	}
+	if err := c.moveObject(fi.ObjectId, path.Dir(orig)); err != nil {
+		return fmt.Errorf("failed to restore %s: %w", orig, err)
+	}
+	return printJSON(map[string]interface{}{
+		"event": "restored",
+		"path":  orig,
+		"from":  fi.FullPath,
+	})
+}
+
+// moveToTrash moves fi into trash at the path it has on the storage, so trash
+// restore knows where it came from. An object trashed from the same path
+// before keeps its place and fi gets a conflict name. The object cache is
+// dropped, as moves keep the object handles it is checked against.
+func (c *CLI) moveToTrash(fi *mtpx.FileInfo, trash string) error {
+	trash = path.Clean(trash)
+	if fi.FullPath == trash || strings.HasPrefix(fi.FullPath, trash+"/") {
+		return fmt.Errorf("%s is in the trash already", fi.FullPath)
+	}
+	dir := path.Dir(path.Join(trash, fi.FullPath))
+
+	if *dryRun {
+		return printPlanned("trash", map[string]interface{}{"path": fi.FullPath, "target": path.Join(dir, fi.Name)})
+	}
+	if err := c.makeRemoteDir(dir); err != nil {
+		return err
+	}
+
+	// the object is renamed where it is, since devices refuse to move an
+	// object next to one of the same name
+	name := fi.Name
+	if c.remoteExists(path.Join(dir, name)) {
+		var err error
+		if name, err = c.freeRemoteName(dir, name); err != nil {
+			return err
+		}
+		if _, err := mtpx.RenameFile(c.device, c.storage, mtpx.FileProp{ObjectId: fi.ObjectId}, name); err != nil {
+			return fmt.Errorf("failed to rename %s for the trash: %w", fi.FullPath, err)
+		}
+	}
+	if err := c.moveObject(fi.ObjectId, dir); err != nil {
+		if name != fi.Name {
+			mtpx.RenameFile(c.device, c.storage, mtpx.FileProp{ObjectId: fi.ObjectId}, fi.Name)
+		}
+		return fmt.Errorf("failed to move %s to the trash: %w", fi.FullPath, err)
+	}
+	c.dropCache()
+
+	return printJSON(map[string]interface{}{
+		"event":   "trashed",
+		"path":    fi.FullPath,
+		"trashed": path.Join(dir, name),
+		"size":    fi.Size,
+	})
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"flag"
+	"path"
+
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// treeNode is an object in the hierarchy printed by tree
+type treeNode struct {
+	fi       *mtpx.FileInfo
+	children []*treeNode
+}
+
+// entry returns the node as nested JSON. Directories always have children,
+// so an empty directory can be told apart from a file.
+func (n *treeNode) entry() map[string]interface{} {
+	entry := map[string]interface{}{
+		"name": n.fi.Name,
+		"path": n.fi.FullPath,
+		"size": n.fi.Size,
+	}
+	if n.fi.IsDir {
+		children := make([]interface{}, 0, len(n.children))
+		for _, child := range n.children {
+			children = append(children, child.entry())
+		}
+		entry["children"] = children
+	}
+	return entry
+}
+
+// handleTree prints the hierarchy below a remote path as a single nested
+// entry, which human output draws as an indented tree
+func (c *CLI) handleTree(args []string) error {
+	fs := flag.NewFlagSet("tree", flag.ContinueOnError)
+	maxDepth := fs.Int("max-depth", -1, "only descend this many levels below the path (-1 is unlimited)")
+	skipHidden := fs.Bool("skip-hidden", false, "leave out hidden objects and their contents")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) < 1 {
+		return usagef("tree requires a remote path")
+	}
+	if *maxDepth < -1 {
+		return usagef("invalid --max-depth %d: must be -1 or more", *maxDepth)
+	}
+
+	root, err := c.resolveRemote(args[0])
+	if err != nil {
+		return err
+	}
+
+	fi, err := c.objectFromPath(root)
+	if err != nil {
+		return err
+	}
+	top := &treeNode{fi: fi}
+	if fi.IsDir && *maxDepth != 0 {
+		if err := c.buildTree(top, *maxDepth, *skipHidden); err != nil {
+			return err
+		}
+	}
+
+	printJSON(top.entry())
+	printDone("MTPX_TREE_DONE")
+	return nil
+}
+
+// buildTree adds the objects below top. Without a depth limit that is one
+// recursive Walk; with one, each directory is listed on its own so the walk
+// stops at maxDepth instead of visiting the whole subtree.
+func (c *CLI) buildTree(top *treeNode, maxDepth int, skipHidden bool) error {
+	hidden := newHiddenFilter(c)
+
+	if maxDepth < 0 {
+		dirs := map[string]*treeNode{path.Clean(top.fi.FullPath): top}
+		_, _, _, err := c.walk(top.fi.FullPath, true, true, skipHidden,
+			func(objectId uint32, fi *mtpx.FileInfo, err error) error {
+				if err != nil {
+					return nil
+				}
+				// Walk lists a directory before its contents, so a missing
+				// parent was left out as hidden
+				parent := dirs[path.Dir(path.Clean(fi.FullPath))]
+				if parent == nil || (skipHidden && hidden.skip(fi)) {
+					return nil
+				}
+				node := &treeNode{fi: fi}
+				parent.children = append(parent.children, node)
+				if fi.IsDir {
+					dirs[path.Clean(fi.FullPath)] = node
+				}
+				return nil
+			})
+		return err
+	}
+
+	_, _, _, err := c.walk(top.fi.FullPath, false, true, skipHidden,
+		func(objectId uint32, fi *mtpx.FileInfo, err error) error {
+			if err != nil || (skipHidden && hidden.skip(fi)) {
+				return nil
+			}
+			top.children = append(top.children, &treeNode{fi: fi})
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+	if maxDepth == 1 {
+		return nil
+	}
+	for _, child := range top.children {
+		if child.fi.IsDir {
+			if err := c.buildTree(child, maxDepth-1, skipHidden); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
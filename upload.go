@@ -0,0 +1,489 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/ganeshrvel/go-mtpfs/mtp"
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// uploader copies local files and directory trees to the device
+type uploader struct {
+	cli       *CLI
+	chunkSize int
+
+	// limiter caps the transfer rate, nil means unlimited
+	limiter *rateLimiter
+
+	// verify names the hash algorithm that uploaded files are checked with
+	// against a read back of the remote object, empty for none
+	verify string
+
+	// concurrency is the number of transfer queue workers
+	concurrency int
+
+	// onConflict is the --on-conflict policy for existing remote files,
+	// empty overwrites them
+	onConflict string
+
+	// filter leaves files of a tree upload out, nil keeps all
+	filter *pathFilter
+
+	// ignoreSpace uploads trees that don't fit in the free space of the
+	// storage, warning instead of failing
+	ignoreSpace bool
+
+	// skipExisting leaves remote files alone that match the local file in
+	// size and modification time
+	skipExisting bool
+}
+
+func (u *uploader) uploadFile(localFile, remoteDir string) error {
+	return u.uploadFileNamed(localFile, remoteDir, filepath.Base(localFile))
+}
+
+// uploadFileNamed uploads localFile into remoteDir as name
+func (u *uploader) uploadFileNamed(localFile, remoteDir, name string) error {
+	if u.skipExisting {
+		var skip bool
+		err := u.cli.withDevice(func() (err error) {
+			skip, err = u.unchanged(localFile, path.Join(remoteDir, name))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if skip {
+			transfers.skip()
+			return printJSON(map[string]interface{}{
+				"path":    localFile,
+				"target":  path.Join(remoteDir, name),
+				"skipped": true,
+				"reason":  "unchanged",
+			})
+		}
+	}
+	if u.onConflict != "" && u.onConflict != conflictOverwrite {
+		var skip bool
+		err := u.cli.withDevice(func() (err error) {
+			name, skip, err = u.resolveConflict(localFile, remoteDir, name)
+			return err
+		})
+		if err != nil || skip {
+			return err
+		}
+	}
+
+	remote := path.Join(remoteDir, name)
+	if *dryRun {
+		return printPlanned("upload", map[string]interface{}{
+			"source": localFile,
+			"target": remote,
+		})
+	}
+
+	// a transfer that fails with the session is retried on a new one
+	err := u.cli.retry(localFile, func(attempt int) error {
+		return u.cli.withDevice(func() error {
+			// UploadFiles sends under the local name, temporary names
+			// need uploadFileAs
+			switch {
+			case u.chunkSize > 0:
+				return u.uploadFileChunked(localFile, remoteDir, name)
+			case name != filepath.Base(localFile), u.cli.tempName(name) != name:
+				return u.uploadFileAs(localFile, remoteDir, name)
+			}
+			return u.uploadFileWhole(localFile, remoteDir)
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	if u.verify != "" {
+		var fi *mtpx.FileInfo
+		err = u.cli.withDevice(func() (err error) {
+			fi, err = u.cli.objectFromPath(remote)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to look up %s for verification: %w", remote, err)
+		}
+		if err := u.cli.verifyTransfer(localFile, fi, u.verify); err != nil {
+			return err
+		}
+	}
+	if info, err := os.Stat(localFile); err == nil {
+		fileCompleted(directionUpload, localFile, remote, info.Size())
+	}
+	return nil
+}
+
+// unchanged reports whether remote exists with the size and modification time
+// of localFile. An upload cut off by a crash leaves an object that lacks the
+// modification time, which is set once the transfer completes.
+func (u *uploader) unchanged(localFile, remote string) (bool, error) {
+	existing, err := u.cli.objectFromPath(remote)
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	st, err := os.Stat(localFile)
+	if err != nil {
+		return false, fmt.Errorf("invalid local file path: %w", err)
+	}
+	return !existing.IsDir && existing.Size == st.Size() &&
+		st.ModTime().Truncate(time.Second).Equal(existing.ModTime.Truncate(time.Second)), nil
+}
+
+// resolveConflict applies --on-conflict when remoteDir already holds a file
+// named name. It returns the name to upload localFile as, or skip after
+// reporting why.
+func (u *uploader) resolveConflict(localFile, remoteDir, name string) (_ string, skip bool, err error) {
+	c := u.cli
+	remote := path.Join(remoteDir, name)
+
+	existing, err := c.objectFromPath(remote)
+	if isNotFound(err) {
+		return name, false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	if u.onConflict == conflictRename {
+		name, err = c.freeRemoteName(remoteDir, name)
+		return name, false, err
+	}
+
+	info, err := os.Stat(localFile)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid local file path: %w", err)
+	}
+	if reason := checkConflict(u.onConflict, info.ModTime(), existing.ModTime); reason != "" {
+		return "", true, printConflictSkip(localFile, remote, reason)
+	}
+	return name, false, nil
+}
+
+// uploadFileWhole sends localFile in one transfer with UploadFiles
+func (u *uploader) uploadFileWhole(localFile, remoteDir string) error {
+	c := u.cli
+	handler := &ProgressHandler{
+		sourcePath: localFile,
+		targetDir:  remoteDir,
+		limiter:    u.limiter,
+	}
+
+	_, _, _, err := mtpx.UploadFiles(c.device, c.storage, []string{localFile}, remoteDir, false,
+		func(fi *os.FileInfo, path string, err error) error { return nil },
+		handler.handleUploadProgress)
+	if err != nil {
+		// an interrupted upload leaves a partial object behind
+		if errors.Is(err, errCanceled) && handler.objectId != 0 {
+			c.device.DeleteObject(handler.objectId)
+		}
+		return err
+	}
+
+	if info, err := os.Stat(localFile); err == nil && handler.objectId != 0 {
+		c.setModTime(handler.objectId, info.ModTime())
+	}
+	return nil
+}
+
+// uploadFileAs sends localFile under another name, which UploadFiles can't
+func (u *uploader) uploadFileAs(localFile, remoteDir, name string) error {
+	f, err := os.Open(localFile)
+	if err != nil {
+		return fmt.Errorf("invalid local file path: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return u.uploadStream(f, info.Size(), localFile, name, remoteDir, info.ModTime())
+}
+
+// uploadFileChunked creates an empty object named name and fills it with
+// Android partial object transfers of chunkSize bytes. An existing file of the
+// same name is replaced, as UploadFiles does, once the upload is complete.
+func (u *uploader) uploadFileChunked(localFile, remoteDir, name string) error {
+	c := u.cli
+
+	f, err := os.Open(localFile)
+	if err != nil {
+		return fmt.Errorf("invalid local file path: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := info.Size()
+
+	tempName := c.tempName(name)
+	handle, err := u.createObject(remoteDir, tempName, 0, info.ModTime())
+	if err != nil {
+		return err
+	}
+
+	remotePath := path.Join(remoteDir, name)
+	progress.begin(handle)
+	if err := u.sendChunks(handle, f, name, remotePath, size); err != nil {
+		c.device.DeleteObject(handle)
+		return fmt.Errorf("failed to upload %s: %w", localFile, err)
+	}
+
+	c.setModTime(handle, info.ModTime())
+	if err := c.commitUpload(handle, remoteDir, tempName, name, size); err != nil {
+		return err
+	}
+	progress.complete(handle, name, localFile, remotePath, size)
+	return nil
+}
+
+// uploadStream uploads size bytes from r as remoteDir/name. An existing file of
+// the same name is replaced once the upload is complete. source names the
+// stream in the transfer summary.
+func (u *uploader) uploadStream(r io.Reader, size int64, source, name, remoteDir string, modTime time.Time) error {
+	c := u.cli
+
+	if *dryRun {
+		return printPlanned("upload", map[string]interface{}{
+			"source": source,
+			"target": path.Join(remoteDir, name),
+			"size":   size,
+		})
+	}
+
+	tempName := c.tempName(name)
+	handle, err := u.createObject(remoteDir, tempName, size, modTime)
+	if err != nil {
+		return err
+	}
+
+	remotePath := path.Join(remoteDir, name)
+	if u.limiter != nil {
+		r = &throttledReader{r: r, l: u.limiter}
+	}
+	progress.begin(handle)
+	err = c.device.SendObject(r, size, func(sent int64) error {
+		return progress.update(handle, name, remotePath, sent, size)
+	})
+	if err != nil {
+		c.device.DeleteObject(handle)
+		return fmt.Errorf("failed to upload %s: %w", remotePath, err)
+	}
+
+	c.setModTime(handle, modTime)
+	if err := c.commitUpload(handle, remoteDir, tempName, name, size); err != nil {
+		return err
+	}
+	progress.complete(handle, name, source, remotePath, size)
+	return nil
+}
+
+// setModTime sets the DateModified property of an uploaded object to modTime.
+// The object info already carries the time, but Android ignores it and keeps
+// the upload time. Devices that don't support the property, or refuse to set
+// it, are warned about once and then left alone.
+func (c *CLI) setModTime(handle uint32, modTime time.Time) {
+	if c.noDateModified || modTime.IsZero() {
+		return
+	}
+
+	var err error
+	if !c.supportsProp(mtp.OFC_Undefined, mtp.OPC_DateModified) {
+		err = fmt.Errorf("property not supported")
+	} else {
+		err = c.device.SetObjectPropValue(handle, mtp.OPC_DateModified,
+			&mtp.StringValue{Value: modTime.Format("20060102T150405")})
+	}
+	if err != nil {
+		c.noDateModified = true
+		log.Printf("warning: the device doesn't accept modification times (%v), uploaded files keep the upload time", err)
+	}
+}
+
+// createObject creates the object info for remoteDir/name, creating remoteDir
+// and replacing an existing file of the same name as UploadFiles does
+func (u *uploader) createObject(remoteDir, name string, size int64, modTime time.Time) (uint32, error) {
+	c := u.cli
+
+	parentId, err := mtpx.MakeDirectory(c.device, c.storage, remoteDir)
+	if err != nil {
+		return 0, err
+	}
+
+	existing, err := mtpx.GetObjectFromParentIdAndFilename(c.device, c.storage, parentId, name)
+	switch err.(type) {
+	case nil:
+		if err := c.device.DeleteObject(existing.ObjectId); err != nil {
+			return 0, fmt.Errorf("failed to replace %s: %w", name, err)
+		}
+	case mtpx.FileNotFoundError:
+	default:
+		return 0, err
+	}
+
+	// sizes beyond 4 GiB don't fit the object info and are sent as 0xFFFFFFFF
+	obj := mtp.ObjectInfo{
+		StorageID:        c.storage,
+		ObjectFormat:     mtp.OFC_Undefined,
+		ParentObject:     parentId,
+		Filename:         name,
+		CompressedSize:   uint32(min(size, 0xFFFFFFFF)),
+		ModificationDate: modTime,
+	}
+	_, _, handle, err := c.device.SendObjectInfo(c.storage, parentId, &obj)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create %s: %w", name, err)
+	}
+	return handle, nil
+}
+
+func (u *uploader) sendChunks(handle uint32, r io.Reader, name, remotePath string, size int64) error {
+	dev := u.cli.device
+
+	if err := dev.SendObject(&bytes.Buffer{}, 0, mtp.EmptyProgressFunc); err != nil {
+		return err
+	}
+	if err := dev.AndroidBeginEditObject(handle); err != nil {
+		return err
+	}
+
+	if u.limiter != nil {
+		r = &throttledReader{r: r, l: u.limiter}
+	}
+
+	var offset int64
+	for offset < size {
+		n := min(int64(u.chunkSize), size-offset)
+		if err := dev.AndroidSendPartialObject(handle, offset, uint32(n), io.LimitReader(r, n)); err != nil {
+			return err
+		}
+		offset += n
+		if err := progress.update(handle, name, remotePath, offset, size); err != nil {
+			return err
+		}
+	}
+
+	return dev.AndroidEndEditObject(handle)
+}
+
+// uploadTree mirrors the local directory localDir into remoteDir, creating
+// each remote directory before uploading the files below it
+func (u *uploader) uploadTree(localDir, remoteDir string) error {
+	c := u.cli
+
+	info, err := os.Stat(localDir)
+	if err != nil {
+		return fmt.Errorf("invalid local directory: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("not a directory: %s", localDir)
+	}
+
+	remoteRoot := path.Join("/", remoteDir, filepath.Base(localDir))
+
+	totalFiles, totalSize, err := countTree(localDir, u.filter)
+	if err != nil {
+		return err
+	}
+	// with --skip-existing part of the tree may be on the device already
+	if err := c.checkFreeSpace(totalSize, u.ignoreSpace || u.skipExisting); err != nil {
+		return err
+	}
+
+	q := newTransferQueue(c, u.concurrency, true)
+	q.setTotal(totalFiles, totalSize)
+
+	var dirs int64
+	err = filepath.Walk(localDir, func(localPath string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if fi.Mode()&os.ModeSymlink != 0 {
+			log.Printf("warning: skipping symlink %s", localPath)
+			return nil
+		}
+
+		rel, err := filepath.Rel(localDir, localPath)
+		if err != nil {
+			return err
+		}
+		if rel != "." && u.filter.skip(filepath.ToSlash(rel), fi.IsDir()) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		remotePath := path.Join(remoteRoot, filepath.ToSlash(rel))
+
+		if fi.IsDir() {
+			if err := c.withDevice(func() error { return c.makeRemoteDir(remotePath) }); err != nil {
+				return err
+			}
+			dirs++
+			return nil
+		}
+
+		if !fi.Mode().IsRegular() {
+			log.Printf("warning: skipping non-regular file %s", localPath)
+			return nil
+		}
+
+		return q.add(fi.Size(), func() error { return u.uploadFile(localPath, path.Dir(remotePath)) })
+	})
+	if qerr := q.wait(); err == nil {
+		err = qerr
+	}
+	if err != nil {
+		return err
+	}
+
+	files, size := q.done()
+
+	return printJSON(map[string]interface{}{
+		"files":       files,
+		"directories": dirs,
+		"size":        size,
+	})
+}
+
+// countTree counts the regular files below localDir that filter keeps and
+// their total size, for aggregate progress
+func countTree(localDir string, filter *pathFilter) (files, size int64, err error) {
+	err = filepath.Walk(localDir, func(localPath string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if rel, err := filepath.Rel(localDir, localPath); err == nil && rel != "." && filter.skip(filepath.ToSlash(rel), fi.IsDir()) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if fi.Mode().IsRegular() {
+			files++
+			size += fi.Size()
+		}
+		return nil
+	})
+	return files, size, err
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// mtpClients lists the running processes named in mtpClientNames
+func mtpClients() []mtpClient {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	var clients []mtpClient
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		comm, err := os.ReadFile(filepath.Join("/proc", e.Name(), "comm"))
+		if err != nil {
+			continue
+		}
+		if name := strings.TrimSpace(string(comm)); isMTPClient(name) {
+			clients = append(clients, mtpClient{Pid: pid, Name: name})
+		}
+	}
+	return clients
+}
+
+// kernelDriverName returns the name of the kernel driver bound to the
+// interface of m, read from sysfs, or "" when it can't be told
+func kernelDriverName(m *mtpInterface) string {
+	devices, err := filepath.Glob("/sys/bus/usb/devices/*")
+	if err != nil {
+		return ""
+	}
+	for _, dev := range devices {
+		if readSysfsInt(filepath.Join(dev, "busnum")) != int(m.bus) ||
+			readSysfsInt(filepath.Join(dev, "devnum")) != int(m.address) {
+			continue
+		}
+		iface := fmt.Sprintf("%s:%d.%d", dev, m.config, m.iface)
+		driver, err := os.Readlink(filepath.Join(iface, "driver"))
+		if err != nil {
+			return ""
+		}
+		return filepath.Base(driver)
+	}
+	return ""
+}
+
+func readSysfsInt(file string) int {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return -1
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// udevRuleDirs are searched by udevRuleFor, in udev's order of precedence
+var udevRuleDirs = []string{"/etc/udev/rules.d", "/run/udev/rules.d", "/usr/lib/udev/rules.d", "/lib/udev/rules.d"}
+
+// udevRuleFor returns the first udev rules file that names the USB vendor
+// id, such as libmtp's 69-libmtp.rules, or ""
+func udevRuleFor(vendor uint16) string {
+	id := fmt.Sprintf("%04x", vendor)
+	for _, dir := range udevRuleDirs {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.rules"))
+		for _, file := range files {
+			b, err := os.ReadFile(file)
+			if err != nil {
+				continue
+			}
+			if strings.Contains(strings.ToLower(string(b)), `"`+id+`"`) {
+				return file
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,44 @@
+//go:build !linux
+
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// mtpClients lists the running processes named in mtpClientNames, as ps
+// reports them. Without ps, such as on Windows, it finds none.
+func mtpClients() []mtpClient {
+	out, err := exec.Command("ps", "-axo", "pid=,comm=").Output()
+	if err != nil {
+		return nil
+	}
+	var clients []mtpClient
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		pid, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		if name := filepath.Base(strings.TrimSpace(fields[1])); isMTPClient(name) {
+			clients = append(clients, mtpClient{Pid: pid, Name: name})
+		}
+	}
+	return clients
+}
+
+// kernelDriverName can only tell the driver on Linux
+func kernelDriverName(m *mtpInterface) string {
+	return ""
+}
+
+// udevRuleFor finds nothing, udev is Linux only
+func udevRuleFor(vendor uint16) string {
+	return ""
+}
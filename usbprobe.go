@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/ganeshrvel/usb"
+)
+
+// mtpInterface is the MTP interface of a connected USB device, as found by
+// opening the device and claiming the interface. The errors are libusb's:
+// usb.ERROR_ACCESS when the user may not open the device, usb.ERROR_BUSY
+// when a kernel driver or another program holds the interface.
+type mtpInterface struct {
+	bus, address  uint8
+	vendor        uint16
+	product       uint16
+	config, iface byte
+
+	openErr, claimErr error
+
+	// kernelDriver is set when a kernel driver is bound to the interface,
+	// detached names the driver detached from it by a probe with detach
+	kernelDriver bool
+	detached     string
+}
+
+func (m *mtpInterface) vidPid() string {
+	return fmt.Sprintf("%04x:%04x", m.vendor, m.product)
+}
+
+// probeMTPInterfaces opens every USB device with an interface that looks
+// like MTP to mtp.FindDevices, checks it for a kernel driver and claims it,
+// releasing it right away. mtp.Device.Open ignores a failed claim, which
+// leaves the operations that follow to fail without saying why. With detach
+// a kernel driver is detached from the interface before claiming it, which
+// lasts until the device is reconnected.
+func probeMTPInterfaces(ctx *usb.Context, detach bool) ([]*mtpInterface, error) {
+	l, err := ctx.GetDeviceList()
+	if err != nil {
+		return nil, err
+	}
+	if len(l) == 0 {
+		return nil, nil
+	}
+	defer l.Done()
+
+	var found []*mtpInterface
+	for _, d := range l {
+		m := mtpInterfaceOf(d)
+		if m == nil {
+			continue
+		}
+		found = append(found, m)
+
+		h, err := d.Open()
+		if err != nil {
+			m.openErr = err
+			continue
+		}
+		if active, err := h.KernelDriverActive(m.iface); err == nil && active {
+			m.kernelDriver = true
+			if detach {
+				driver := kernelDriverName(m)
+				if err := h.DetachKernelDriver(m.iface); err == nil {
+					m.kernelDriver, m.detached = false, driver
+					if m.detached == "" {
+						m.detached = "kernel driver"
+					}
+				}
+			}
+		}
+		if err := h.ClaimInterface(m.iface); err != nil {
+			m.claimErr = err
+		} else {
+			h.ReleaseInterface(m.iface)
+		}
+		h.Close()
+	}
+	return found, nil
+}
+
+// mtpInterfaceOf returns the first interface of d with a bulk in, a bulk out
+// and an interrupt endpoint, which is how mtp.FindDevices tells MTP devices
+// apart, or nil
+func mtpInterfaceOf(d *usb.Device) *mtpInterface {
+	dd, err := d.GetDeviceDescriptor()
+	if err != nil {
+		return nil
+	}
+	for i := byte(0); i < dd.NumConfigurations; i++ {
+		cd, err := d.GetConfigDescriptor(i)
+		if err != nil {
+			return nil
+		}
+		for _, iface := range cd.Interfaces {
+			for _, a := range iface.AltSetting {
+				if len(a.EndPoints) != 3 {
+					continue
+				}
+				var bulkIn, bulkOut, event bool
+				for _, ep := range a.EndPoints {
+					switch {
+					case ep.Direction() == usb.ENDPOINT_IN && ep.TransferType() == usb.TRANSFER_TYPE_INTERRUPT:
+						event = true
+					case ep.Direction() == usb.ENDPOINT_IN && ep.TransferType() == usb.TRANSFER_TYPE_BULK:
+						bulkIn = true
+					case ep.Direction() == usb.ENDPOINT_OUT && ep.TransferType() == usb.TRANSFER_TYPE_BULK:
+						bulkOut = true
+					}
+				}
+				if bulkIn && bulkOut && event {
+					return &mtpInterface{
+						bus:     d.GetBusNumber(),
+						address: d.GetDeviceAddress(),
+						vendor:  dd.IdVendor,
+						product: dd.IdProduct,
+						config:  cd.ConfigurationValue,
+						iface:   a.InterfaceNumber,
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// mtpClient is a running program that is known to claim MTP devices
+type mtpClient struct {
+	Pid  int    `json:"pid"`
+	Name string `json:"name"`
+}
+
+func (c mtpClient) String() string {
+	return fmt.Sprintf("%s (pid %d)", c.Name, c.Pid)
+}
+
+// mtpClientNames are the process names of desktop services and tools that
+// claim MTP devices, some of them as soon as the device is plugged in
+var mtpClientNames = []string{
+	// GNOME and other GVfs desktops
+	"gvfsd-mtp", "gvfsd-gphoto2",
+	// KDE, whose MTP daemon runs inside kiod
+	"kiod5", "kiod6",
+	// FUSE file systems
+	"jmtpfs", "simple-mtpfs", "go-mtpfs", "mtpfs",
+	// macOS
+	"ptpcamerad", "Android File Transfer", "Android File Transfer Agent", "Image Capture",
+}
+
+func isMTPClient(name string) bool {
+	for _, n := range mtpClientNames {
+		if name == n {
+			return true
+		}
+	}
+	return false
+}
+
+// mtpClientHint suggests how to stop clients, or the programs that usually
+// hold devices on this system when none was found
+func mtpClientHint(clients []mtpClient) string {
+	if len(clients) == 0 {
+		switch runtime.GOOS {
+		case "linux":
+			return "unmount the device in the file manager, or stop gvfs with: pkill gvfsd-mtp"
+		case "darwin":
+			return "quit Android File Transfer and Image Capture, then run: killall ptpcamerad"
+		}
+		return "close other applications holding the device and reconnect it"
+	}
+	names := make([]string, 0, len(clients))
+	seen := map[string]bool{}
+	for _, c := range clients {
+		if !seen[c.Name] {
+			seen[c.Name] = true
+			names = append(names, fmt.Sprintf("%q", c.Name))
+		}
+	}
+	return fmt.Sprintf("unmount the device in the file manager or stop %s, for example with: killall %s",
+		strings.Join(names, ", "), strings.Join(names, " "))
+}
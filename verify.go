@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"time"
+
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+// Hash algorithms for --verify and hash
+const (
+	hashMD5    = "md5"
+	hashSHA1   = "sha1"
+	hashSHA256 = "sha256"
+)
+
+func validateVerify(algo string) error {
+	if algo == "" || algo == hashSHA256 {
+		return nil
+	}
+	return usagef("invalid --verify %q: must be %s", algo, hashSHA256)
+}
+
+func newHasher(algo string) (hash.Hash, error) {
+	switch algo {
+	case hashMD5:
+		return md5.New(), nil
+	case hashSHA1:
+		return sha1.New(), nil
+	case hashSHA256:
+		return sha256.New(), nil
+	}
+	return nil, usagef("invalid hash algorithm %q: must be %s, %s or %s", algo, hashMD5, hashSHA1, hashSHA256)
+}
+
+// localDigest hashes the local file at localPath
+func localDigest(localPath, algo string) ([]byte, error) {
+	h, err := newHasher(algo)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// remoteDigest streams the remote object through the hasher without storing
+// it anywhere
+func (c *CLI) remoteDigest(objectId uint32, algo string) ([]byte, error) {
+	h, err := newHasher(algo)
+	if err != nil {
+		return nil, err
+	}
+	err = c.withDevice(func() error {
+		start := time.Now()
+		return c.device.GetObject(objectId, h, func(sent int64) error { return checkTransfer(start) })
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// verifyTransfer compares the local file with a fresh read of the remote
+// object and reports the outcome. A mismatch is an error.
+func (c *CLI) verifyTransfer(localPath string, fi *mtpx.FileInfo, algo string) error {
+	local, err := localDigest(localPath, algo)
+	if err != nil {
+		return fmt.Errorf("failed to hash %s: %w", localPath, err)
+	}
+	remote, err := c.remoteDigest(fi.ObjectId, algo)
+	if err != nil {
+		return fmt.Errorf("failed to hash %s: %w", fi.FullPath, err)
+	}
+
+	if !bytes.Equal(local, remote) {
+		printJSON(map[string]interface{}{
+			"path":          fi.FullPath,
+			"local":         localPath,
+			"verified":      false,
+			"algo":          algo,
+			"local_digest":  hex.EncodeToString(local),
+			"remote_digest": hex.EncodeToString(remote),
+		})
+		return transferFailed(fmt.Errorf("%s checksum mismatch between %s and %s", algo, localPath, fi.FullPath))
+	}
+
+	return printJSON(map[string]interface{}{
+		"path":     fi.FullPath,
+		"local":    localPath,
+		"verified": true,
+		"algo":     algo,
+		"digest":   hex.EncodeToString(local),
+	})
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	mtpx "github.com/ganeshrvel/go-mtpx"
+)
+
+func (c *CLI) handleWatch(args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	interval := fs.Duration("interval", 10*time.Second, "time between polls of the remote directory, or of the local one with --push where inotify is missing")
+	skipExisting := fs.Bool("skip-existing", false, "only transfer files that appear after the watch started")
+	push := fs.Bool("push", false, "watch a local directory and upload its new and changed files: watch --push <local_dir> <remote_dir>")
+	debounce := fs.Duration("debounce", 2*time.Second, "with --push, wait until the local directory was quiet this long before uploading")
+	skipHidden := fs.Bool("skip-hidden", false, "with --push, leave hidden local files alone")
+	filters := addFilterFlags(fs)
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	if *push {
+		if len(args) < 2 {
+			return usagef("watch --push requires local dir and remote dir")
+		}
+		filter, err := filters.filter()
+		if err != nil {
+			return err
+		}
+		localDir, err := filepath.Abs(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid local path: %w", err)
+		}
+		s := &syncer{
+			cli:        c,
+			localDir:   localDir,
+			remoteDir:  remotePath(args[1]),
+			skipHidden: *skipHidden,
+			filter:     filter,
+		}
+		return s.watchPush(*interval, *debounce, *skipExisting)
+	}
+
+	if len(args) < 2 {
+		return usagef("watch requires remote dir and local target dir")
+	}
+
+	remoteDir := remotePath(args[0])
+	localDir, err := filepath.Abs(args[1])
+	if err != nil {
+		return fmt.Errorf("invalid target path: %w", err)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	d := &downloader{cli: c, limiter: newRateLimiter(0)}
+
+	// size of every object already handled, by object id
+	seen := map[uint32]int64{}
+	first := true
+
+	for {
+		err := c.pollWatch(d, remoteDir, localDir, seen, first && *skipExisting)
+		if err != nil {
+			printJSON(map[string]string{
+				"event": "error",
+				"error": err.Error(),
+			})
+			// the session goes stale when the device sleeps or is replugged
+			c.reconnectIfDead()
+		}
+		first = false
+
+		select {
+		case <-sig:
+			printDone("MTPX_WATCH_DONE")
+			return nil
+		case <-time.After(*interval):
+		}
+	}
+}
+
+// pollWatch walks remoteDir once and downloads every file that wasn't seen
+// before or changed size since. With seedOnly the files are only recorded.
+func (c *CLI) pollWatch(d *downloader, remoteDir, localDir string, seen map[uint32]int64, seedOnly bool) error {
+	_, _, _, err := c.walk(remoteDir, true, true, false,
+		func(objectId uint32, fi *mtpx.FileInfo, err error) error {
+			if err != nil || fi.IsDir {
+				return nil
+			}
+
+			if size, ok := seen[objectId]; ok && size == fi.Size {
+				return nil
+			}
+			if seedOnly {
+				seen[objectId] = fi.Size
+				return nil
+			}
+
+			printJSON(map[string]interface{}{
+				"event": "new_file",
+				"path":  fi.FullPath,
+				"size":  fi.Size,
+			})
+
+			localPath := localDir
+			rel := strings.TrimPrefix(fi.FullPath, remoteDir)
+			if rel == "" {
+				rel = fi.Name
+			}
+			for _, name := range strings.Split(strings.Trim(rel, "/"), "/") {
+				localPath = filepath.Join(localPath, d.localName(name))
+			}
+			if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+				return fmt.Errorf("failed to create local directory: %w", err)
+			}
+
+			if err := d.downloadFile(fi, localPath); err != nil {
+				return err
+			}
+			seen[objectId] = fi.Size
+			return nil
+		})
+
+	return err
+}
+
+// watchPush uploads files written below the local directory until
+// interrupted. Unless skipExisting is set it starts with a sync push, and
+// afterwards only pushes the paths the local watcher reports, once they were
+// quiet for debounce. Local deletes are not mirrored.
+func (s *syncer) watchPush(interval, debounce time.Duration, skipExisting bool) error {
+	c := s.cli
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	changed := make(chan string, 256)
+	stop, err := watchLocalTree(s.localDir, interval, changed)
+	if err != nil {
+		return fmt.Errorf("failed to watch %s: %w", s.localDir, err)
+	}
+	defer stop()
+
+	if !skipExisting {
+		if err := s.push(""); err != nil {
+			return err
+		}
+	}
+	// size and mtime of every local file as last pushed, so events for
+	// files that didn't change are ignored
+	pushed, err := s.localEntries()
+	if err != nil {
+		return err
+	}
+
+	pending := map[string]bool{}
+	var quiet <-chan time.Time
+	for {
+		select {
+		case <-sig:
+			printDone("MTPX_WATCH_DONE")
+			return nil
+		case p := <-changed:
+			pending[p] = true
+			quiet = time.After(debounce)
+		case <-quiet:
+			quiet = nil
+			if err := s.pushChanged(pending, pushed); err != nil {
+				printJSON(map[string]string{
+					"event": "error",
+					"error": err.Error(),
+				})
+				c.reconnectIfDead()
+			}
+			pending = map[string]bool{}
+		}
+	}
+}
+
+// pushChanged uploads the changed local paths, the files below changed
+// directories included, that differ from what was pushed last
+func (s *syncer) pushChanged(paths map[string]bool, pushed map[string]syncEntry) error {
+	c := s.cli
+	u := &uploader{cli: c, limiter: newRateLimiter(0)}
+
+	var files []string
+	for p := range paths {
+		filepath.Walk(p, func(localPath string, fi os.FileInfo, err error) error {
+			// gone again, or a temporary file of the program writing it
+			if err != nil {
+				return nil
+			}
+			rel, err := filepath.Rel(s.localDir, localPath)
+			if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+				return nil
+			}
+			rel = filepath.ToSlash(rel)
+			if s.skipWatched(rel, fi.IsDir()) {
+				if fi.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if fi.Mode().IsRegular() {
+				files = append(files, rel)
+			}
+			return nil
+		})
+	}
+	sort.Strings(files)
+
+	for _, rel := range files {
+		localFile := filepath.Join(s.localDir, filepath.FromSlash(rel))
+		fi, err := os.Stat(localFile)
+		if err != nil {
+			continue
+		}
+		l := syncEntry{size: fi.Size(), mtime: fi.ModTime()}
+		if prev, ok := pushed[rel]; ok && prev.size == l.size && prev.mtime.Equal(l.mtime) {
+			continue
+		}
+
+		remoteFile := path.Join(s.remoteDir, rel)
+		printJSON(map[string]interface{}{
+			"event":  "changed_file",
+			"path":   localFile,
+			"target": remoteFile,
+			"size":   l.size,
+		})
+		if err := c.withDevice(func() error { return c.makeRemoteDir(path.Dir(remoteFile)) }); err != nil {
+			return err
+		}
+		if err := u.uploadFile(localFile, path.Dir(remoteFile)); err != nil {
+			return err
+		}
+		pushed[rel] = l
+	}
+	return nil
+}
+
+// skipWatched applies --skip-hidden and the filters to a changed path, whose
+// parent directories were never visited on their own
+func (s *syncer) skipWatched(rel string, isDir bool) bool {
+	if s.skipHidden {
+		for _, part := range strings.Split(rel, "/") {
+			if strings.HasPrefix(part, ".") {
+				return true
+			}
+		}
+	}
+	return s.filter.skip(rel, isDir)
+}